@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/psp/fakepsp"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	successRate := flag.Float64("success-rate", 0.7, "share of unscripted charges approved, between 0 and 1")
+	latency := flag.Duration("latency", 50*time.Millisecond, "base latency added to every charge")
+	jitter := flag.Duration("jitter", 0, "maximum random latency added on top of -latency")
+	script := flag.String("script", "", `comma separated outcomes played before falling back to -success-rate, e.g. "decline:insufficient_funds,error:503,timeout,approve"`)
+	apiKey := flag.String("api-key", "", "bearer token required from clients, if set")
+	seed := flag.Int64("seed", 0, "random seed; zero uses the current time")
+	flag.Parse()
+
+	if *successRate < 0 || *successRate > 1 {
+		log.Fatalf("invalid -success-rate %v", *successRate)
+	}
+
+	outcomes, err := fakepsp.ParseScript(*script)
+	if err != nil {
+		log.Fatalf("invalid -script: %v", err)
+	}
+
+	server := fakepsp.New(fakepsp.Config{
+		SuccessRate: *successRate,
+		Latency:     *latency,
+		Jitter:      *jitter,
+		Script:      outcomes,
+		APIKey:      *apiKey,
+		Seed:        *seed,
+	})
+
+	log.Printf("fake PSP listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, server))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func deadLetters(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	letters := sqlite.NewOutboxRepository(db)
+
+	switch action, args := args[0], args[1:]; action {
+	case "list":
+		limit, err := listLimit(args)
+		if err != nil {
+			return err
+		}
+		return listDeadLetters(letters, limit)
+	case "show":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return showDeadLetter(letters, args[0])
+	case "replay":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := letters.Replay(args[0]); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		fmt.Printf("%s is back in the outbox\n", args[0])
+	case "discard":
+		if len(args) != 1 {
+			return errUsage
+		}
+		if err := letters.Discard(args[0]); err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		fmt.Printf("%s discarded\n", args[0])
+	default:
+		return errUsage
+	}
+
+	return nil
+}
+
+func listLimit(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 100, nil
+	case 1:
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit <= 0 {
+			return 0, fmt.Errorf("invalid number of dead letters %q", args[0])
+		}
+		return limit, nil
+	default:
+		return 0, errUsage
+	}
+}
+
+func listDeadLetters(letters outbox.DeadLetters, limit int) error {
+	found, err := letters.ListDeadLetters(limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tAGGREGATE\tATTEMPTS\tDEAD AT\tLAST ERROR")
+	for _, letter := range found {
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%d\t%s\t%s\n",
+			letter.ID,
+			letter.Type,
+			letter.Aggregate.Type,
+			letter.Aggregate.ID,
+			letter.Attempts,
+			letter.DeadAt.Format(time.RFC3339),
+			letter.LastError,
+		)
+	}
+
+	return w.Flush()
+}
+
+func showDeadLetter(letters outbox.DeadLetters, id string) error {
+	letter, err := letters.FindDeadLetter(id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		ID            string          `json:"id"`
+		Type          string          `json:"type"`
+		Version       int             `json:"version"`
+		OccurredAt    time.Time       `json:"occurred_at"`
+		Aggregate     string          `json:"aggregate"`
+		CorrelationID string          `json:"correlation_id"`
+		CausationID   string          `json:"causation_id"`
+		Attempts      int             `json:"attempts"`
+		LastError     string          `json:"last_error"`
+		DeadAt        time.Time       `json:"dead_at"`
+		Payload       json.RawMessage `json:"payload"`
+	}{
+		ID:            letter.ID,
+		Type:          string(letter.Type),
+		Version:       letter.Version,
+		OccurredAt:    letter.OccurredAt,
+		Aggregate:     letter.Aggregate.Type + "/" + letter.Aggregate.ID,
+		CorrelationID: letter.CorrelationID,
+		CausationID:   letter.CausationID,
+		Attempts:      letter.Attempts,
+		LastError:     letter.LastError,
+		DeadAt:        letter.DeadAt,
+		Payload:       letter.Payload,
+	})
+}
@@ -0,0 +1,69 @@
+// Command paymentsctl runs maintenance tasks against the payments database.
+// It reads the same configuration as the server.
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+const usage = `usage: paymentsctl [flags] <command> [arguments]
+
+commands:
+  migrate up                 apply pending migrations
+  migrate down [N|all]       roll back the last N (default 1) or all migrations
+  migrate status             list migrations and when they were applied
+  dead-letters list [N]      list the oldest N (default 100) dead lettered outbox events
+  dead-letters show ID       print a dead letter with its payload
+  dead-letters replay ID     put a dead letter back in the outbox
+  dead-letters discard ID    delete a dead letter for good`
+
+var errUsage = errors.New(usage)
+
+var commands = map[string]func(db *sql.DB, args []string) error{
+	"migrate":      migrate,
+	"dead-letters": deadLetters,
+}
+
+func main() {
+	log.SetFlags(0)
+
+	cfg, args, err := config.LoadArgs(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, usage)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		log.Fatalf("unknown command %q\n%s", args[0], usage)
+	}
+
+	if cfg.Storage.Backend != config.BackendSQLite {
+		log.Fatalf("%s needs the %s storage backend, not %s", args[0], config.BackendSQLite, cfg.Storage.Backend)
+	}
+
+	db, err := sqlite.Open(cfg.Storage.Path)
+	if err != nil {
+		log.Fatalf("error opening %s: %v", cfg.Storage.Path, err)
+	}
+	defer db.Close()
+
+	if err := command(db, args[1:]); err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func migrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch action, args := args[0], args[1:]; action {
+	case "up":
+		if len(args) != 0 {
+			return errUsage
+		}
+		if err := sqlite.RunMigrations(db); err != nil {
+			return err
+		}
+	case "down":
+		steps, err := rollbackSteps(args)
+		if err != nil {
+			return err
+		}
+		if err := sqlite.RollbackMigrations(db, steps); err != nil {
+			return err
+		}
+	case "status":
+		if len(args) != 0 {
+			return errUsage
+		}
+	default:
+		return errUsage
+	}
+
+	return printMigrations(db)
+}
+
+func rollbackSteps(args []string) (int, error) {
+	switch {
+	case len(args) == 0:
+		return 1, nil
+	case len(args) > 1:
+		return 0, errUsage
+	case args[0] == "all":
+		return -1, nil
+	}
+
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations %q", args[0])
+	}
+	return steps, nil
+}
+
+func printMigrations(db *sql.DB) error {
+	migrations, err := sqlite.Migrations(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, m := range migrations {
+		applied := "pending"
+		if m.Applied() {
+			applied = m.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, applied)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/psp"
+)
+
+// executors returns the gateway payments are charged through and the one
+// refunding them, which must be the same: a charge can only be refunded
+// where it was made.
+func executors(cfg config.Executor) (worker.PaymentExecutor, worker.RefundExecutor, error) {
+	switch cfg.Kind {
+	case config.ExecutorRandom:
+		return &worker.RandomPaymentExecutor{}, &worker.RandomRefundExecutor{}, nil
+	case config.ExecutorPSP:
+		executor := psp.NewHTTPExecutor(cfg.PSPURL, cfg.PSPAPIKey, cfg.Timeout)
+		return executor, executor.Refunds(), nil
+	}
+	return nil, nil, fmt.Errorf("executor %q has no refund executor", cfg.Kind)
+}
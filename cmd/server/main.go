@@ -2,114 +2,251 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
-
-	// "net/http"
-	"time"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/application/invoice"
+	paymentApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/refund"
 	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infra/logging"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infra/metrics"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/eventbus"
-
-	// httpapi "github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/http"
+	httpapi "github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/http"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
-	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
 )
 
 func main() {
-	log.Println(os.Getwd())
-	db, err := sqlite.Open("./db/db.db")
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal("error openning database")
+		log.Fatal(err)
 	}
 
-	invoiceRepo := sqlite.NewInvoiceRepository(db)
-	paymentRepo := sqlite.NewPaymentRepository(db)
-	outboxRepo := sqlite.NewOutboxRepository(db)
+	repos, closeRepos, err := openRepositories(cfg.Storage)
+	if err != nil {
+		log.Fatalf("error opening %s storage: %v", cfg.Storage.Backend, err)
+	}
 
 	bus := eventbus.NewInMemoryBus()
 	outboxRecorder := &outbox.Recorder{
-		Repo: outboxRepo,
+		Repo: repos.Outbox,
 	}
 
-	// invoiceService := &invoice.Service{
-	// 	Repo:     invoiceRepo,
-	// 	EventBus: bus,
-	// }
-
 	retryScheduler := &worker.RetryScheduler{
-		EventBus:  bus,
-		MaxRetry:  3,
-		BaseDelay: time.Second,
-		MaxDelay:  30 * time.Second,
+		Store:        repos.Scheduled,
+		Recorder:     outboxRecorder,
+		Invoices:     repos.Invoices,
+		UnitOfWork:   repos.UnitOfWork,
+		Policy:       retryPolicy(cfg.Retry),
+		PollInterval: cfg.Retry.PollInterval,
+		BatchSize:    cfg.Retry.BatchSize,
+	}
+
+	invoiceService := &invoice.Service{
+		Repo:       repos.Invoices,
+		Recorder:   outboxRecorder,
+		Scheduled:  repos.Scheduled,
+		Payments:   repos.Payments,
+		Refunds:    repos.Refunds,
+		UnitOfWork: repos.UnitOfWork,
 	}
 
 	logger := &logging.StdoutLogger{}
 	metrics := &metrics.Counters{}
-	executor := &worker.RandomPaymentExecutor{}
 
-	dispatcher := outbox.Dispatcher{
-		Repo:         outboxRepo,
-		EventBus:     bus,
-		PollInterval: 1 * time.Second,
-		BatchSize:    1024,
+	executor, refundExecutor, err := executors(cfg.Executor)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	ctx := context.Background()
+	// dispatcher IDs show up in the outbox leases, so they say which
+	// process holds an event
+	hostname, _ := os.Hostname()
+	var dispatchers []*outbox.Dispatcher
+	for i := range cfg.Dispatcher.Workers {
+		dispatchers = append(dispatchers, &outbox.Dispatcher{
+			Repo:         repos.Outbox,
+			EventBus:     bus,
+			PollInterval: cfg.Dispatcher.PollInterval,
+			BatchSize:    cfg.Dispatcher.BatchSize,
+			ID:           fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), i),
+			Lease:        cfg.Dispatcher.Lease,
+			Retry:        policy(cfg.Dispatcher.Retry),
+		})
+	}
 
-	go func() {
-		dispatcher.Run(ctx)
-	}()
+	overdueSweeper := &invoice.OverdueSweeper{
+		Repo:         repos.Invoices,
+		Recorder:     outboxRecorder,
+		UnitOfWork:   repos.UnitOfWork,
+		PollInterval: cfg.Overdue.PollInterval,
+		BatchSize:    cfg.Overdue.BatchSize,
+	}
 
 	paymentProcessor := &worker.PaymentProcessor{
-		Repo:     paymentRepo,
-		Recorder: outboxRecorder,
-		Retry:    retryScheduler,
-		Logger:   logger,
-		Metrics:  metrics,
-		Executor: executor,
+		Repo:       repos.Payments,
+		Recorder:   outboxRecorder,
+		Retry:      retryScheduler,
+		Logger:     logger,
+		Metrics:    metrics,
+		Executor:   executor,
+		Invoices:   repos.Invoices,
+		Refunds:    repos.Refunds,
+		UnitOfWork: repos.UnitOfWork,
+		Timeout:    cfg.Executor.Timeout,
 	}
 
 	invoiceEventHandler := invoice.PaymentEventHandler{
-		Repo: invoiceRepo,
-	}
-
-	bus.Subscribe(
-		"PaymentRequested",
-		paymentProcessor.Handle,
-	)
-
-	bus.Subscribe(
-		"PaymentSucceeded",
-		invoiceEventHandler.Handle,
-	)
-
-	bus.Subscribe(
-		"PaymentFailed",
-		invoiceEventHandler.Handle,
-	)
-
-	bus.Publish(event.Event{
-		Type: event.PaymentRequested,
-		Payload: event.PaymentRequestPayload{
-			InvoiceID: "inv-123",
-			Amount:    100,
-			Attempt:   1,
+		Repo:   repos.Invoices,
+		Logger: logger,
+	}
+
+	refundProcessor := &worker.RefundProcessor{
+		Repo:       repos.Refunds,
+		Payments:   repos.Payments,
+		Recorder:   outboxRecorder,
+		Logger:     logger,
+		Metrics:    metrics,
+		Executor:   refundExecutor,
+		UnitOfWork: repos.UnitOfWork,
+		Timeout:    cfg.Executor.Timeout,
+	}
+
+	invoiceRefundHandler := invoice.RefundEventHandler{
+		Repo:    repos.Invoices,
+		Refunds: repos.Refunds,
+	}
+
+	subscriptions := []struct {
+		eventType event.Type
+		handler   eventbus.HandlerFunc
+	}{
+		{event.PaymentRequested, paymentProcessor.Handle},
+		{event.PaymentSucceeded, invoiceEventHandler.Handle},
+		{event.PaymentFailed, invoiceEventHandler.Handle},
+		{event.PaymentRetriesExhausted, invoiceEventHandler.Handle},
+		{event.RefundRequested, refundProcessor.Handle},
+		{event.RefundSucceeded, invoiceRefundHandler.Handle},
+	}
+	for _, sub := range subscriptions {
+		if err := bus.Subscribe(sub.eventType, sub.handler); err != nil {
+			log.Fatalf("error subscribing to %s: %v", sub.eventType, err)
+		}
+	}
+
+	invoiceHandler := &httpapi.InvoiceHandler{
+		Service: invoiceService,
+	}
+
+	paymentHandler := &httpapi.PaymentHandler{
+		Payments: &paymentApplication.Service{
+			Payments: repos.Payments,
+			Invoices: repos.Invoices,
+		},
+		Refunds: &refund.Service{
+			Invoices:   repos.Invoices,
+			Payments:   repos.Payments,
+			Refunds:    repos.Refunds,
+			Recorder:   outboxRecorder,
+			UnitOfWork: repos.UnitOfWork,
 		},
-	})
+	}
+
+	idempotency := &httpapi.Idempotency{
+		Store:         repos.Idempotency,
+		TTL:           cfg.HTTP.IdempotencyTTL,
+		PurgeInterval: cfg.HTTP.IdempotencyPurgeInterval,
+		Lease:         cfg.HTTP.IdempotencyLease,
+	}
 
-	time.Sleep(10 * time.Second)
+	router := httpapi.NewRouter(invoiceHandler, paymentHandler, idempotency)
 
-	// invoiceHandler := &httpapi.InvoiceHandler{
-	// 	Service: invoiceService,
-	// }
+	server := &http.Server{
+		Addr:    cfg.HTTP.Addr,
+		Handler: router,
+	}
 
-	// router := httpapi.NewRouter(invoiceHandler)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Workers get their own context so they keep running while the HTTP
+	// server drains; requests in flight may still publish events.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	runs := []func(context.Context){
+		retryScheduler.Run,
+		overdueSweeper.Run,
+		idempotency.Run,
+	}
+	for _, dispatcher := range dispatchers {
+		runs = append(runs, dispatcher.Run)
+	}
+
+	var workers sync.WaitGroup
+	for _, run := range runs {
+		workers.Go(func() {
+			run(workerCtx)
+		})
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("HTTP server running on %s", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
 
-	// log.Println("HTTP server running on port :8080")
-	// log.Fatal(http.ListenAndServe(":8080", router))
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down")
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server failed: %v", err)
+		}
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown: %v", err)
+	}
+
+	// Workers stop between ticks, so the batch being dispatched and the
+	// payments it triggered complete before Run returns.
+	stopWorkers()
+
+	drained := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("workers stopped")
+	case <-shutdownCtx.Done():
+		// Closing the storage would fail the writes of the workers still
+		// running halfway; the process exits with it open instead, and what
+		// they left undone is redelivered on restart.
+		log.Println("timed out waiting for workers to stop, leaving storage open")
+		return
+	}
+
+	if err := closeRepos(); err != nil {
+		log.Printf("error closing %s storage: %v", cfg.Storage.Backend, err)
+	}
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+// retryPolicy assembles the configured policies: merchant overrides first,
+// then per decline code policies, then the default.
+func retryPolicy(cfg config.Retry) worker.RetryPolicy {
+	byDeclineCode := worker.ByDeclineCode{
+		Policies: make(map[payment.DeclineCode]worker.RetryPolicy, len(cfg.DeclineCodes)),
+		Default:  policy(cfg.Policy),
+	}
+	for code, p := range cfg.DeclineCodes {
+		byDeclineCode.Policies[payment.DeclineCode(code)] = policy(p)
+	}
+
+	merchants := make(map[string]worker.RetryPolicy, len(cfg.Merchants))
+	for merchant, p := range cfg.Merchants {
+		merchants[merchant] = policy(p)
+	}
+
+	return worker.PolicySelector{
+		Merchants: merchants,
+		Default:   byDeclineCode,
+	}
+}
+
+func policy(p config.Policy) worker.RetryPolicy {
+	switch p.Strategy {
+	case config.StrategyExponential:
+		return worker.ExponentialBackoff{Base: p.Base, Max: p.Max, MaxAttempts: p.MaxAttempts}
+	case config.StrategyFullJitter:
+		return worker.FullJitter{Base: p.Base, Max: p.Max, MaxAttempts: p.MaxAttempts}
+	case config.StrategyDecorrelatedJitter:
+		return worker.DecorrelatedJitter{Base: p.Base, Max: p.Max, MaxAttempts: p.MaxAttempts}
+	case config.StrategyFixed:
+		return worker.FixedInterval{Interval: p.Interval, MaxAttempts: p.MaxAttempts}
+	default:
+		return worker.NoRetry{}
+	}
+}
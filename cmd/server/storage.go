@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/idempotency"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+type repositories struct {
+	Invoices    invoice.Repository
+	Payments    payment.Repository
+	Refunds     refund.Repository
+	Outbox      outbox.Repository
+	Scheduled   payment.ScheduleRepository
+	Idempotency idempotency.Store
+	UnitOfWork  contracts.UnitOfWork
+}
+
+// openRepositories builds the repositories of the configured backend. The
+// returned close function releases whatever the backend holds open.
+func openRepositories(cfg config.Storage) (*repositories, func() error, error) {
+	if cfg.Backend == config.BackendMemory {
+		invoices := inmemory.NewInvoiceRepository()
+		payments := inmemory.NewPaymentRepository()
+		refunds := inmemory.NewRefundRepository()
+		scheduled := inmemory.NewScheduledPaymentRepository()
+		events := inmemory.NewOutboxRepository()
+
+		return &repositories{
+			Invoices:    invoices,
+			Payments:    payments,
+			Refunds:     refunds,
+			Outbox:      events,
+			Scheduled:   scheduled,
+			Idempotency: inmemory.NewIdempotencyRepository(),
+			UnitOfWork:  inmemory.NewUnitOfWork(invoices, payments, refunds, scheduled, events),
+		}, func() error { return nil }, nil
+	}
+
+	db, err := sqlite.Open(cfg.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := sqlite.RunMigrations(db); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return &repositories{
+		Invoices:    sqlite.NewInvoiceRepository(db),
+		Payments:    sqlite.NewPaymentRepository(db),
+		Refunds:     sqlite.NewRefundRepository(db),
+		Outbox:      sqlite.NewOutboxRepository(db),
+		Scheduled:   sqlite.NewScheduledPaymentRepository(db),
+		Idempotency: sqlite.NewIdempotencyRepository(db),
+		UnitOfWork:  sqlite.NewUnitOfWork(db),
+	}, db.Close, nil
+}
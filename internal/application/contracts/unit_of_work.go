@@ -0,0 +1,24 @@
+package contracts
+
+import (
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+// Repositories are the repositories of a unit of work. Events recorded
+// through Events are written to the outbox along with the other changes.
+type Repositories struct {
+	Invoices  invoice.Repository
+	Payments  payment.Repository
+	Refunds   refund.Repository
+	Scheduled payment.ScheduleRepository
+	Events    EventRecorder
+}
+
+// UnitOfWork runs fn in one transaction: everything written through the
+// repositories it is given commits if fn returns nil and is rolled back
+// otherwise.
+type UnitOfWork interface {
+	Do(fn func(Repositories) error) error
+}
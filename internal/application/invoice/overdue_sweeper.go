@@ -0,0 +1,93 @@
+package invoice
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+)
+
+type OverdueSweeper struct {
+	Repo     domainInvoice.Repository
+	Recorder contracts.EventRecorder
+	// UnitOfWork, if set, makes an invoice turn overdue together with the
+	// event recording it.
+	UnitOfWork   contracts.UnitOfWork
+	PollInterval time.Duration
+	BatchSize    int
+	Now          func() time.Time
+}
+
+func (s *OverdueSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.SweepOnce()
+		}
+	}
+}
+
+func (s *OverdueSweeper) SweepOnce() {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	invoices, err := s.Repo.FindOverdue(now, s.BatchSize)
+	if err != nil {
+		log.Println(err.Error())
+		return
+	}
+
+	for _, inv := range invoices {
+		previous := inv.Status
+
+		if err := inv.MarkOverdue(now); err != nil {
+			continue
+		}
+
+		evt := event.New(
+			event.InvoiceOverdue,
+			event.Invoice(inv.ID),
+			event.InvoiceOverduePayload{
+				InvoiceID:      inv.ID,
+				PreviousStatus: string(previous),
+				DueAt:          inv.DueAt,
+				Balance:        inv.Balance(),
+			},
+		)
+
+		// lost races (e.g. a payment requested meanwhile) are picked up again
+		// on the next sweep if the invoice is still overdue
+		err := s.inTransaction(func(tx contracts.Repositories) error {
+			if err := tx.Invoices.Update(inv); err != nil {
+				return err
+			}
+			return tx.Events.Record(evt)
+		})
+		if err != nil && !errors.Is(err, domainInvoice.ErrConcurrentUpdate) {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// inTransaction runs fn in the unit of work, or straight against Repo and
+// Recorder if there is none.
+func (s *OverdueSweeper) inTransaction(fn func(contracts.Repositories) error) error {
+	if s.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Invoices: s.Repo,
+			Events:   s.Recorder,
+		})
+	}
+	return s.UnitOfWork.Do(fn)
+}
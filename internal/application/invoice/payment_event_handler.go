@@ -5,10 +5,14 @@ import (
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infra/logging"
 )
 
 type PaymentEventHandler struct {
 	Repo domainInvoice.Repository
+	// Logger, if set, reports every invoice change with the event that caused
+	// it and its correlation.
+	Logger logging.Logger
 }
 
 func (h *PaymentEventHandler) Handle(evt event.Event) error {
@@ -18,7 +22,7 @@ func (h *PaymentEventHandler) Handle(evt event.Event) error {
 		if !ok {
 			return errors.New("invalid payload for PaymentSucceeded")
 		}
-		return h.Repo.UpdateStatus(payload.InvoiceID, domainInvoice.StatusPaid)
+		return h.applyPayment(evt, payload)
 
 	case event.PaymentFailed:
 		payload, ok := evt.Payload.(event.PaymentFailedPayload)
@@ -26,9 +30,81 @@ func (h *PaymentEventHandler) Handle(evt event.Event) error {
 			return errors.New("invalid payload for PaymentFailed")
 		}
 		if !payload.Retryable {
-			return h.Repo.UpdateStatus(payload.InvoiceID, domainInvoice.StatusFailed)
+			return h.failPayment(evt, payload.InvoiceID, payload.Attempt)
 		}
 		return nil
+
+	case event.PaymentRetriesExhausted:
+		payload, ok := evt.Payload.(event.PaymentRetriesExhaustedPayload)
+		if !ok {
+			return errors.New("invalid payload for PaymentRetriesExhausted")
+		}
+		return h.failPayment(evt, payload.InvoiceID, payload.Attempts)
+	}
+	return nil
+}
+
+// applyPayment settles the payment against the invoice balance. The invoice
+// keeps the payments applied to it, so a redelivered success is recognized
+// even once a later installment is in PROCESSING. Payments of canceled
+// invoices are refunded by the payment processor instead.
+func (h *PaymentEventHandler) applyPayment(evt event.Event, payload event.PaymentSucceededPayload) error {
+	inv, err := h.Repo.FindByID(payload.InvoiceID)
+	if err != nil {
+		return err
+	}
+
+	if inv.PaymentApplied(payload.PaymentID) || inv.Status == domainInvoice.StatusCanceled {
+		return nil
+	}
+
+	if err := inv.ApplyPayment(payload.PaymentID, payload.Amount); err != nil {
+		return err
 	}
+
+	if err := h.Repo.Update(inv); err != nil {
+		return err
+	}
+
+	h.logChange(evt, inv)
 	return nil
 }
+
+// failPayment is idempotent: redelivered events for an invoice that is
+// already FAILED are ignored, as are failures of invoices canceled meanwhile.
+func (h *PaymentEventHandler) failPayment(evt event.Event, invoiceID string, attempts int) error {
+	inv, err := h.Repo.FindByID(invoiceID)
+	if err != nil {
+		return err
+	}
+
+	if inv.Status == domainInvoice.StatusFailed || inv.Status == domainInvoice.StatusCanceled {
+		return nil
+	}
+
+	if err := inv.FailPayment(attempts); err != nil {
+		return err
+	}
+
+	if err := h.Repo.Update(inv); err != nil {
+		return err
+	}
+
+	h.logChange(evt, inv)
+	return nil
+}
+
+func (h *PaymentEventHandler) logChange(evt event.Event, inv *domainInvoice.Invoice) {
+	if h.Logger == nil {
+		return
+	}
+
+	h.Logger.Info("invoice updated", map[string]any{
+		"invoice-id":     inv.ID,
+		"status":         inv.Status,
+		"event-id":       evt.ID,
+		"event-type":     evt.Type,
+		"correlation-id": evt.CorrelationID,
+		"causation-id":   evt.CausationID,
+	})
+}
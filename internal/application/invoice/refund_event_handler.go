@@ -0,0 +1,64 @@
+package invoice
+
+import (
+	"errors"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+type RefundEventHandler struct {
+	Repo    domainInvoice.Repository
+	Refunds refund.Repository
+}
+
+// Handle recomputes the refunded total from the succeeded refunds instead of
+// adding the event amount, so redelivered events do not double count.
+// Canceled invoices are left alone: their refunds return payments that
+// succeeded after the cancellation and were never applied to them.
+func (h *RefundEventHandler) Handle(evt event.Event) error {
+	if evt.Type != event.RefundSucceeded {
+		return nil
+	}
+
+	payload, ok := evt.Payload.(event.RefundSucceededPayload)
+	if !ok {
+		return errors.New("invalid payload for RefundSucceeded")
+	}
+
+	inv, err := h.Repo.FindByID(payload.InvoiceID)
+	if err != nil {
+		return err
+	}
+
+	if inv.Status == domainInvoice.StatusCanceled {
+		return nil
+	}
+
+	refunds, err := h.Refunds.FindByInvoiceID(inv.ID)
+	if err != nil {
+		return err
+	}
+
+	total := money.Zero(inv.Amount.Currency)
+	for _, ref := range refunds {
+		if ref.Status != refund.StatusSucceeded {
+			continue
+		}
+		if total, err = total.Add(ref.Amount); err != nil {
+			return err
+		}
+	}
+
+	if inv.AmountRefunded == total {
+		return nil
+	}
+
+	if err := inv.RecordRefunds(total); err != nil {
+		return err
+	}
+
+	return h.Repo.Update(inv)
+}
@@ -1,32 +1,57 @@
 package invoice
 
 import (
+	"crypto/rand"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
 )
 
 var (
-	ErrInvoiceNotFound     = errors.New("invoice not found")
+	ErrInvoiceNotFound     = domainInvoice.ErrNotFound
 	ErrInvalidInvoiceState = errors.New("invalid invoice state")
 )
 
 type Service struct {
 	Repo     domainInvoice.Repository
-	EventBus EventPublisher
+	Recorder contracts.EventRecorder
+	// Scheduled holds the payment retries queued for invoices, which are
+	// dropped when the invoice is canceled.
+	Scheduled payment.ScheduleRepository
+	// Payments and Refunds are used to refund the payments an invoice
+	// already received when it is canceled.
+	Payments payment.Repository
+	Refunds  refund.Repository
+	// UnitOfWork, if set, makes invoice changes commit together with the
+	// events recording them.
+	UnitOfWork contracts.UnitOfWork
+	// PaymentTerm sets the due date of invoices created without one.
+	PaymentTerm time.Duration
+	Now         func() time.Time
 }
 
-type EventPublisher interface {
-	Publish(event.Event) error
-}
+const defaultPaymentTerm = 30 * 24 * time.Hour
+
+// CreateInvoice issues the invoice now; a zero dueAt applies the payment
+// term.
+func (s *Service) CreateInvoice(id, merchantID string, currency money.Currency, lines []domainInvoice.LineItem, dueAt time.Time) (*domainInvoice.Invoice, error) {
+	issuedAt := s.now()
+	if dueAt.IsZero() {
+		dueAt = issuedAt.Add(s.paymentTerm())
+	}
 
-func (s *Service) CreateInvoice(id string, amount int64) (*domainInvoice.Invoice, error) {
-	inv := &domainInvoice.Invoice{
-		ID:     id,
-		Amount: amount,
-		Status: domainInvoice.StatusPending,
+	inv, err := domainInvoice.New(id, currency, lines, issuedAt, dueAt)
+	if err != nil {
+		return nil, err
 	}
+	inv.MerchantID = merchantID
 
 	if err := s.Repo.Save(inv); err != nil {
 		return nil, err
@@ -35,28 +60,226 @@ func (s *Service) CreateInvoice(id string, amount int64) (*domainInvoice.Invoice
 	return inv, nil
 }
 
-func (s *Service) RequestPayment(invoiceID string) error {
+func (s *Service) GetInvoice(id string) (*domainInvoice.Invoice, error) {
+	return s.Repo.FindByID(id)
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ListInvoices returns a page of invoices and the cursor of the next page,
+// which is nil on the last one.
+func (s *Service) ListInvoices(q domainInvoice.ListQuery) ([]*domainInvoice.Invoice, *domainInvoice.Cursor, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultListLimit
+	}
+	q.Limit = min(q.Limit, maxListLimit)
+
+	limit := q.Limit
+	q.Limit++
+
+	invoices, err := s.Repo.List(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(invoices) <= limit {
+		return invoices, nil, nil
+	}
+
+	invoices = invoices[:limit]
+	return invoices, domainInvoice.CursorOf(invoices[limit-1]), nil
+}
+
+// RequestPayment requests a payment of the invoice's outstanding balance.
+func (s *Service) RequestPayment(invoiceID, paymentMethod string) error {
+	inv, err := s.Repo.FindByID(invoiceID)
+	if err != nil {
+		return err
+	}
+
+	return s.requestPayment(inv, inv.Balance(), paymentMethod)
+}
+
+// RequestPartialPayment requests an installment of the given amount, which
+// must not exceed the outstanding balance.
+func (s *Service) RequestPartialPayment(invoiceID string, amount money.Money, paymentMethod string) error {
+	inv, err := s.Repo.FindByID(invoiceID)
+	if err != nil {
+		return err
+	}
+
+	return s.requestPayment(inv, amount, paymentMethod)
+}
+
+func (s *Service) requestPayment(inv *domainInvoice.Invoice, amount money.Money, paymentMethod string) error {
+	if err := inv.ValidatePaymentAmount(amount); err != nil {
+		return err
+	}
+
+	from := inv.Status
+	if err := inv.Transition(domainInvoice.StatusProcessing); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidInvoiceState, err)
+	}
+
+	evt := event.New(
+		event.PaymentRequested,
+		event.Invoice(inv.ID),
+		event.PaymentRequestPayload{
+			InvoiceID:     inv.ID,
+			MerchantID:    inv.MerchantID,
+			PaymentID:     generatePaymentID(),
+			Amount:        amount,
+			PaymentMethod: paymentMethod,
+			Attempt:       1,
+		},
+	)
+
+	return s.inTransaction(func(tx contracts.Repositories) error {
+		if err := tx.Invoices.UpdateStatus(inv.ID, from, inv.Status); err != nil {
+			return err
+		}
+		return tx.Events.Record(evt)
+	})
+}
+
+// CancelInvoice cancels PENDING, FAILED and OVERDUE invoices. PROCESSING
+// invoices are canceled too: their queued payment retries are dropped, and a
+// payment in flight that succeeds anyway is refunded. Installments the
+// invoice already received are refunded with the cancellation.
+func (s *Service) CancelInvoice(invoiceID, reason string) (*domainInvoice.Invoice, error) {
 	inv, err := s.Repo.FindByID(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := inv.Status
+	if err := inv.Cancel(reason); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInvoiceState, err)
+	}
+
+	evt := event.New(
+		event.InvoiceCanceled,
+		event.Invoice(inv.ID),
+		event.InvoiceCanceledPayload{
+			InvoiceID:      inv.ID,
+			PreviousStatus: string(previous),
+			Reason:         reason,
+		},
+	)
+
+	err = s.inTransaction(func(tx contracts.Repositories) error {
+		if err := tx.Invoices.Update(inv); err != nil {
+			return err
+		}
+		if previous == domainInvoice.StatusProcessing {
+			if err := tx.Scheduled.DeleteByInvoiceID(inv.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Events.Record(evt); err != nil {
+			return err
+		}
+		for _, paymentID := range inv.AppliedPayments {
+			if err := refundApplied(tx, paymentID, evt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+// refundApplied requests a refund of what is left of a payment applied to a
+// canceled invoice once the refunds it already has are taken out.
+func refundApplied(tx contracts.Repositories, paymentID string, cause event.Event) error {
+	pay, err := tx.Payments.FindByID(paymentID)
 	if err != nil {
 		return err
 	}
 
-	if inv.Status != domainInvoice.StatusPending {
-		return ErrInvalidInvoiceState
+	refunds, err := tx.Refunds.FindByPaymentID(pay.ID)
+	if err != nil {
+		return err
 	}
 
-	if err := s.Repo.UpdateStatus(inv.ID, domainInvoice.StatusProcessing); err != nil {
+	amount := pay.Amount
+	for _, ref := range refunds {
+		if !ref.Pending() {
+			continue
+		}
+		if amount, err = amount.Sub(ref.Amount); err != nil {
+			return err
+		}
+	}
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	ref := &refund.Refund{
+		ID:        generateRefundID(),
+		PaymentID: pay.ID,
+		InvoiceID: pay.InvoiceID,
+		Amount:    amount,
+		Reason:    "invoice canceled",
+		Status:    refund.StatusRequested,
+	}
+	if err := tx.Refunds.Save(ref); err != nil {
 		return err
 	}
 
-	evt := event.Event{
-		Type: event.PaymentRequested,
-		Payload: event.PaymentRequestPayload{
-			InvoiceID: inv.ID,
-			Amount:    inv.Amount,
-			Attempt:   1,
+	return tx.Events.Record(event.CausedBy(cause,
+		event.RefundRequested,
+		event.Refund(ref.ID),
+		event.RefundRequestedPayload{
+			RefundID:  ref.ID,
+			PaymentID: ref.PaymentID,
+			InvoiceID: ref.InvoiceID,
+			Amount:    ref.Amount,
+			Reason:    ref.Reason,
 		},
+	))
+}
+
+// inTransaction runs fn in the unit of work, or straight against Repo,
+// Payments, Refunds, Scheduled and Recorder if there is none.
+func (s *Service) inTransaction(fn func(contracts.Repositories) error) error {
+	if s.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Invoices:  s.Repo,
+			Payments:  s.Payments,
+			Refunds:   s.Refunds,
+			Scheduled: s.Scheduled,
+			Events:    s.Recorder,
+		})
 	}
+	return s.UnitOfWork.Do(fn)
+}
+
+func (s *Service) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
+
+func (s *Service) paymentTerm() time.Duration {
+	if s.PaymentTerm <= 0 {
+		return defaultPaymentTerm
+	}
+	return s.PaymentTerm
+}
+
+func generatePaymentID() string {
+	return "pay_" + rand.Text()
+}
 
-	return s.EventBus.Publish(evt)
+func generateRefundID() string {
+	return "ref_" + rand.Text()
 }
@@ -0,0 +1,545 @@
+package invoice_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	domainRefund "github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+type fakeRecorder struct {
+	recorded []event.Event
+}
+
+func (f *fakeRecorder) Record(evt event.Event) error {
+	f.recorded = append(f.recorded, evt)
+	return nil
+}
+
+// fakeRetries only supports dropping the retries of an invoice.
+type fakeRetries struct {
+	payment.ScheduleRepository
+	canceled []string
+}
+
+func (f *fakeRetries) DeleteByInvoiceID(invoiceID string) error {
+	f.canceled = append(f.canceled, invoiceID)
+	return nil
+}
+
+var now = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func setupService(t *testing.T, status domainInvoice.Status) (*invoice.Service, *fakeRecorder, *fakeRetries) {
+	t.Helper()
+
+	repo := inmemory.NewInvoiceRepository()
+
+	inv, err := domainInvoice.New("inv-1", money.BRL, []domainInvoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}, now, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv.Status = status
+
+	if err := repo.Save(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &fakeRecorder{}
+	retries := &fakeRetries{}
+
+	return &invoice.Service{
+		Repo:      repo,
+		Recorder:  recorder,
+		Scheduled: retries,
+	}, recorder, retries
+}
+
+func TestService_CancelInvoice_ShouldCancelAndRecordEvent(t *testing.T) {
+	for _, status := range []domainInvoice.Status{domainInvoice.StatusPending, domainInvoice.StatusFailed} {
+		t.Run(string(status), func(t *testing.T) {
+			service, recorder, retries := setupService(t, status)
+
+			inv, err := service.CancelInvoice("inv-1", "customer request")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if inv.Status != domainInvoice.StatusCanceled || inv.CancelReason != "customer request" {
+				t.Fatalf("expected canceled invoice with reason, got %s %q", inv.Status, inv.CancelReason)
+			}
+
+			if len(recorder.recorded) != 1 || recorder.recorded[0].Type != event.InvoiceCanceled {
+				t.Fatalf("expected a single InvoiceCanceled event, got %v", recorder.recorded)
+			}
+
+			if len(retries.canceled) != 0 {
+				t.Fatalf("expected no retries to be canceled, got %v", retries.canceled)
+			}
+		})
+	}
+}
+
+func TestService_CancelInvoice_ShouldAbortRetriesOfProcessingInvoice(t *testing.T) {
+	service, _, retries := setupService(t, domainInvoice.StatusProcessing)
+
+	if _, err := service.CancelInvoice("inv-1", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(retries.canceled) != 1 || retries.canceled[0] != "inv-1" {
+		t.Fatalf("expected retries of inv-1 to be canceled, got %v", retries.canceled)
+	}
+}
+
+func TestService_CancelInvoice_ShouldRejectPaidInvoice(t *testing.T) {
+	service, recorder, _ := setupService(t, domainInvoice.StatusPaid)
+
+	_, err := service.CancelInvoice("inv-1", "")
+	if !errors.Is(err, invoice.ErrInvalidInvoiceState) {
+		t.Fatalf("expected ErrInvalidInvoiceState, got %v", err)
+	}
+
+	if len(recorder.recorded) != 0 {
+		t.Fatalf("expected no events, got %v", recorder.recorded)
+	}
+}
+
+func TestOverdueSweeper_ShouldMarkPastDueInvoicesAndRecordEvent(t *testing.T) {
+	repo := inmemory.NewInvoiceRepository()
+	recorder := &fakeRecorder{}
+
+	service := &invoice.Service{
+		Repo: repo,
+		Now:  func() time.Time { return now },
+	}
+
+	line := []domainInvoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}
+
+	if _, err := service.CreateInvoice("inv-late", "", money.BRL, line, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.CreateInvoice("inv-on-time", "", money.BRL, line, now.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	sweeper := &invoice.OverdueSweeper{
+		Repo:      repo,
+		Recorder:  recorder,
+		BatchSize: 10,
+		Now:       func() time.Time { return now.Add(2 * time.Hour) },
+	}
+
+	sweeper.SweepOnce()
+	sweeper.SweepOnce()
+
+	late, _ := repo.FindByID("inv-late")
+	if late.Status != domainInvoice.StatusOverdue {
+		t.Fatalf("expected inv-late to be OVERDUE, got %s", late.Status)
+	}
+
+	onTime, _ := repo.FindByID("inv-on-time")
+	if onTime.Status != domainInvoice.StatusPending {
+		t.Fatalf("expected inv-on-time to stay PENDING, got %s", onTime.Status)
+	}
+
+	if len(recorder.recorded) != 1 || recorder.recorded[0].Type != event.InvoiceOverdue {
+		t.Fatalf("expected a single InvoiceOverdue event, got %v", recorder.recorded)
+	}
+}
+
+func TestPaymentEventHandler_ShouldFailInvoiceWhenRetriesAreExhausted(t *testing.T) {
+	repo := inmemory.NewInvoiceRepository()
+
+	inv, err := domainInvoice.New("inv-1", money.BRL, []domainInvoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}, now, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv.Status = domainInvoice.StatusProcessing
+
+	if err := repo.Save(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &invoice.PaymentEventHandler{Repo: repo}
+	evt := event.Event{
+		Type: event.PaymentRetriesExhausted,
+		Payload: event.PaymentRetriesExhaustedPayload{
+			InvoiceID:   "inv-1",
+			PaymentID:   "pay-1",
+			Attempts:    3,
+			DeclineCode: "insufficient_funds",
+		},
+	}
+
+	for range 2 {
+		if err := handler.Handle(evt); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	failed, err := repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed.Status != domainInvoice.StatusFailed || failed.PaymentAttempts != 3 {
+		t.Fatalf("expected FAILED invoice after 3 attempts, got %s after %d", failed.Status, failed.PaymentAttempts)
+	}
+}
+
+func TestService_ListInvoices_ShouldFilterAndPaginate(t *testing.T) {
+	repo := inmemory.NewInvoiceRepository()
+	issuedAt := now
+
+	service := &invoice.Service{
+		Repo: repo,
+		Now:  func() time.Time { return issuedAt },
+	}
+
+	line := []domainInvoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}
+
+	for _, id := range []string{"inv-a", "inv-b", "inv-c", "inv-d", "inv-e"} {
+		if _, err := service.CreateInvoice(id, "", money.BRL, line, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		issuedAt = issuedAt.Add(time.Hour)
+	}
+
+	if err := repo.UpdateStatus("inv-b", domainInvoice.StatusPending, domainInvoice.StatusCanceled); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []string
+	q := domainInvoice.ListQuery{Status: domainInvoice.StatusPending, IssuedTo: now.Add(4 * time.Hour), Limit: 2}
+	for {
+		page, next, err := service.ListInvoices(q)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, inv := range page {
+			listed = append(listed, inv.ID)
+		}
+		if next == nil {
+			break
+		}
+		q.After = next
+	}
+
+	if got := strings.Join(listed, ","); got != "inv-a,inv-c,inv-d" {
+		t.Fatalf("expected inv-a,inv-c,inv-d, got %s", got)
+	}
+}
+
+func TestService_RequestPayment_ShouldRecordRequestWithStatusChange(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusPending)
+	outbox := inmemory.NewOutboxRepository()
+	service.UnitOfWork = inmemory.NewUnitOfWork(
+		service.Repo.(*inmemory.InvoiceRepository),
+		inmemory.NewPaymentRepository(),
+		inmemory.NewRefundRepository(),
+		inmemory.NewScheduledPaymentRepository(),
+		outbox,
+	)
+
+	if err := service.RequestPayment("inv-1", "card_123"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusProcessing {
+		t.Fatalf("expected PROCESSING, got %s", inv.Status)
+	}
+
+	recorded, err := outbox.FindUnpublished(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Type != event.PaymentRequested {
+		t.Fatalf("expected one PaymentRequested in the outbox, got %+v", recorded)
+	}
+}
+
+func TestService_CancelInvoice_ShouldDropRetriesWithTheCancellation(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusProcessing)
+	scheduled := inmemory.NewScheduledPaymentRepository()
+	outbox := inmemory.NewOutboxRepository()
+	service.UnitOfWork = inmemory.NewUnitOfWork(
+		service.Repo.(*inmemory.InvoiceRepository),
+		inmemory.NewPaymentRepository(),
+		inmemory.NewRefundRepository(),
+		scheduled,
+		outbox,
+	)
+
+	if err := scheduled.Save(&payment.ScheduledPayment{ID: "sched-1", InvoiceID: "inv-1", RunAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.CancelInvoice("inv-1", "customer request"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := scheduled.Scheduled(); n != 0 {
+		t.Fatalf("expected the retry to be dropped, got %d scheduled", n)
+	}
+
+	recorded, err := outbox.FindUnpublished(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 || recorded[0].Type != event.InvoiceCanceled {
+		t.Fatalf("expected one InvoiceCanceled in the outbox, got %+v", recorded)
+	}
+}
+
+func TestService_CancelInvoice_ShouldRefundThePaymentsAlreadyReceived(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusFailed)
+	payments := inmemory.NewPaymentRepository()
+	refunds := inmemory.NewRefundRepository()
+	outbox := inmemory.NewOutboxRepository()
+	service.UnitOfWork = inmemory.NewUnitOfWork(
+		service.Repo.(*inmemory.InvoiceRepository),
+		payments,
+		refunds,
+		inmemory.NewScheduledPaymentRepository(),
+		outbox,
+	)
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv.AmountPaid = money.Money{Amount: 500, Currency: money.BRL}
+	inv.AppliedPayments = []string{"pay-1"}
+	if err := service.Repo.Update(inv); err != nil {
+		t.Fatal(err)
+	}
+	if err := payments.Save(&payment.Payment{
+		ID:        "pay-1",
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 500, Currency: money.BRL},
+		Status:    payment.StatusSuccess,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := refunds.Save(&domainRefund.Refund{
+		ID:        "ref-0",
+		PaymentID: "pay-1",
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Status:    domainRefund.StatusSucceeded,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.CancelInvoice("inv-1", "customer request"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	saved, err := refunds.FindByPaymentID("pay-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || saved[1].Status != domainRefund.StatusRequested || saved[1].Amount.Amount != 400 {
+		t.Fatalf("expected a requested refund of the remaining 400, got %+v", saved)
+	}
+
+	recorded, err := outbox.FindUnpublished(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 2 || recorded[0].Type != event.InvoiceCanceled || recorded[1].Type != event.RefundRequested {
+		t.Fatalf("expected InvoiceCanceled then RefundRequested in the outbox, got %+v", recorded)
+	}
+	if recorded[1].CorrelationID != recorded[0].ID {
+		t.Fatalf("expected the refund to be correlated with the cancellation")
+	}
+}
+
+func TestPaymentEventHandler_ShouldLeaveCanceledInvoiceUnpaid(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusCanceled)
+	handler := &invoice.PaymentEventHandler{Repo: service.Repo}
+
+	err := handler.Handle(event.Event{
+		Type: event.PaymentSucceeded,
+		Payload: event.PaymentSucceededPayload{
+			InvoiceID: "inv-1",
+			PaymentID: "pay-1",
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusCanceled || !inv.AmountPaid.IsZero() {
+		t.Fatalf("expected an unpaid CANCELED invoice, got %s paid %s", inv.Status, inv.AmountPaid)
+	}
+}
+
+func TestPaymentEventHandler_ShouldIgnoreRedeliveredInstallmentDuringTheNextOne(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusProcessing)
+	handler := &invoice.PaymentEventHandler{Repo: service.Repo}
+
+	first := event.Event{
+		Type: event.PaymentSucceeded,
+		Payload: event.PaymentSucceededPayload{
+			InvoiceID: "inv-1",
+			PaymentID: "pay-1",
+			Amount:    money.Money{Amount: 400, Currency: money.BRL},
+		},
+	}
+	if err := handler.Handle(first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := service.RequestPartialPayment("inv-1", money.Money{Amount: 600, Currency: money.BRL}, "card_123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.Handle(first); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusProcessing || inv.AmountPaid.Amount != 400 {
+		t.Fatalf("expected PROCESSING with 400 paid, got %s with %s", inv.Status, inv.AmountPaid)
+	}
+}
+
+func TestRefundEventHandler_ShouldCountEachSucceededRefundOnce(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusProcessing)
+	paid := &invoice.PaymentEventHandler{Repo: service.Repo}
+	if err := paid.Handle(event.Event{
+		Type: event.PaymentSucceeded,
+		Payload: event.PaymentSucceededPayload{
+			InvoiceID: "inv-1",
+			PaymentID: "pay-1",
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	refunds := inmemory.NewRefundRepository()
+	handler := &invoice.RefundEventHandler{Repo: service.Repo, Refunds: refunds}
+
+	succeed := func(id string, amount int64) event.Event {
+		t.Helper()
+		if err := refunds.Save(&domainRefund.Refund{
+			ID:        id,
+			PaymentID: "pay-1",
+			InvoiceID: "inv-1",
+			Amount:    money.Money{Amount: amount, Currency: money.BRL},
+			Status:    domainRefund.StatusSucceeded,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return event.Event{
+			Type: event.RefundSucceeded,
+			Payload: event.RefundSucceededPayload{
+				RefundID:  id,
+				PaymentID: "pay-1",
+				InvoiceID: "inv-1",
+				Amount:    money.Money{Amount: amount, Currency: money.BRL},
+			},
+		}
+	}
+
+	first := succeed("ref-1", 300)
+	for range 2 {
+		if err := handler.Handle(first); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusPartiallyRefunded || inv.AmountRefunded.Amount != 300 {
+		t.Fatalf("expected PARTIALLY_REFUNDED with 300 refunded, got %s with %s", inv.Status, inv.AmountRefunded)
+	}
+
+	if err := handler.Handle(succeed("ref-2", 700)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inv, err = service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusRefunded || inv.AmountRefunded.Amount != 1000 {
+		t.Fatalf("expected REFUNDED with 1000 refunded, got %s with %s", inv.Status, inv.AmountRefunded)
+	}
+}
+
+func TestRefundEventHandler_ShouldLeaveCanceledInvoiceAlone(t *testing.T) {
+	service, _, _ := setupService(t, domainInvoice.StatusCanceled)
+	refunds := inmemory.NewRefundRepository()
+	if err := refunds.Save(&domainRefund.Refund{
+		ID:        "ref-1",
+		PaymentID: "pay-1",
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Status:    domainRefund.StatusSucceeded,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &invoice.RefundEventHandler{Repo: service.Repo, Refunds: refunds}
+	err := handler.Handle(event.Event{
+		Type: event.RefundSucceeded,
+		Payload: event.RefundSucceededPayload{
+			RefundID:  "ref-1",
+			PaymentID: "pay-1",
+			InvoiceID: "inv-1",
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inv, err := service.Repo.FindByID("inv-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Status != domainInvoice.StatusCanceled || !inv.AmountRefunded.IsZero() {
+		t.Fatalf("expected CANCELED with nothing refunded, got %s with %s", inv.Status, inv.AmountRefunded)
+	}
+}
@@ -1,15 +1,59 @@
 package payment
 
 import (
-	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
-	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	domainPayment "github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
 )
 
 type Service struct {
-	Repo     payment.Repository
-	EventBus EventPublisher
+	Payments domainPayment.Repository
+	Invoices domainInvoice.Repository
 }
 
-type EventPublisher interface {
-	Publish(event.Event) error
+// Details is a payment along with its attempt history.
+type Details struct {
+	*domainPayment.Payment
+	Attempts []*domainPayment.Attempt
+}
+
+func (s *Service) GetPayment(id string) (*Details, error) {
+	pay, err := s.Payments.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.details(pay)
+}
+
+// ListInvoicePayments returns every payment requested for the invoice,
+// failing with domainInvoice.ErrNotFound for unknown invoices.
+func (s *Service) ListInvoicePayments(invoiceID string) ([]*Details, error) {
+	if _, err := s.Invoices.FindByID(invoiceID); err != nil {
+		return nil, err
+	}
+
+	payments, err := s.Payments.FindByInvoiceID(invoiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]*Details, 0, len(payments))
+	for _, pay := range payments {
+		d, err := s.details(pay)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, d)
+	}
+
+	return details, nil
+}
+
+func (s *Service) details(pay *domainPayment.Payment) (*Details, error) {
+	attempts, err := s.Payments.FindAttempts(pay.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Details{Payment: pay, Attempts: attempts}, nil
 }
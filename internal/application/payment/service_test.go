@@ -0,0 +1,80 @@
+package payment_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/payment"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	domainPayment "github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func setupService(t *testing.T) *payment.Service {
+	t.Helper()
+
+	invoices := inmemory.NewInvoiceRepository()
+	payments := inmemory.NewPaymentRepository()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	inv, err := domainInvoice.New("inv-1", money.BRL, []domainInvoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}, now, now)
+	require.NoError(t, err)
+	require.NoError(t, invoices.Save(inv))
+
+	for _, id := range []string{"pay-1", "pay-2"} {
+		require.NoError(t, payments.Save(&domainPayment.Payment{
+			ID:             id,
+			InvoiceID:      "inv-1",
+			Amount:         money.Money{Amount: 500, Currency: money.BRL},
+			Status:         domainPayment.StatusProcessing,
+			IdempotencyKey: "payment:inv-1:" + id,
+		}))
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		saved, err := payments.SaveAttemptIfNotExist(&domainPayment.Attempt{
+			PaymentID: "pay-1",
+			InvoiceID: "inv-1",
+			Number:    attempt,
+			Status:    domainPayment.StatusFailed,
+		})
+		require.NoError(t, err)
+		require.True(t, saved)
+	}
+
+	return &payment.Service{Payments: payments, Invoices: invoices}
+}
+
+func TestService_GetPayment_ShouldIncludeAttempts(t *testing.T) {
+	service := setupService(t)
+
+	details, err := service.GetPayment("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, "pay-1", details.ID)
+	require.Equal(t, 2, details.Attempt)
+	require.Len(t, details.Attempts, 2)
+
+	_, err = service.GetPayment("pay-404")
+	require.True(t, errors.Is(err, domainPayment.ErrNotFound))
+}
+
+func TestService_ListInvoicePayments(t *testing.T) {
+	service := setupService(t)
+
+	payments, err := service.ListInvoicePayments("inv-1")
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	require.Equal(t, "pay-1", payments[0].ID)
+	require.Equal(t, "pay-2", payments[1].ID)
+
+	_, err = service.ListInvoicePayments("inv-404")
+	require.True(t, errors.Is(err, domainInvoice.ErrNotFound))
+}
@@ -0,0 +1,159 @@
+package refund
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	domainRefund "github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+var (
+	ErrPaymentNotRefundable = errors.New("payment is not refundable")
+	ErrInvalidRefundAmount  = errors.New("invalid refund amount")
+)
+
+type Service struct {
+	Payments payment.Repository
+	Invoices invoice.Repository
+	Refunds  domainRefund.Repository
+	Recorder contracts.EventRecorder
+	// UnitOfWork, if set, makes a refund request commit together with the
+	// event recording it, and checks the refundable amount in the same
+	// transaction. Without it, concurrent requests may exceed the payment.
+	UnitOfWork contracts.UnitOfWork
+}
+
+// RequestRefund refunds everything still refundable on the payment.
+func (s *Service) RequestRefund(paymentID, reason string) (*domainRefund.Refund, error) {
+	return s.requestRefund(paymentID, reason, func(refundable money.Money) money.Money {
+		return refundable
+	})
+}
+
+// RequestPartialRefund refunds the given amount, which must not exceed what
+// is still refundable on the payment.
+func (s *Service) RequestPartialRefund(paymentID string, amount money.Money, reason string) (*domainRefund.Refund, error) {
+	return s.requestRefund(paymentID, reason, func(money.Money) money.Money {
+		return amount
+	})
+}
+
+// requestRefund refunds amountOf what is refundable. The refundable amount
+// is read in the unit the refund is saved in, so concurrent requests can't
+// together refund more than the payment.
+func (s *Service) requestRefund(paymentID, reason string, amountOf func(refundable money.Money) money.Money) (*domainRefund.Refund, error) {
+	var ref *domainRefund.Refund
+
+	err := s.inTransaction(func(tx contracts.Repositories) error {
+		pay, refundable, err := refundable(tx, paymentID)
+		if err != nil {
+			return err
+		}
+
+		amount := amountOf(refundable)
+		if !amount.IsPositive() {
+			return fmt.Errorf("%w: amount must be positive", ErrInvalidRefundAmount)
+		}
+
+		cmp, err := amount.Cmp(refundable)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRefundAmount, err)
+		}
+		if cmp > 0 {
+			return fmt.Errorf("%w: amount %s exceeds refundable %s", ErrInvalidRefundAmount, amount, refundable)
+		}
+
+		ref = &domainRefund.Refund{
+			ID:        generateRefundID(),
+			PaymentID: pay.ID,
+			InvoiceID: pay.InvoiceID,
+			Amount:    amount,
+			Reason:    reason,
+			Status:    domainRefund.StatusRequested,
+		}
+
+		if err := tx.Refunds.Save(ref); err != nil {
+			return err
+		}
+
+		return tx.Events.Record(event.New(
+			event.RefundRequested,
+			event.Refund(ref.ID),
+			event.RefundRequestedPayload{
+				RefundID:  ref.ID,
+				PaymentID: ref.PaymentID,
+				InvoiceID: ref.InvoiceID,
+				Amount:    ref.Amount,
+				Reason:    ref.Reason,
+			},
+		))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+// refundable returns the payment and the part of it not yet claimed by
+// requested, in-flight or succeeded refunds. Payments of invoices that can't
+// record a refund yet are not refundable.
+func refundable(tx contracts.Repositories, paymentID string) (*payment.Payment, money.Money, error) {
+	pay, err := tx.Payments.FindByID(paymentID)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	if pay.Status != payment.StatusSuccess {
+		return nil, money.Money{}, ErrPaymentNotRefundable
+	}
+
+	inv, err := tx.Invoices.FindByID(pay.InvoiceID)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+	if !inv.AcceptsRefunds() {
+		return nil, money.Money{}, fmt.Errorf("%w: invoice is %s", ErrPaymentNotRefundable, inv.Status)
+	}
+
+	refunds, err := tx.Refunds.FindByPaymentID(pay.ID)
+	if err != nil {
+		return nil, money.Money{}, err
+	}
+
+	refundable := pay.Amount
+	for _, ref := range refunds {
+		if !ref.Pending() {
+			continue
+		}
+		if refundable, err = refundable.Sub(ref.Amount); err != nil {
+			return nil, money.Money{}, err
+		}
+	}
+
+	return pay, refundable, nil
+}
+
+// inTransaction runs fn in the unit of work, or straight against Invoices,
+// Payments, Refunds and Recorder if there is none.
+func (s *Service) inTransaction(fn func(contracts.Repositories) error) error {
+	if s.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Invoices: s.Invoices,
+			Payments: s.Payments,
+			Refunds:  s.Refunds,
+			Events:   s.Recorder,
+		})
+	}
+	return s.UnitOfWork.Do(fn)
+}
+
+func generateRefundID() string {
+	return "ref_" + rand.Text()
+}
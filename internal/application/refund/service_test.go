@@ -0,0 +1,177 @@
+package refund_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	domainRefund "github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+type fakeRecorder struct {
+	recorded []event.Event
+}
+
+func (f *fakeRecorder) Record(evt event.Event) error {
+	f.recorded = append(f.recorded, evt)
+	return nil
+}
+
+func setupService(t *testing.T, status payment.Status) (*refund.Service, *fakeRecorder) {
+	t.Helper()
+
+	invoices := inmemory.NewInvoiceRepository()
+	err := invoices.Save(&domainInvoice.Invoice{
+		ID:     "inv-1",
+		Amount: money.Money{Amount: 1000, Currency: money.BRL},
+		Status: domainInvoice.StatusPaid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payments := inmemory.NewPaymentRepository()
+	err = payments.Save(&payment.Payment{
+		ID:             "pay-1",
+		InvoiceID:      "inv-1",
+		Amount:         money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:        1,
+		Status:         status,
+		IdempotencyKey: "payment:inv-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &fakeRecorder{}
+
+	return &refund.Service{
+		Invoices: invoices,
+		Payments: payments,
+		Refunds:  inmemory.NewRefundRepository(),
+		Recorder: recorder,
+	}, recorder
+}
+
+func TestService_RequestPartialRefund_ShouldRecordRefundRequested(t *testing.T) {
+	service, recorder := setupService(t, payment.StatusSuccess)
+
+	ref, err := service.RequestPartialRefund("pay-1", money.Money{Amount: 400, Currency: money.BRL}, "damaged")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ref.Status != domainRefund.StatusRequested {
+		t.Errorf("expected status REQUESTED, got %s", ref.Status)
+	}
+
+	if len(recorder.recorded) != 1 || recorder.recorded[0].Type != event.RefundRequested {
+		t.Fatalf("expected a single RefundRequested event, got %v", recorder.recorded)
+	}
+}
+
+func TestService_ShouldNotRefundMoreThanThePayment(t *testing.T) {
+	service, _ := setupService(t, payment.StatusSuccess)
+
+	if _, err := service.RequestPartialRefund("pay-1", money.Money{Amount: 600, Currency: money.BRL}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := service.RequestPartialRefund("pay-1", money.Money{Amount: 401, Currency: money.BRL}, "")
+	if !errors.Is(err, refund.ErrInvalidRefundAmount) {
+		t.Fatalf("expected ErrInvalidRefundAmount, got %v", err)
+	}
+
+	ref, err := service.RequestRefund("pay-1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ref.Amount.Amount != 400 {
+		t.Fatalf("expected full refund of the remaining 400, got %d", ref.Amount.Amount)
+	}
+}
+
+func TestService_ShouldRejectRefundOfUnsuccessfulPayment(t *testing.T) {
+	service, _ := setupService(t, payment.StatusFailed)
+
+	_, err := service.RequestRefund("pay-1", "")
+	if !errors.Is(err, refund.ErrPaymentNotRefundable) {
+		t.Fatalf("expected ErrPaymentNotRefundable, got %v", err)
+	}
+}
+
+func TestService_ShouldRejectRefundWhileInvoiceCannotRecordIt(t *testing.T) {
+	for _, status := range []domainInvoice.Status{
+		domainInvoice.StatusProcessing,
+		domainInvoice.StatusFailed,
+		domainInvoice.StatusOverdue,
+	} {
+		t.Run(string(status), func(t *testing.T) {
+			service, recorder := setupService(t, payment.StatusSuccess)
+			inv, err := service.Invoices.FindByID("inv-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			inv.Status = status
+			if err := service.Invoices.Update(inv); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = service.RequestRefund("pay-1", "")
+			if !errors.Is(err, refund.ErrPaymentNotRefundable) {
+				t.Fatalf("expected ErrPaymentNotRefundable, got %v", err)
+			}
+			if len(recorder.recorded) != 0 {
+				t.Fatalf("expected no event, got %v", recorder.recorded)
+			}
+		})
+	}
+}
+
+func TestService_ConcurrentRequestsShouldNotRefundMoreThanThePayment(t *testing.T) {
+	service, _ := setupService(t, payment.StatusSuccess)
+	outbox := inmemory.NewOutboxRepository()
+	service.UnitOfWork = inmemory.NewUnitOfWork(
+		service.Invoices.(*inmemory.InvoiceRepository),
+		service.Payments.(*inmemory.PaymentRepository),
+		service.Refunds.(*inmemory.RefundRepository),
+		inmemory.NewScheduledPaymentRepository(),
+		outbox,
+	)
+
+	errs := make([]error, 10)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Go(func() {
+			_, errs[i] = service.RequestPartialRefund("pay-1", money.Money{Amount: 400, Currency: money.BRL}, "")
+		})
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, refund.ErrInvalidRefundAmount):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if succeeded != 2 {
+		t.Fatalf("expected 2 refunds of 400 to fit in 1000, got %d", succeeded)
+	}
+
+	refunds, err := service.Refunds.FindByPaymentID("pay-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds) != 2 {
+		t.Fatalf("expected 2 refunds to be saved, got %d", len(refunds))
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+// PaymentRequest is what a PaymentExecutor needs to charge one attempt.
+// Gateways must deduplicate charges by IdempotencyKey.
+type PaymentRequest struct {
+	PaymentID      string
+	InvoiceID      string
+	Amount         money.Money
+	IdempotencyKey string
+	PaymentMethod  string
+	Attempt        int
+}
+
+type GatewayResult struct {
+	Approved         bool
+	GatewayReference string
+	DeclineCode      payment.DeclineCode
+	Message          string
+	Retryable        bool
+}
+
+// declined builds the result of a refused charge, classifying it by its
+// decline code.
+func declined(code payment.DeclineCode, message string) GatewayResult {
+	return GatewayResult{
+		DeclineCode: code,
+		Message:     message,
+		Retryable:   code.Retryable(),
+	}
+}
+
+// RefundRequest is what a RefundExecutor needs to return all or part of a
+// charge. Gateways must deduplicate refunds by IdempotencyKey.
+type RefundRequest struct {
+	RefundID  string
+	PaymentID string
+	InvoiceID string
+	Amount    money.Money
+	// ChargeReference is the gateway reference of the charge refunded.
+	ChargeReference string
+	IdempotencyKey  string
+}
+
+type RefundResult struct {
+	Approved         bool
+	GatewayReference string
+	Message          string
+}
@@ -1,14 +1,37 @@
 package worker
 
 import (
+	"crypto/rand"
 	"fmt"
-	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 )
 
-func generateIdempotencyKey(invoiceID string) string {
-	return fmt.Sprintf("payment:%s", invoiceID)
+// generateIdempotencyKey scopes the key to the payment requested for the
+// invoice, so each installment of the same invoice is recorded once.
+func generateIdempotencyKey(payload event.PaymentRequestPayload) string {
+	if payload.PaymentID == "" {
+		return fmt.Sprintf("payment:%s", payload.InvoiceID)
+	}
+	return fmt.Sprintf("payment:%s:%s", payload.InvoiceID, payload.PaymentID)
+}
+
+// generateAttemptKey derives the key sent to the gateway, so each attempt of
+// a payment is charged once while retries are charged again.
+func generateAttemptKey(paymentKey string, attempt int) string {
+	return fmt.Sprintf("%s:attempt:%d", paymentKey, attempt)
+}
+
+// generateRefundKey derives the key sent to the gateway, so each refund is
+// made once however often it is executed.
+func generateRefundKey(refundID string) string {
+	return fmt.Sprintf("refund:%s", refundID)
 }
 
 func generatePaymentID() string {
-	return fmt.Sprintf("pay_%d", time.Now().UnixNano())
+	return "pay_" + rand.Text()
+}
+
+func generateRefundID() string {
+	return "ref_" + rand.Text()
 }
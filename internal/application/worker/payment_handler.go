@@ -1,11 +1,16 @@
 package worker
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infra/logging"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infra/metrics"
 )
@@ -17,6 +22,16 @@ type PaymentProcessor struct {
 	Logger   logging.Logger
 	Metrics  *metrics.Counters
 	Executor PaymentExecutor
+	// Invoices and Refunds stop the payments of canceled invoices outside a
+	// unit of work; if unset there, payments are charged whatever their
+	// invoice's status.
+	Invoices invoice.Repository
+	Refunds  refund.Repository
+	// UnitOfWork, if set, makes every payment change commit together with
+	// the event recording it.
+	UnitOfWork contracts.UnitOfWork
+	// Timeout bounds each gateway call; zero means no deadline.
+	Timeout time.Duration
 }
 
 type EventPublisher interface {
@@ -34,77 +49,309 @@ func (p *PaymentProcessor) Handle(evt event.Event) error {
 	}
 
 	p.Logger.Info("processing payment", map[string]any{
-		"invoice-id": payload.InvoiceID,
-		"attempt":    payload.Attempt,
+		"invoice-id":     payload.InvoiceID,
+		"attempt":        payload.Attempt,
+		"event-id":       evt.ID,
+		"correlation-id": evt.CorrelationID,
 	})
 
-	idempotencyKey := generateIdempotencyKey(payload.InvoiceID)
+	var canceled bool
+	err := p.inTransaction(func(tx contracts.Repositories) error {
+		var err error
+		canceled, err = invoiceCanceled(tx, payload.InvoiceID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if canceled {
+		p.Logger.Info("payment of canceled invoice skipped", map[string]any{
+			"invoice-id": payload.InvoiceID,
+			"attempt":    payload.Attempt,
+		})
+		return nil
+	}
 
-	paymentID := generatePaymentID()
+	pay, err := p.findOrCreatePayment(payload)
+	if err != nil {
+		return err
+	}
 
-	pay := &payment.Payment{
-		ID:             paymentID,
-		InvoiceID:      payload.InvoiceID,
-		Attempt:        1,
+	attempt := &payment.Attempt{
+		PaymentID:      pay.ID,
+		InvoiceID:      pay.InvoiceID,
+		Number:         payload.Attempt,
 		Status:         payment.StatusProcessing,
-		IdempotencyKey: idempotencyKey,
+		IdempotencyKey: generateAttemptKey(pay.IdempotencyKey, payload.Attempt),
+		CreatedAt:      time.Now(),
 	}
 
-	saved, err := p.Repo.SaveIfNotExist(pay)
+	run, err := p.startAttempt(pay, attempt)
 	if err != nil {
 		return err
 	}
 
-	if !saved {
+	// the attempt was already made; this is a redelivered request
+	if !run {
 		return nil
 	}
 
-	success := p.Executor.Execute()
+	result, err := p.execute(pay, attempt, payload)
+	if err != nil {
+		// the gateway may have charged the attempt: it stays PROCESSING and
+		// the redelivered request charges it again with the same key, a new
+		// attempt only follows a definite decline
+		p.Logger.Error("payment outcome unknown", map[string]any{
+			"payment_id": pay.ID,
+			"invoice_id": payload.InvoiceID,
+			"attempt":    payload.Attempt,
+			"error":      err.Error(),
+		})
+		return err
+	}
+
+	attempt.DeclineCode = result.DeclineCode
+	attempt.GatewayReference = result.GatewayReference
 
 	p.Metrics.IncProcessed()
 
-	if success {
+	if result.Approved {
 		p.Metrics.IncSucceeded()
 		p.Logger.Info("payment succeeded", map[string]any{
-			"payment-id": pay.ID,
-			"invoice-id": payload.InvoiceID,
-			"attempt":    payload.Attempt,
+			"payment-id":        pay.ID,
+			"invoice-id":        payload.InvoiceID,
+			"attempt":           payload.Attempt,
+			"gateway-reference": result.GatewayReference,
 		})
-		p.Repo.UpdateStatus(pay.ID, payment.StatusSuccess)
+		attempt.Status = payment.StatusSuccess
 
-		return p.Recorder.Record(event.Event{
-			Type: event.PaymentSucceeded,
-			Payload: event.PaymentSucceededPayload{
-				InvoiceID: payload.InvoiceID,
-				PaymentID: pay.ID,
+		succeeded := event.CausedBy(evt,
+			event.PaymentSucceeded,
+			event.Payment(pay.ID),
+			event.PaymentSucceededPayload{
+				InvoiceID:        payload.InvoiceID,
+				PaymentID:        pay.ID,
+				Amount:           pay.Amount,
+				GatewayReference: result.GatewayReference,
 			},
+		)
+
+		// the invoice may have been canceled while it was being charged
+		return p.inTransaction(func(tx contracts.Repositories) error {
+			if err := finishAttempt(tx, pay, attempt, succeeded); err != nil {
+				return err
+			}
+			canceled, err := invoiceCanceled(tx, pay.InvoiceID)
+			if err != nil || !canceled {
+				return err
+			}
+			return refundPayment(tx, pay, succeeded)
 		})
 	}
 
 	p.Metrics.IncFailed()
 
 	p.Logger.Error("payment failed", map[string]any{
-		"payment_id": pay.ID,
-		"invoice_id": payload.InvoiceID,
-		"attempt":    payload.Attempt,
-		"retryable":  true,
+		"payment_id":   pay.ID,
+		"invoice_id":   payload.InvoiceID,
+		"attempt":      payload.Attempt,
+		"decline_code": result.DeclineCode,
+		"retryable":    result.Retryable,
 	})
 
-	p.Repo.UpdateStatus(pay.ID, payment.StatusFailed)
+	attempt.Status = payment.StatusFailed
+
+	failed := event.CausedBy(evt, event.PaymentFailed, event.Payment(pay.ID), event.PaymentFailedPayload{
+		InvoiceID:        payload.InvoiceID,
+		PaymentID:        pay.ID,
+		Attempt:          payload.Attempt,
+		Retryable:        result.Retryable,
+		Reason:           result.Message,
+		DeclineCode:      string(result.DeclineCode),
+		GatewayReference: result.GatewayReference,
+	})
+
+	// the retry is queued with the failure, so a failure is never stored
+	// without its retry
+	return p.inTransaction(func(tx contracts.Repositories) error {
+		if err := finishAttempt(tx, pay, attempt, failed); err != nil {
+			return err
+		}
+		if !result.Retryable {
+			return nil
+		}
+		canceled, err := invoiceCanceled(tx, pay.InvoiceID)
+		if err != nil || canceled {
+			return err
+		}
+		return p.Retry.Schedule(tx, failed, payload, result.DeclineCode)
+	})
+}
+
+// startAttempt records the attempt and marks the payment PROCESSING
+// together. It reports whether the attempt is to be charged: a new attempt
+// is, and so is one a redelivered request finds still PROCESSING, since its
+// outcome was never stored. That one is charged again with its stored key,
+// so the gateway charges it once. Finished attempts are not charged again.
+func (p *PaymentProcessor) startAttempt(pay *payment.Payment, attempt *payment.Attempt) (bool, error) {
+	var run bool
+
+	err := p.inTransaction(func(tx contracts.Repositories) error {
+		saved, err := tx.Payments.SaveAttemptIfNotExist(attempt)
+		if err != nil {
+			return err
+		}
+
+		if !saved {
+			stored, err := findAttempt(tx.Payments, pay.ID, attempt.Number)
+			if err != nil {
+				return err
+			}
+			if run = stored.Status == payment.StatusProcessing; run {
+				*attempt = *stored
+			}
+			return nil
+		}
+		run = true
+
+		if pay.Status == payment.StatusProcessing {
+			return nil
+		}
+		return tx.Payments.UpdateStatus(pay.ID, payment.StatusProcessing)
+	})
+
+	return run, err
+}
+
+func findAttempt(repo payment.Repository, paymentID string, number int) (*payment.Attempt, error) {
+	attempts, err := repo.FindAttempts(paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attempts {
+		if a.Number == number {
+			return a, nil
+		}
+	}
+
+	return nil, fmt.Errorf("attempt %d of payment %s not found", number, paymentID)
+}
+
+// finishAttempt stores the outcome of the attempt and records the event
+// reporting it through tx, so the outcome is never stored without its event
+// or the other way around.
+func finishAttempt(tx contracts.Repositories, pay *payment.Payment, attempt *payment.Attempt, outcome event.Event) error {
+	if err := tx.Payments.UpdateAttempt(attempt); err != nil {
+		return err
+	}
+	if err := tx.Payments.UpdateStatus(pay.ID, attempt.Status); err != nil {
+		return err
+	}
+	return tx.Events.Record(outcome)
+}
+
+// invoiceCanceled reports whether the invoice was canceled, which stops its
+// payments. It is always false if tx has no Invoices.
+func invoiceCanceled(tx contracts.Repositories, invoiceID string) (bool, error) {
+	if tx.Invoices == nil {
+		return false, nil
+	}
 
-	failPayload := event.PaymentFailedPayload{
-		InvoiceID: payload.InvoiceID,
+	inv, err := tx.Invoices.FindByID(invoiceID)
+	if err != nil {
+		return false, err
+	}
+
+	return inv.Status == invoice.StatusCanceled, nil
+}
+
+// refundPayment requests a refund of the whole payment, which succeeded for
+// an invoice canceled meanwhile; cause is the event reporting the success.
+func refundPayment(tx contracts.Repositories, pay *payment.Payment, cause event.Event) error {
+	ref := &refund.Refund{
+		ID:        generateRefundID(),
 		PaymentID: pay.ID,
-		Retryable: true,
-		Reason:    "temporary failure",
+		InvoiceID: pay.InvoiceID,
+		Amount:    pay.Amount,
+		Reason:    "invoice canceled",
+		Status:    refund.StatusRequested,
 	}
 
-	p.Recorder.Record(event.Event{
-		Type:    event.PaymentFailed,
-		Payload: failPayload,
-	})
+	if err := tx.Refunds.Save(ref); err != nil {
+		return err
+	}
+
+	return tx.Events.Record(event.CausedBy(cause,
+		event.RefundRequested,
+		event.Refund(ref.ID),
+		event.RefundRequestedPayload{
+			RefundID:  ref.ID,
+			PaymentID: ref.PaymentID,
+			InvoiceID: ref.InvoiceID,
+			Amount:    ref.Amount,
+			Reason:    ref.Reason,
+		},
+	))
+}
 
-	p.Retry.Schedule(payload)
+// inTransaction runs fn in the unit of work, or straight against Repo,
+// Invoices, Refunds and Recorder if there is none.
+func (p *PaymentProcessor) inTransaction(fn func(contracts.Repositories) error) error {
+	if p.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Invoices: p.Invoices,
+			Payments: p.Repo,
+			Refunds:  p.Refunds,
+			Events:   p.Recorder,
+		})
+	}
+	return p.UnitOfWork.Do(fn)
+}
+
+// findOrCreatePayment returns the payment the attempt belongs to, creating it
+// on its first attempt.
+func (p *PaymentProcessor) findOrCreatePayment(payload event.PaymentRequestPayload) (*payment.Payment, error) {
+	paymentID := payload.PaymentID
+	if paymentID == "" {
+		paymentID = generatePaymentID()
+	}
+
+	pay := &payment.Payment{
+		ID:             paymentID,
+		InvoiceID:      payload.InvoiceID,
+		Amount:         payload.Amount,
+		Status:         payment.StatusProcessing,
+		IdempotencyKey: generateIdempotencyKey(payload),
+	}
 
-	return nil
+	saved, err := p.Repo.SaveIfNotExist(pay)
+	if err != nil {
+		return nil, err
+	}
+	if saved {
+		return pay, nil
+	}
+
+	return p.Repo.FindByIdempotencyKey(pay.IdempotencyKey)
+}
+
+// execute charges the attempt. An error means the gateway outcome is
+// unknown.
+func (p *PaymentProcessor) execute(pay *payment.Payment, attempt *payment.Attempt, payload event.PaymentRequestPayload) (GatewayResult, error) {
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	return p.Executor.Execute(ctx, PaymentRequest{
+		PaymentID:      pay.ID,
+		InvoiceID:      pay.InvoiceID,
+		Amount:         pay.Amount,
+		IdempotencyKey: attempt.IdempotencyKey,
+		PaymentMethod:  payload.PaymentMethod,
+		Attempt:        attempt.Number,
+	})
 }
@@ -3,39 +3,37 @@ package worker_test
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
-	_ "modernc.org/sqlite"
 
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
 	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infra/metrics"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/eventbus"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
 )
 
 func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("sqlite", ":memory:")
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "outbox.db"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	t.Cleanup(func() { db.Close() })
 
-	schema := `
-	CREATE TABLE outbox_events (
-		id TEXT PRIMARY KEY,
-		event_type TEXT NOT NULL,
-		payload BLOB NOT NULL,
-		published INTEGER NOT NULL DEFAULT 0,
-		created_at DATETIME NOT NULL
-	);
-	`
-
-	if _, err := db.Exec(schema); err != nil {
+	if err := sqlite.RunMigrations(db); err != nil {
 		t.Fatal(err)
 	}
 
@@ -46,8 +44,9 @@ type fakeRetry struct {
 	scheduleFn func(event.PaymentRequestPayload)
 }
 
-func (f *fakeRetry) Schedule(payload event.PaymentRequestPayload) {
+func (f *fakeRetry) Schedule(_ contracts.Repositories, _ event.Event, payload event.PaymentRequestPayload, _ payment.DeclineCode) error {
 	f.scheduleFn(payload)
+	return nil
 }
 
 type fakeRecorder struct {
@@ -60,10 +59,20 @@ func (f *fakeRecorder) Record(evt event.Event) error {
 
 type fakeExecutor struct {
 	executeFn func() bool
+	resultFn  func(worker.PaymentRequest) (worker.GatewayResult, error)
 }
 
-func (f *fakeExecutor) Execute() bool {
-	return f.executeFn()
+func (f *fakeExecutor) Execute(ctx context.Context, req worker.PaymentRequest) (worker.GatewayResult, error) {
+	if f.resultFn != nil {
+		return f.resultFn(req)
+	}
+	if f.executeFn() {
+		return worker.GatewayResult{Approved: true}, nil
+	}
+	return worker.GatewayResult{
+		DeclineCode: payment.DeclineProcessingError,
+		Retryable:   true,
+	}, nil
 }
 
 type noopLogger struct{}
@@ -109,7 +118,7 @@ func TestPaymentProcessor_WhenPaymentSucceeds_ShouldSavePaymentAndPublishEvent(t
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-1",
-			Amount:    1000,
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -194,7 +203,7 @@ func TestPaymentProcessor_WhenPaymentFails_ShouldPublishFailureAndScheduleRetry(
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-1",
-			Amount:    1000,
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -264,7 +273,7 @@ func TestPaymentProcessor_ShouldBiIdempotent_ForSameInvoice(t *testing.T) {
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-123",
-			Amount:    500,
+			Amount:    money.Money{Amount: 500, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -314,7 +323,7 @@ func TestPaymentProcessor_ShouldNotCreateDuplicatePayments_WhenEventsAreConcurre
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-race",
-			Amount:    1000,
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -378,7 +387,7 @@ func TestPaymentProcessor_ShouldEmitCorrectMetrics_OnSuccess(t *testing.T) {
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-metrics-ok",
-			Amount:    1000,
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -438,7 +447,7 @@ func TestPaymentProcessor_ShouldEmitCorrectMetrics_OnFailure(t *testing.T) {
 		Type: event.PaymentRequested,
 		Payload: event.PaymentRequestPayload{
 			InvoiceID: "inv-metrics-ok",
-			Amount:    1000,
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
 			Attempt:   1,
 		},
 	}
@@ -477,15 +486,16 @@ func TestPaymentFailureTriggersRetryAndEventuallySucceeded(t *testing.T) {
 	logger := &noopLogger{}
 
 	retry := &worker.RetryScheduler{
-		EventBus:  bus,
-		MaxRetry:  3,
-		BaseDelay: 1 * time.Millisecond,
-		MaxDelay:  5 * time.Millisecond,
+		Store:        inmemory.NewScheduledPaymentRepository(),
+		Recorder:     &fakeRecorder{recordFn: bus.Publish},
+		Policy:       worker.ExponentialBackoff{Base: 1 * time.Millisecond, Max: 5 * time.Millisecond, MaxAttempts: 3},
+		PollInterval: 1 * time.Millisecond,
+		BatchSize:    10,
 	}
 
 	db := setupTestDB(t)
 
-	outboxDB := outbox.NewSQLiteRepository(db)
+	outboxDB := sqlite.NewOutboxRepository(db)
 
 	dispatcher := outbox.Dispatcher{
 		Repo:         outboxDB,
@@ -494,13 +504,24 @@ func TestPaymentFailureTriggersRetryAndEventuallySucceeded(t *testing.T) {
 		BatchSize:    1,
 	}
 
-	ctx := context.Background()
+	// the workers must stop before the database is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	stop := func() {
+		cancel()
+		workers.Wait()
+	}
+	t.Cleanup(stop)
 
-	go func() {
+	workers.Go(func() {
 		dispatcher.Run(ctx)
-	}()
+	})
+
+	workers.Go(func() {
+		retry.Run(ctx)
+	})
 
-	recorder := outbox.Recorder{outboxDB}
+	recorder := outbox.Recorder{Repo: outboxDB}
 
 	processor := &worker.PaymentProcessor{
 		Repo:     repo,
@@ -511,11 +532,11 @@ func TestPaymentFailureTriggersRetryAndEventuallySucceeded(t *testing.T) {
 		Executor: executor,
 	}
 
-	bus.Subscribe(event.PaymentRequested, processor.Handle)
+	require.NoError(t, bus.Subscribe(event.PaymentRequested, processor.Handle))
 
 	payload := event.PaymentRequestPayload{
 		InvoiceID: "inv-123",
-		Amount:    100,
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
 		Attempt:   1,
 	}
 
@@ -526,14 +547,379 @@ func TestPaymentFailureTriggersRetryAndEventuallySucceeded(t *testing.T) {
 
 	require.NoError(t, err)
 
-	time.Sleep(20 * time.Millisecond)
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint64(&metrics.PaymentsSucceeded) == 1
+	}, time.Second, time.Millisecond)
+	stop()
 
-	require.Equal(t, uint64(2), metrics.PaymentsProcessed)
-	require.Equal(t, uint64(1), metrics.PaymentsFailed)
-	require.Equal(t, uint64(1), metrics.PaymentsSucceeded)
+	require.Equal(t, uint64(2), atomic.LoadUint64(&metrics.PaymentsProcessed))
+	require.Equal(t, uint64(1), atomic.LoadUint64(&metrics.PaymentsFailed))
+	require.Equal(t, uint64(1), atomic.LoadUint64(&metrics.PaymentsSucceeded))
 
 	p, err := repo.FindByIdempotencyKey("payment:inv-123")
 	require.NoError(t, err)
 	require.Equal(t, p.Status, payment.StatusSuccess)
-	ctx.Done()
+	require.Equal(t, 2, p.Attempt)
+
+	attempts, err := repo.FindAttempts(p.ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	require.Equal(t, payment.StatusFailed, attempts[0].Status)
+	require.Equal(t, payment.DeclineProcessingError, attempts[0].DeclineCode)
+	require.Equal(t, payment.StatusSuccess, attempts[1].Status)
+	require.NotEqual(t, attempts[0].IdempotencyKey, attempts[1].IdempotencyKey)
+}
+
+func TestPaymentProcessor_WhenDeclineIsNotRetryable_ShouldNotScheduleRetry(t *testing.T) {
+	repo := inmemory.NewPaymentRepository()
+
+	publishedEvents := []event.Event{}
+	recorder := &fakeRecorder{
+		recordFn: func(evt event.Event) error {
+			publishedEvents = append(publishedEvents, evt)
+			return nil
+		},
+	}
+
+	var received worker.PaymentRequest
+	executor := &fakeExecutor{
+		resultFn: func(req worker.PaymentRequest) (worker.GatewayResult, error) {
+			received = req
+			return worker.GatewayResult{
+				GatewayReference: "gw-1",
+				DeclineCode:      payment.DeclineCardStolen,
+				Message:          "card reported stolen",
+				Retryable:        false,
+			}, nil
+		},
+	}
+
+	retryCalled := false
+	retry := &fakeRetry{
+		scheduleFn: func(event.PaymentRequestPayload) {
+			retryCalled = true
+		},
+	}
+
+	processor := &worker.PaymentProcessor{
+		Repo:     repo,
+		Recorder: recorder,
+		Retry:    retry,
+		Logger:   &noopLogger{},
+		Metrics:  &metrics.Counters{},
+		Executor: executor,
+	}
+
+	err := processor.Handle(event.Event{
+		Type: event.PaymentRequested,
+		Payload: event.PaymentRequestPayload{
+			InvoiceID:     "inv-1",
+			PaymentID:     "pay-1",
+			Amount:        money.Money{Amount: 1000, Currency: money.BRL},
+			PaymentMethod: "card_123",
+			Attempt:       1,
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "pay-1", received.PaymentID)
+	require.Equal(t, "card_123", received.PaymentMethod)
+	require.Equal(t, money.Money{Amount: 1000, Currency: money.BRL}, received.Amount)
+	require.NotEmpty(t, received.IdempotencyKey)
+
+	require.False(t, retryCalled)
+	require.Len(t, publishedEvents, 1)
+
+	failed, ok := publishedEvents[0].Payload.(event.PaymentFailedPayload)
+	require.True(t, ok)
+	require.False(t, failed.Retryable)
+	require.Equal(t, string(payment.DeclineCardStolen), failed.DeclineCode)
+	require.Equal(t, "gw-1", failed.GatewayReference)
+	require.Equal(t, "card reported stolen", failed.Reason)
+}
+
+func TestPaymentProcessor_WhenExecutorErrors_ShouldChargeTheSameAttemptAgain(t *testing.T) {
+	repo := inmemory.NewPaymentRepository()
+
+	publishedEvents := []event.Event{}
+	recorder := &fakeRecorder{
+		recordFn: func(evt event.Event) error {
+			publishedEvents = append(publishedEvents, evt)
+			return nil
+		},
+	}
+
+	var requests []worker.PaymentRequest
+	executor := &fakeExecutor{
+		resultFn: func(req worker.PaymentRequest) (worker.GatewayResult, error) {
+			requests = append(requests, req)
+			if len(requests) == 1 {
+				return worker.GatewayResult{}, context.DeadlineExceeded
+			}
+			return worker.GatewayResult{Approved: true}, nil
+		},
+	}
+
+	retry := &fakeRetry{
+		scheduleFn: func(event.PaymentRequestPayload) {
+			t.Fatal("unknown outcome was retried as a new attempt")
+		},
+	}
+
+	processor := &worker.PaymentProcessor{
+		Repo:     repo,
+		Recorder: recorder,
+		Retry:    retry,
+		Logger:   &noopLogger{},
+		Metrics:  &metrics.Counters{},
+		Executor: executor,
+		Timeout:  time.Second,
+	}
+
+	requested := event.Event{
+		Type: event.PaymentRequested,
+		Payload: event.PaymentRequestPayload{
+			InvoiceID: "inv-1",
+			Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+			Attempt:   1,
+		},
+	}
+
+	require.ErrorIs(t, processor.Handle(requested), context.DeadlineExceeded)
+	require.Empty(t, publishedEvents)
+
+	require.NoError(t, processor.Handle(requested))
+
+	require.Len(t, requests, 2)
+	require.Equal(t, requests[0].IdempotencyKey, requests[1].IdempotencyKey)
+	require.Equal(t, requests[0].Attempt, requests[1].Attempt)
+
+	require.Len(t, publishedEvents, 1)
+	require.Equal(t, event.PaymentSucceeded, publishedEvents[0].Type)
+
+	pay, err := repo.FindByIdempotencyKey("payment:inv-1")
+	require.NoError(t, err)
+	attempts, err := repo.FindAttempts(pay.ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, payment.StatusSuccess, attempts[0].Status)
+}
+
+// unitRepositories are in-memory repositories sharing a unit of work.
+type unitRepositories struct {
+	invoices   *inmemory.InvoiceRepository
+	payments   *inmemory.PaymentRepository
+	refunds    *inmemory.RefundRepository
+	scheduled  *inmemory.ScheduledPaymentRepository
+	outbox     *inmemory.OutboxRepository
+	unitOfWork *inmemory.UnitOfWork
+}
+
+// setupUnitRepositories holds inv-1 in the given status.
+func setupUnitRepositories(t *testing.T, status domainInvoice.Status) *unitRepositories {
+	r := &unitRepositories{
+		invoices:  inmemory.NewInvoiceRepository(),
+		payments:  inmemory.NewPaymentRepository(),
+		refunds:   inmemory.NewRefundRepository(),
+		scheduled: inmemory.NewScheduledPaymentRepository(),
+		outbox:    inmemory.NewOutboxRepository(),
+	}
+	r.unitOfWork = inmemory.NewUnitOfWork(r.invoices, r.payments, r.refunds, r.scheduled, r.outbox)
+
+	require.NoError(t, r.invoices.Save(&domainInvoice.Invoice{
+		ID:     "inv-1",
+		Amount: money.Money{Amount: 1000, Currency: money.BRL},
+		Status: status,
+	}))
+
+	return r
+}
+
+func TestPaymentProcessor_WithUnitOfWork_ShouldRecordOutcomeWithPaymentChanges(t *testing.T) {
+	repos := setupUnitRepositories(t, domainInvoice.StatusProcessing)
+
+	processor := &worker.PaymentProcessor{
+		Repo:       repos.payments,
+		Retry:      &fakeRetry{scheduleFn: func(event.PaymentRequestPayload) {}},
+		Logger:     &noopLogger{},
+		Metrics:    &metrics.Counters{},
+		Executor:   &fakeExecutor{executeFn: func() bool { return true }},
+		UnitOfWork: repos.unitOfWork,
+	}
+
+	requested := event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   1,
+	})
+	require.NoError(t, processor.Handle(requested))
+
+	pay, err := repos.payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusSuccess, pay.Status)
+
+	recorded, err := repos.outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.PaymentSucceeded, recorded[0].Type)
+	require.Equal(t, requested.ID, recorded[0].CausationID)
+}
+
+func TestPaymentProcessor_WhenInvoiceIsCanceled_ShouldNotCharge(t *testing.T) {
+	repos := setupUnitRepositories(t, domainInvoice.StatusCanceled)
+
+	processor := &worker.PaymentProcessor{
+		Repo:    repos.payments,
+		Logger:  &noopLogger{},
+		Metrics: &metrics.Counters{},
+		Executor: &fakeExecutor{executeFn: func() bool {
+			t.Fatal("canceled invoice was charged")
+			return false
+		}},
+		UnitOfWork: repos.unitOfWork,
+	}
+
+	require.NoError(t, processor.Handle(event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   2,
+	})))
+
+	require.Empty(t, repos.payments.Payments())
+
+	recorded, err := repos.outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Empty(t, recorded)
+}
+
+func TestPaymentProcessor_WhenInvoiceIsCanceledDuringTheCharge_ShouldRefundTheSuccess(t *testing.T) {
+	repos := setupUnitRepositories(t, domainInvoice.StatusProcessing)
+
+	processor := &worker.PaymentProcessor{
+		Repo:    repos.payments,
+		Logger:  &noopLogger{},
+		Metrics: &metrics.Counters{},
+		Executor: &fakeExecutor{executeFn: func() bool {
+			require.NoError(t, repos.invoices.UpdateStatus("inv-1", domainInvoice.StatusProcessing, domainInvoice.StatusCanceled))
+			return true
+		}},
+		UnitOfWork: repos.unitOfWork,
+	}
+
+	require.NoError(t, processor.Handle(event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   1,
+	})))
+
+	refunds, err := repos.refunds.FindByPaymentID("pay-1")
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	require.Equal(t, refund.StatusRequested, refunds[0].Status)
+	require.Equal(t, money.Money{Amount: 1000, Currency: money.BRL}, refunds[0].Amount)
+
+	recorded, err := repos.outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, recorded, 2)
+	require.Equal(t, event.PaymentSucceeded, recorded[0].Type)
+	require.Equal(t, event.RefundRequested, recorded[1].Type)
+	require.Equal(t, recorded[0].ID, recorded[1].CausationID)
+}
+
+func TestPaymentProcessor_WhenInvoiceIsCanceledDuringTheCharge_ShouldNotRetryTheFailure(t *testing.T) {
+	repos := setupUnitRepositories(t, domainInvoice.StatusProcessing)
+
+	processor := &worker.PaymentProcessor{
+		Repo: repos.payments,
+		Retry: &fakeRetry{scheduleFn: func(event.PaymentRequestPayload) {
+			t.Fatal("failure of a canceled invoice was retried")
+		}},
+		Logger:  &noopLogger{},
+		Metrics: &metrics.Counters{},
+		Executor: &fakeExecutor{executeFn: func() bool {
+			require.NoError(t, repos.invoices.UpdateStatus("inv-1", domainInvoice.StatusProcessing, domainInvoice.StatusCanceled))
+			return false
+		}},
+		UnitOfWork: repos.unitOfWork,
+	}
+
+	require.NoError(t, processor.Handle(event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   1,
+	})))
+
+	pay, err := repos.payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusFailed, pay.Status)
+}
+
+// flakyUnitOfWork fails the first fail events recorded through it, rolling
+// their unit back.
+type flakyUnitOfWork struct {
+	contracts.UnitOfWork
+	fail int
+}
+
+func (u *flakyUnitOfWork) Do(fn func(contracts.Repositories) error) error {
+	return u.UnitOfWork.Do(func(tx contracts.Repositories) error {
+		events := tx.Events
+		tx.Events = &fakeRecorder{recordFn: func(evt event.Event) error {
+			if u.fail > 0 {
+				u.fail--
+				return errors.New("outbox unavailable")
+			}
+			return events.Record(evt)
+		}}
+		return fn(tx)
+	})
+}
+
+func TestPaymentProcessor_WhenOutcomeIsNotStored_ShouldChargeTheRedeliveredAttemptWithItsKey(t *testing.T) {
+	repos := setupUnitRepositories(t, domainInvoice.StatusProcessing)
+
+	var keys []string
+	processor := &worker.PaymentProcessor{
+		Repo:    repos.payments,
+		Logger:  &noopLogger{},
+		Metrics: &metrics.Counters{},
+		Executor: &fakeExecutor{resultFn: func(req worker.PaymentRequest) (worker.GatewayResult, error) {
+			keys = append(keys, req.IdempotencyKey)
+			return worker.GatewayResult{Approved: true}, nil
+		}},
+		UnitOfWork: &flakyUnitOfWork{UnitOfWork: repos.unitOfWork, fail: 1},
+	}
+
+	requested := event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   1,
+	})
+	require.Error(t, processor.Handle(requested))
+
+	attempts, err := repos.payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, payment.StatusProcessing, attempts[0].Status)
+
+	require.NoError(t, processor.Handle(requested))
+	require.NoError(t, processor.Handle(requested))
+
+	require.Len(t, keys, 2)
+	require.Equal(t, keys[0], keys[1])
+
+	attempts, err = repos.payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, payment.StatusSuccess, attempts[0].Status)
+
+	recorded, err := repos.outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.PaymentSucceeded, recorded[0].Type)
 }
@@ -1,6 +1,12 @@
 package worker
 
-import "github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+import (
+	"context"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
 
 type PaymentWorker struct {
 	Handler PaymentHandler
@@ -10,10 +16,23 @@ type PaymentHandler interface {
 	Handle(event.Event) error
 }
 
+// PaymentExecutor charges a payment attempt through a gateway. An error means
+// the outcome is unknown (timeouts, network failures) and the attempt may be
+// retried with the same idempotency key.
 type PaymentExecutor interface {
-	Execute() bool
+	Execute(ctx context.Context, req PaymentRequest) (GatewayResult, error)
+}
+
+// RefundExecutor returns a charge through a gateway. An error means the
+// outcome is unknown and the refund may be retried with the same idempotency
+// key.
+type RefundExecutor interface {
+	Execute(ctx context.Context, req RefundRequest) (RefundResult, error)
 }
 
 type Scheduler interface {
-	Schedule(event.PaymentRequestPayload)
+	// Schedule queues the next attempt of the failed payment request through
+	// tx, so it commits along with the failure; cause is the event reporting
+	// the failure. tx.Scheduled is nil outside a unit of work.
+	Schedule(tx contracts.Repositories, cause event.Event, payload event.PaymentRequestPayload, decline payment.DeclineCode) error
 }
@@ -1,11 +1,52 @@
 package worker
 
 import (
+	"context"
+	"fmt"
 	"math/rand"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
 )
 
+var randomDeclines = []payment.DeclineCode{
+	payment.DeclineInsufficientFunds,
+	payment.DeclineDoNotHonor,
+	payment.DeclineProcessingError,
+	payment.DeclineExpiredCard,
+	payment.DeclineCardStolen,
+}
+
 type RandomPaymentExecutor struct{}
 
-func (r *RandomPaymentExecutor) Execute() bool {
-	return rand.Intn(100) < 70
+func (r *RandomPaymentExecutor) Execute(ctx context.Context, req PaymentRequest) (GatewayResult, error) {
+	if err := ctx.Err(); err != nil {
+		return GatewayResult{}, err
+	}
+
+	reference := fmt.Sprintf("rnd_%d", time.Now().UnixNano())
+
+	if rand.Intn(100) < 70 {
+		return GatewayResult{Approved: true, GatewayReference: reference}, nil
+	}
+
+	result := declined(randomDeclines[rand.Intn(len(randomDeclines))], "random decline")
+	result.GatewayReference = reference
+	return result, nil
+}
+
+type RandomRefundExecutor struct{}
+
+func (r *RandomRefundExecutor) Execute(ctx context.Context, req RefundRequest) (RefundResult, error) {
+	if err := ctx.Err(); err != nil {
+		return RefundResult{}, err
+	}
+
+	reference := fmt.Sprintf("rnd_%d", time.Now().UnixNano())
+
+	if rand.Intn(100) < 90 {
+		return RefundResult{Approved: true, GatewayReference: reference}, nil
+	}
+
+	return RefundResult{GatewayReference: reference, Message: "random decline"}, nil
 }
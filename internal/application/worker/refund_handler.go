@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infra/logging"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infra/metrics"
+)
+
+type RefundProcessor struct {
+	Repo refund.Repository
+	// Payments looks up the charge each refund returns.
+	Payments payment.Repository
+	Recorder contracts.EventRecorder
+	Logger   logging.Logger
+	Metrics  *metrics.Counters
+	Executor RefundExecutor
+	// UnitOfWork, if set, makes the outcome of a refund commit together
+	// with the event recording it.
+	UnitOfWork contracts.UnitOfWork
+	// Timeout bounds each gateway call; zero means no deadline.
+	Timeout time.Duration
+}
+
+func (p *RefundProcessor) Handle(evt event.Event) error {
+	if evt.Type != event.RefundRequested {
+		return nil
+	}
+
+	payload, ok := evt.Payload.(event.RefundRequestedPayload)
+	if !ok {
+		return errors.New("invalid payload for RefundRequested")
+	}
+
+	p.Logger.Info("processing refund", map[string]any{
+		"refund-id":  payload.RefundID,
+		"payment-id": payload.PaymentID,
+		"invoice-id": payload.InvoiceID,
+	})
+
+	run, err := p.claim(payload.RefundID)
+	if err != nil || !run {
+		return err
+	}
+
+	result, err := p.execute(payload)
+	if err != nil {
+		// the gateway may have made the refund: it stays PROCESSING
+		p.Logger.Error("refund outcome unknown", map[string]any{
+			"refund-id":  payload.RefundID,
+			"payment-id": payload.PaymentID,
+			"error":      err.Error(),
+		})
+		return err
+	}
+
+	p.Metrics.IncRefundsProcessed()
+
+	if result.Approved {
+		p.Metrics.IncRefundsSucceeded()
+		p.Logger.Info("refund succeeded", map[string]any{
+			"refund-id":         payload.RefundID,
+			"payment-id":        payload.PaymentID,
+			"gateway-reference": result.GatewayReference,
+		})
+
+		return p.finish(payload.RefundID, refund.StatusSucceeded, event.CausedBy(evt,
+			event.RefundSucceeded,
+			event.Refund(payload.RefundID),
+			event.RefundSucceededPayload{
+				RefundID:  payload.RefundID,
+				PaymentID: payload.PaymentID,
+				InvoiceID: payload.InvoiceID,
+				Amount:    payload.Amount,
+			},
+		))
+	}
+
+	p.Metrics.IncRefundsFailed()
+	p.Logger.Error("refund failed", map[string]any{
+		"refund-id":         payload.RefundID,
+		"payment-id":        payload.PaymentID,
+		"gateway-reference": result.GatewayReference,
+		"reason":            result.Message,
+	})
+
+	reason := result.Message
+	if reason == "" {
+		reason = "refund declined"
+	}
+
+	return p.finish(payload.RefundID, refund.StatusFailed, event.CausedBy(evt,
+		event.RefundFailed,
+		event.Refund(payload.RefundID),
+		event.RefundFailedPayload{
+			RefundID:  payload.RefundID,
+			PaymentID: payload.PaymentID,
+			InvoiceID: payload.InvoiceID,
+			Amount:    payload.Amount,
+			Reason:    reason,
+		},
+	))
+}
+
+// claim moves the refund to PROCESSING and reports whether it is to be
+// executed. A redelivered request finding it still PROCESSING executes it
+// again, since its outcome was never stored; the refund's key keeps the
+// gateway from making it twice. Finished refunds are not executed again.
+func (p *RefundProcessor) claim(refundID string) (bool, error) {
+	err := p.Repo.UpdateStatus(refundID, refund.StatusRequested, refund.StatusProcessing)
+	if !errors.Is(err, refund.ErrConcurrentUpdate) {
+		return err == nil, err
+	}
+
+	ref, err := p.Repo.FindByID(refundID)
+	if err != nil {
+		return false, err
+	}
+
+	return ref.Status == refund.StatusProcessing, nil
+}
+
+// execute returns the charge of the refunded payment, keyed by the refund so
+// the gateway makes it once. An error means the outcome is unknown.
+func (p *RefundProcessor) execute(payload event.RefundRequestedPayload) (RefundResult, error) {
+	charge, err := chargeReference(p.Payments, payload.PaymentID)
+	if err != nil {
+		return RefundResult{}, err
+	}
+
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	return p.Executor.Execute(ctx, RefundRequest{
+		RefundID:        payload.RefundID,
+		PaymentID:       payload.PaymentID,
+		InvoiceID:       payload.InvoiceID,
+		Amount:          payload.Amount,
+		ChargeReference: charge,
+		IdempotencyKey:  generateRefundKey(payload.RefundID),
+	})
+}
+
+// chargeReference is the gateway reference of the attempt that charged the
+// payment.
+func chargeReference(payments payment.Repository, paymentID string) (string, error) {
+	attempts, err := payments.FindAttempts(paymentID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range attempts {
+		if a.Status == payment.StatusSuccess {
+			return a.GatewayReference, nil
+		}
+	}
+
+	return "", fmt.Errorf("payment %s has no successful attempt", paymentID)
+}
+
+// finish moves the refund out of PROCESSING and records outcome in one
+// unit.
+func (p *RefundProcessor) finish(refundID string, status refund.Status, outcome event.Event) error {
+	return p.inTransaction(func(tx contracts.Repositories) error {
+		if err := tx.Refunds.UpdateStatus(refundID, refund.StatusProcessing, status); err != nil {
+			return err
+		}
+		return tx.Events.Record(outcome)
+	})
+}
+
+// inTransaction runs fn in the unit of work, or straight against Repo and
+// Recorder if there is none.
+func (p *RefundProcessor) inTransaction(fn func(contracts.Repositories) error) error {
+	if p.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Refunds: p.Repo,
+			Events:  p.Recorder,
+		})
+	}
+	return p.UnitOfWork.Do(fn)
+}
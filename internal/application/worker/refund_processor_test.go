@@ -0,0 +1,172 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infra/metrics"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+type fakeRefundExecutor struct {
+	requests []worker.RefundRequest
+	resultFn func(worker.RefundRequest) (worker.RefundResult, error)
+}
+
+func (f *fakeRefundExecutor) Execute(ctx context.Context, req worker.RefundRequest) (worker.RefundResult, error) {
+	f.requests = append(f.requests, req)
+	return f.resultFn(req)
+}
+
+// setupRefundProcessor holds ref-1, a REQUESTED refund of 400 of the
+// payment pay-1, charged as ch_1.
+func setupRefundProcessor(t *testing.T, executor worker.RefundExecutor) (*worker.RefundProcessor, *inmemory.RefundRepository, *recordingRecorder) {
+	t.Helper()
+
+	payments := inmemory.NewPaymentRepository()
+	require.NoError(t, payments.Save(&payment.Payment{
+		ID:             "pay-1",
+		InvoiceID:      "inv-1",
+		Amount:         money.Money{Amount: 1000, Currency: money.BRL},
+		Status:         payment.StatusSuccess,
+		IdempotencyKey: "payment:inv-1:pay-1",
+	}))
+	_, err := payments.SaveAttemptIfNotExist(&payment.Attempt{
+		PaymentID:        "pay-1",
+		InvoiceID:        "inv-1",
+		Number:           1,
+		Status:           payment.StatusSuccess,
+		IdempotencyKey:   "payment:inv-1:pay-1:attempt:1",
+		GatewayReference: "ch_1",
+	})
+	require.NoError(t, err)
+
+	refunds := inmemory.NewRefundRepository()
+	require.NoError(t, refunds.Save(&refund.Refund{
+		ID:        "ref-1",
+		PaymentID: "pay-1",
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 400, Currency: money.BRL},
+		Status:    refund.StatusRequested,
+	}))
+
+	recorder := &recordingRecorder{}
+
+	return &worker.RefundProcessor{
+		Repo:     refunds,
+		Payments: payments,
+		Recorder: recorder,
+		Logger:   &noopLogger{},
+		Metrics:  &metrics.Counters{},
+		Executor: executor,
+	}, refunds, recorder
+}
+
+func refundRequested() event.Event {
+	return event.New(event.RefundRequested, event.Refund("ref-1"), event.RefundRequestedPayload{
+		RefundID:  "ref-1",
+		PaymentID: "pay-1",
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 400, Currency: money.BRL},
+	})
+}
+
+func TestRefundProcessor_ShouldRefundTheChargeOnce(t *testing.T) {
+	executor := &fakeRefundExecutor{resultFn: func(worker.RefundRequest) (worker.RefundResult, error) {
+		return worker.RefundResult{Approved: true, GatewayReference: "re_1"}, nil
+	}}
+	processor, refunds, recorder := setupRefundProcessor(t, executor)
+
+	requested := refundRequested()
+	require.NoError(t, processor.Handle(requested))
+	require.NoError(t, processor.Handle(requested))
+
+	require.Len(t, executor.requests, 1)
+	require.Equal(t, worker.RefundRequest{
+		RefundID:        "ref-1",
+		PaymentID:       "pay-1",
+		InvoiceID:       "inv-1",
+		Amount:          money.Money{Amount: 400, Currency: money.BRL},
+		ChargeReference: "ch_1",
+		IdempotencyKey:  "refund:ref-1",
+	}, executor.requests[0])
+
+	ref, err := refunds.FindByID("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.StatusSucceeded, ref.Status)
+
+	recorded := recorder.events()
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.RefundSucceeded, recorded[0].Type)
+	require.Equal(t, requested.ID, recorded[0].CausationID)
+}
+
+func TestRefundProcessor_WhenDeclined_ShouldRecordTheGatewayReason(t *testing.T) {
+	executor := &fakeRefundExecutor{resultFn: func(worker.RefundRequest) (worker.RefundResult, error) {
+		return worker.RefundResult{Message: "charge already disputed"}, nil
+	}}
+	processor, refunds, recorder := setupRefundProcessor(t, executor)
+
+	require.NoError(t, processor.Handle(refundRequested()))
+
+	ref, err := refunds.FindByID("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.StatusFailed, ref.Status)
+
+	recorded := recorder.events()
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.RefundFailed, recorded[0].Type)
+	require.Equal(t, "charge already disputed", recorded[0].Payload.(event.RefundFailedPayload).Reason)
+}
+
+func TestRefundProcessor_WhenOutcomeIsUnknown_ShouldExecuteTheRedeliveredRefundWithItsKey(t *testing.T) {
+	executor := &fakeRefundExecutor{resultFn: func(worker.RefundRequest) (worker.RefundResult, error) {
+		return worker.RefundResult{}, context.DeadlineExceeded
+	}}
+	processor, refunds, recorder := setupRefundProcessor(t, executor)
+
+	requested := refundRequested()
+	require.ErrorIs(t, processor.Handle(requested), context.DeadlineExceeded)
+
+	ref, err := refunds.FindByID("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.StatusProcessing, ref.Status)
+	require.Empty(t, recorder.events())
+
+	executor.resultFn = func(worker.RefundRequest) (worker.RefundResult, error) {
+		return worker.RefundResult{Approved: true}, nil
+	}
+	require.NoError(t, processor.Handle(requested))
+
+	require.Len(t, executor.requests, 2)
+	require.Equal(t, executor.requests[0].IdempotencyKey, executor.requests[1].IdempotencyKey)
+
+	ref, err = refunds.FindByID("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.StatusSucceeded, ref.Status)
+	require.Len(t, recorder.events(), 1)
+}
+
+func TestRefundProcessor_ShouldExecuteARefundLeftProcessingByACrash(t *testing.T) {
+	executor := &fakeRefundExecutor{resultFn: func(worker.RefundRequest) (worker.RefundResult, error) {
+		return worker.RefundResult{Approved: true}, nil
+	}}
+	processor, refunds, recorder := setupRefundProcessor(t, executor)
+
+	require.NoError(t, refunds.UpdateStatus("ref-1", refund.StatusRequested, refund.StatusProcessing))
+
+	require.NoError(t, processor.Handle(refundRequested()))
+
+	require.Len(t, executor.requests, 1)
+	ref, err := refunds.FindByID("ref-1")
+	require.NoError(t, err)
+	require.Equal(t, refund.StatusSucceeded, ref.Status)
+	require.Len(t, recorder.events(), 1)
+}
@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+// FailedAttempt describes the attempt a RetryPolicy decides on.
+type FailedAttempt struct {
+	InvoiceID   string
+	MerchantID  string
+	Attempt     int
+	DeclineCode payment.DeclineCode
+	// PreviousDelay is how long the failed attempt waited after the one
+	// before it; zero for first attempts.
+	PreviousDelay time.Duration
+}
+
+// RetryPolicy decides whether a failed attempt is retried and how long to
+// wait before the next one.
+type RetryPolicy interface {
+	NextDelay(FailedAttempt) (time.Duration, bool)
+}
+
+// Random returns a non-negative pseudo-random number in [0, n). It is
+// injectable so jittered policies can be tested deterministically.
+type Random func(n int64) int64
+
+func (r Random) between(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	if r == nil {
+		r = rand.Int63n
+	}
+	return lo + time.Duration(r(int64(hi-lo)))
+}
+
+// ExponentialBackoff doubles the delay after every attempt, up to Max.
+type ExponentialBackoff struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+func (p ExponentialBackoff) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if a.Attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	return backoff(p.Base, p.Max, a.Attempt), true
+}
+
+// FullJitter waits a random delay between zero and the exponential backoff.
+type FullJitter struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+	Random      Random
+}
+
+func (p FullJitter) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if a.Attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	return p.Random.between(0, backoff(p.Base, p.Max, a.Attempt)), true
+}
+
+// DecorrelatedJitter waits a random delay between Base and three times the
+// previous delay, up to Max.
+type DecorrelatedJitter struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+	Random      Random
+}
+
+func (p DecorrelatedJitter) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if a.Attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	previous := max(a.PreviousDelay, p.Base)
+	return min(p.Random.between(p.Base, 3*previous), p.Max), true
+}
+
+// FixedInterval waits the same delay between every attempt.
+type FixedInterval struct {
+	Interval    time.Duration
+	MaxAttempts int
+}
+
+func (p FixedInterval) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if a.Attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	return p.Interval, true
+}
+
+type NoRetry struct{}
+
+func (NoRetry) NextDelay(FailedAttempt) (time.Duration, bool) {
+	return 0, false
+}
+
+// ByDeclineCode picks the policy registered for the decline code, falling
+// back to Default.
+type ByDeclineCode struct {
+	Policies map[payment.DeclineCode]RetryPolicy
+	Default  RetryPolicy
+}
+
+func (p ByDeclineCode) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if policy, ok := p.Policies[a.DeclineCode]; ok {
+		return policy.NextDelay(a)
+	}
+	return p.Default.NextDelay(a)
+}
+
+// PolicySelector applies the policy configured for the invoice, then the one
+// configured for its merchant, then Default.
+type PolicySelector struct {
+	Invoices  map[string]RetryPolicy
+	Merchants map[string]RetryPolicy
+	Default   RetryPolicy
+}
+
+func (s PolicySelector) NextDelay(a FailedAttempt) (time.Duration, bool) {
+	if policy, ok := s.Invoices[a.InvoiceID]; ok {
+		return policy.NextDelay(a)
+	}
+	if policy, ok := s.Merchants[a.MerchantID]; ok && a.MerchantID != "" {
+		return policy.NextDelay(a)
+	}
+	return s.Default.NextDelay(a)
+}
+
+func backoff(base, limit time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,77 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+// fixedRandom always returns the given fraction of n.
+func fixedRandom(fraction float64) worker.Random {
+	return func(n int64) int64 {
+		return int64(float64(n) * fraction)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := worker.ExponentialBackoff{Base: time.Second, Max: 5 * time.Second, MaxAttempts: 5}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		delay, ok := policy.NextDelay(worker.FailedAttempt{Attempt: attempt})
+		require.True(t, ok)
+		require.Equal(t, want, delay, "attempt %d", attempt)
+	}
+
+	_, ok := policy.NextDelay(worker.FailedAttempt{Attempt: 5})
+	require.False(t, ok)
+}
+
+func TestFullJitter(t *testing.T) {
+	policy := worker.FullJitter{Base: time.Second, Max: time.Minute, MaxAttempts: 5, Random: fixedRandom(0.5)}
+
+	delay, ok := policy.NextDelay(worker.FailedAttempt{Attempt: 3})
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, delay)
+
+	_, ok = policy.NextDelay(worker.FailedAttempt{Attempt: 5})
+	require.False(t, ok)
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	policy := worker.DecorrelatedJitter{Base: time.Second, Max: 10 * time.Second, MaxAttempts: 5, Random: fixedRandom(0.5)}
+
+	delay, ok := policy.NextDelay(worker.FailedAttempt{Attempt: 1})
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, delay)
+
+	delay, ok = policy.NextDelay(worker.FailedAttempt{Attempt: 2, PreviousDelay: 4 * time.Second})
+	require.True(t, ok)
+	require.Equal(t, 6500*time.Millisecond, delay)
+
+	delay, ok = policy.NextDelay(worker.FailedAttempt{Attempt: 3, PreviousDelay: 9 * time.Second})
+	require.True(t, ok)
+	require.Equal(t, 10*time.Second, delay)
+}
+
+func TestPolicySelector(t *testing.T) {
+	selector := worker.PolicySelector{
+		Invoices:  map[string]worker.RetryPolicy{"inv-vip": worker.FixedInterval{Interval: time.Minute, MaxAttempts: 10}},
+		Merchants: map[string]worker.RetryPolicy{"merchant-strict": worker.NoRetry{}},
+		Default:   worker.FixedInterval{Interval: time.Hour, MaxAttempts: 3},
+	}
+
+	delay, ok := selector.NextDelay(worker.FailedAttempt{InvoiceID: "inv-vip", MerchantID: "merchant-strict", Attempt: 1})
+	require.True(t, ok)
+	require.Equal(t, time.Minute, delay)
+
+	_, ok = selector.NextDelay(worker.FailedAttempt{InvoiceID: "inv-1", MerchantID: "merchant-strict", Attempt: 1})
+	require.False(t, ok)
+
+	delay, ok = selector.NextDelay(worker.FailedAttempt{InvoiceID: "inv-1", Attempt: 1, DeclineCode: payment.DeclineProcessingError})
+	require.True(t, ok)
+	require.Equal(t, time.Hour, delay)
+}
@@ -1,36 +1,162 @@
 package worker
 
 import (
+	"context"
+	"crypto/rand"
+	"log"
 	"time"
 
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
 )
 
+// RetryScheduler persists retries and requests them through the outbox once
+// they are due, so pending retries survive restarts.
 type RetryScheduler struct {
-	EventBus  EventPublisher
-	MaxRetry  int
-	BaseDelay time.Duration
-	MaxDelay  time.Duration
+	Store    payment.ScheduleRepository
+	Recorder contracts.EventRecorder
+	// Invoices drops the retries of canceled invoices outside a unit of
+	// work; if unset there, every retry is requested.
+	Invoices invoice.Repository
+	// UnitOfWork, if set, makes a due retry leave the store together with
+	// the request recording it.
+	UnitOfWork   contracts.UnitOfWork
+	Policy       RetryPolicy
+	PollInterval time.Duration
+	BatchSize    int
+	Now          func() time.Time
 }
 
-func (r *RetryScheduler) Schedule(payload event.PaymentRequestPayload) {
-	if payload.Attempt >= r.MaxRetry {
+// Schedule queues the next attempt of a failed payment if the retry policy
+// allows it, and records PaymentRetriesExhausted otherwise. Both the retry
+// and the exhaustion are caused by cause and written through tx, falling back
+// to Store if tx has no Scheduled.
+func (r *RetryScheduler) Schedule(tx contracts.Repositories, cause event.Event, payload event.PaymentRequestPayload, decline payment.DeclineCode) error {
+	delay, ok := r.Policy.NextDelay(FailedAttempt{
+		InvoiceID:     payload.InvoiceID,
+		MerchantID:    payload.MerchantID,
+		Attempt:       payload.Attempt,
+		DeclineCode:   decline,
+		PreviousDelay: payload.RetryDelay,
+	})
+	if !ok {
+		return tx.Events.Record(event.CausedBy(cause,
+			event.PaymentRetriesExhausted,
+			event.Invoice(payload.InvoiceID),
+			event.PaymentRetriesExhaustedPayload{
+				InvoiceID:   payload.InvoiceID,
+				PaymentID:   payload.PaymentID,
+				Attempts:    payload.Attempt,
+				DeclineCode: string(decline),
+			},
+		))
+	}
+
+	store := tx.Scheduled
+	if store == nil {
+		store = r.Store
+	}
+
+	return store.Save(&payment.ScheduledPayment{
+		ID:            generateScheduledPaymentID(),
+		InvoiceID:     payload.InvoiceID,
+		MerchantID:    payload.MerchantID,
+		PaymentID:     payload.PaymentID,
+		Amount:        payload.Amount,
+		PaymentMethod: payload.PaymentMethod,
+		Attempt:       payload.Attempt + 1,
+		Delay:         delay,
+		RunAt:         r.now().Add(delay),
+		CorrelationID: cause.CorrelationID,
+		CausationID:   cause.ID,
+	})
+}
+
+func (r *RetryScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.PollOnce()
+		}
+	}
+}
+
+// PollOnce requests every due retry, and drops those of invoices canceled
+// meanwhile. A retry is deleted in the unit recording its request; without
+// a unit of work, a crash in between requests it twice rather than never,
+// and the payment processor's idempotency absorbs the duplicate.
+func (r *RetryScheduler) PollOnce() {
+	due, err := r.Store.FindDue(r.now(), r.BatchSize)
+	if err != nil {
+		log.Println(err.Error())
 		return
 	}
 
-	delay := min(r.BaseDelay*time.Duration(1<<(payload.Attempt-1)), r.MaxDelay)
+	for _, scheduled := range due {
+		cause := event.Event{
+			ID:            scheduled.CausationID,
+			CorrelationID: scheduled.CorrelationID,
+		}
 
-	nextPayload := event.PaymentRequestPayload{
-		InvoiceID: payload.InvoiceID,
-		Amount:    payload.Amount,
-		Attempt:   payload.Attempt + 1,
+		requested := event.CausedBy(cause,
+			event.PaymentRequested,
+			event.Invoice(scheduled.InvoiceID),
+			event.PaymentRequestPayload{
+				InvoiceID:     scheduled.InvoiceID,
+				MerchantID:    scheduled.MerchantID,
+				PaymentID:     scheduled.PaymentID,
+				Amount:        scheduled.Amount,
+				PaymentMethod: scheduled.PaymentMethod,
+				Attempt:       scheduled.Attempt,
+				RetryDelay:    scheduled.Delay,
+			},
+		)
+
+		err := r.inTransaction(func(tx contracts.Repositories) error {
+			canceled, err := invoiceCanceled(tx, scheduled.InvoiceID)
+			if err != nil {
+				return err
+			}
+			if !canceled {
+				if err := tx.Events.Record(requested); err != nil {
+					return err
+				}
+			}
+			return tx.Scheduled.Delete(scheduled.ID)
+		})
+		if err != nil {
+			log.Println(err.Error())
+		}
 	}
+}
 
-	go func() {
-		time.Sleep(delay)
-		r.EventBus.Publish(event.Event{
-			Type:    event.PaymentRequested,
-			Payload: nextPayload,
+// inTransaction runs fn in the unit of work, or straight against Invoices,
+// Store and Recorder if there is none.
+func (r *RetryScheduler) inTransaction(fn func(contracts.Repositories) error) error {
+	if r.UnitOfWork == nil {
+		return fn(contracts.Repositories{
+			Invoices:  r.Invoices,
+			Scheduled: r.Store,
+			Events:    r.Recorder,
 		})
-	}()
+	}
+	return r.UnitOfWork.Do(fn)
+}
+
+func (r *RetryScheduler) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func generateScheduledPaymentID() string {
+	return "sched_" + rand.Text()
 }
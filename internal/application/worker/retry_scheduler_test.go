@@ -0,0 +1,222 @@
+package worker_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+type recordingRecorder struct {
+	mu       sync.Mutex
+	recorded []event.Event
+}
+
+func (r *recordingRecorder) Record(evt event.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.recorded = append(r.recorded, evt)
+	return nil
+}
+
+func (r *recordingRecorder) events() []event.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]event.Event(nil), r.recorded...)
+}
+
+func newRetryScheduler(now *time.Time) (*worker.RetryScheduler, *inmemory.ScheduledPaymentRepository, *recordingRecorder) {
+	store := inmemory.NewScheduledPaymentRepository()
+	recorder := &recordingRecorder{}
+
+	return &worker.RetryScheduler{
+		Store:     store,
+		Recorder:  recorder,
+		Policy:    worker.ExponentialBackoff{Base: time.Second, Max: time.Minute, MaxAttempts: 3},
+		BatchSize: 10,
+		Now:       func() time.Time { return *now },
+	}, store, recorder
+}
+
+func TestRetryScheduler_ShouldRecordRetryOnceDue(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, store, recorder := newRetryScheduler(&now)
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, event.Event{}, event.PaymentRequestPayload{
+		InvoiceID:     "inv-1",
+		PaymentID:     "pay-1",
+		Amount:        money.Money{Amount: 100, Currency: money.BRL},
+		PaymentMethod: "card_123",
+		Attempt:       2,
+	}, payment.DeclineProcessingError))
+	require.Equal(t, 1, store.Scheduled())
+
+	now = now.Add(time.Second)
+	retry.PollOnce()
+	require.Empty(t, recorder.events())
+
+	now = now.Add(time.Second)
+	retry.PollOnce()
+
+	recorded := recorder.events()
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.PaymentRequested, recorded[0].Type)
+	require.Equal(t, event.PaymentRequestPayload{
+		InvoiceID:     "inv-1",
+		PaymentID:     "pay-1",
+		Amount:        money.Money{Amount: 100, Currency: money.BRL},
+		PaymentMethod: "card_123",
+		Attempt:       3,
+		RetryDelay:    2 * time.Second,
+	}, recorded[0].Payload)
+	require.Equal(t, 0, store.Scheduled())
+
+	retry.PollOnce()
+	require.Len(t, recorder.events(), 1)
+}
+
+func TestRetryScheduler_ShouldRecordExhaustionAtMaxRetry(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, store, recorder := newRetryScheduler(&now)
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, event.Event{}, event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   3,
+	}, payment.DeclineProcessingError))
+
+	require.Equal(t, 0, store.Scheduled())
+
+	recorded := recorder.events()
+	require.Len(t, recorded, 1)
+	require.Equal(t, event.PaymentRetriesExhausted, recorded[0].Type)
+	require.Equal(t, event.PaymentRetriesExhaustedPayload{
+		InvoiceID:   "inv-1",
+		PaymentID:   "pay-1",
+		Attempts:    3,
+		DeclineCode: string(payment.DeclineProcessingError),
+	}, recorded[0].Payload)
+}
+
+func TestRetryScheduler_ShouldSurviveRestart(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, store, _ := newRetryScheduler(&now)
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: retry.Recorder}, event.Event{}, event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   1,
+	}, payment.DeclineProcessingError))
+
+	recorder := &recordingRecorder{}
+	restarted := &worker.RetryScheduler{
+		Store:     store,
+		Recorder:  recorder,
+		Policy:    worker.NoRetry{},
+		BatchSize: 10,
+		Now:       func() time.Time { return now.Add(time.Minute) },
+	}
+	restarted.PollOnce()
+
+	require.Len(t, recorder.events(), 1)
+}
+
+func TestRetryScheduler_ShouldFollowPolicyForDeclineCode(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, store, recorder := newRetryScheduler(&now)
+	retry.Policy = worker.ByDeclineCode{
+		Policies: map[payment.DeclineCode]worker.RetryPolicy{
+			payment.DeclineInsufficientFunds: worker.FixedInterval{Interval: 24 * time.Hour, MaxAttempts: 8},
+			payment.DeclineDoNotHonor:        worker.NoRetry{},
+		},
+		Default: worker.ExponentialBackoff{Base: time.Second, Max: time.Minute, MaxAttempts: 3},
+	}
+
+	payload := event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   5,
+	}
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, event.Event{}, payload, payment.DeclineDoNotHonor))
+	require.Equal(t, 0, store.Scheduled())
+	require.Len(t, recorder.events(), 1)
+	require.Equal(t, event.PaymentRetriesExhausted, recorder.events()[0].Type)
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, event.Event{}, payload, payment.DeclineInsufficientFunds))
+	require.Equal(t, 1, store.Scheduled())
+
+	now = now.Add(23 * time.Hour)
+	retry.PollOnce()
+	require.Len(t, recorder.events(), 1)
+
+	now = now.Add(time.Hour)
+	retry.PollOnce()
+	require.Len(t, recorder.events(), 2)
+	require.Equal(t, event.PaymentRequested, recorder.events()[1].Type)
+}
+
+func TestRetryScheduler_ShouldDropRetriesOfCanceledInvoice(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, store, recorder := newRetryScheduler(&now)
+
+	invoices := inmemory.NewInvoiceRepository()
+	require.NoError(t, invoices.Save(&invoice.Invoice{
+		ID:     "inv-1",
+		Amount: money.Money{Amount: 100, Currency: money.BRL},
+		Status: invoice.StatusProcessing,
+	}))
+	retry.Invoices = invoices
+
+	payload := event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   1,
+	}
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, event.Event{}, payload, payment.DeclineProcessingError))
+	require.NoError(t, invoices.UpdateStatus("inv-1", invoice.StatusProcessing, invoice.StatusCanceled))
+
+	now = now.Add(time.Hour)
+	retry.PollOnce()
+
+	require.Empty(t, recorder.events())
+	require.Equal(t, 0, store.Scheduled())
+}
+
+func TestRetryScheduler_ShouldRecordRetryInTheFailuresCausalChain(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	retry, _, recorder := newRetryScheduler(&now)
+
+	requested := event.New(event.PaymentRequested, event.Invoice("inv-1"), nil)
+	failed := event.CausedBy(requested, event.PaymentFailed, event.Payment("pay-1"), nil)
+
+	require.NoError(t, retry.Schedule(contracts.Repositories{Events: recorder}, failed, event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		PaymentID: "pay-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   1,
+	}, payment.DeclineProcessingError))
+
+	now = now.Add(time.Minute)
+	retry.PollOnce()
+
+	recorded := recorder.events()
+	require.Len(t, recorded, 1)
+	require.Equal(t, requested.ID, recorded[0].CorrelationID)
+	require.Equal(t, failed.ID, recorded[0].CausationID)
+	require.Equal(t, event.Invoice("inv-1"), recorded[0].Aggregate)
+	require.NotEqual(t, requested.ID, recorded[0].ID)
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BackendSQLite = "sqlite"
+	BackendMemory = "memory"
+)
+
+const (
+	ExecutorRandom = "random"
+	ExecutorPSP    = "psp"
+)
+
+const (
+	StrategyExponential        = "exponential"
+	StrategyFullJitter         = "full_jitter"
+	StrategyDecorrelatedJitter = "decorrelated_jitter"
+	StrategyFixed              = "fixed"
+	StrategyNone               = "none"
+)
+
+type Config struct {
+	HTTP       HTTP       `yaml:"http" toml:"http"`
+	Storage    Storage    `yaml:"storage" toml:"storage"`
+	Dispatcher Dispatcher `yaml:"dispatcher" toml:"dispatcher"`
+	Retry      Retry      `yaml:"retry" toml:"retry"`
+	Overdue    Overdue    `yaml:"overdue" toml:"overdue"`
+	Executor   Executor   `yaml:"executor" toml:"executor"`
+}
+
+type HTTP struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// ShutdownTimeout bounds how long in-flight requests and workers get to
+	// finish once the server is asked to stop.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay. Expired ones are deleted every
+	// IdempotencyPurgeInterval.
+	IdempotencyTTL           time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	IdempotencyPurgeInterval time.Duration `yaml:"idempotency_purge_interval" toml:"idempotency_purge_interval"`
+	// IdempotencyLease is how long a request being processed holds its key;
+	// once it runs out, a retry may take the key over.
+	IdempotencyLease time.Duration `yaml:"idempotency_lease" toml:"idempotency_lease"`
+}
+
+type Storage struct {
+	// Backend is either "sqlite" or "memory"; the memory backend loses all
+	// state on restart and is meant for local experiments.
+	Backend string `yaml:"backend" toml:"backend"`
+	Path    string `yaml:"path" toml:"path"`
+}
+
+type Dispatcher struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size"`
+	// Workers is the number of dispatchers the server runs. Servers sharing
+	// a database split the outbox between all of theirs.
+	Workers int `yaml:"workers" toml:"workers"`
+	// Lease is how long a dispatcher holds a claimed batch before others
+	// may take it over; it should outlast publishing one.
+	Lease time.Duration `yaml:"lease" toml:"lease"`
+	// Retry spaces the attempts to publish an event; events still failing
+	// when it gives up are dead lettered.
+	Retry Policy `yaml:"retry" toml:"retry"`
+}
+
+type Retry struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size"`
+	// Policy applies to every failed payment without a more specific policy
+	// below.
+	Policy       Policy            `yaml:"policy" toml:"policy"`
+	DeclineCodes map[string]Policy `yaml:"decline_codes" toml:"decline_codes"`
+	// Merchants override Policy and DeclineCodes for a merchant's invoices.
+	Merchants map[string]Policy `yaml:"merchants" toml:"merchants"`
+}
+
+// Overdue configures the sweep marking invoices past their due date as
+// overdue.
+type Overdue struct {
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size"`
+}
+
+// Policy describes a retry policy. Base and Max apply to the backoff
+// strategies, Interval to the fixed one.
+type Policy struct {
+	Strategy    string        `yaml:"strategy" toml:"strategy"`
+	Base        time.Duration `yaml:"base" toml:"base"`
+	Max         time.Duration `yaml:"max" toml:"max"`
+	Interval    time.Duration `yaml:"interval" toml:"interval"`
+	MaxAttempts int           `yaml:"max_attempts" toml:"max_attempts"`
+}
+
+type Executor struct {
+	// Kind is either "random", which simulates the gateway in process, or
+	// "psp", which charges through the PSP at PSPURL.
+	Kind string `yaml:"kind" toml:"kind"`
+	// Timeout bounds each gateway call.
+	Timeout   time.Duration `yaml:"timeout" toml:"timeout"`
+	PSPURL    string        `yaml:"psp_url" toml:"psp_url"`
+	PSPAPIKey string        `yaml:"psp_api_key" toml:"psp_api_key"`
+}
+
+// Default returns the configuration used when nothing overrides it.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Addr:                     ":8080",
+			ShutdownTimeout:          15 * time.Second,
+			IdempotencyTTL:           24 * time.Hour,
+			IdempotencyPurgeInterval: time.Hour,
+			IdempotencyLease:         time.Minute,
+		},
+		Storage: Storage{
+			Backend: BackendSQLite,
+			Path:    "./db/db.db",
+		},
+		Dispatcher: Dispatcher{
+			PollInterval: time.Second,
+			BatchSize:    1024,
+			Workers:      1,
+			Lease:        30 * time.Second,
+			Retry: Policy{
+				Strategy:    StrategyExponential,
+				Base:        time.Second,
+				Max:         5 * time.Minute,
+				MaxAttempts: 10,
+			},
+		},
+		Retry: Retry{
+			PollInterval: time.Second,
+			BatchSize:    100,
+			Policy: Policy{
+				Strategy:    StrategyFullJitter,
+				Base:        time.Second,
+				Max:         30 * time.Second,
+				MaxAttempts: 3,
+			},
+			DeclineCodes: map[string]Policy{
+				"insufficient_funds": {
+					Strategy:    StrategyFixed,
+					Interval:    24 * time.Hour,
+					MaxAttempts: 8,
+				},
+			},
+		},
+		Overdue: Overdue{
+			PollInterval: time.Minute,
+			BatchSize:    100,
+		},
+		Executor: Executor{
+			Kind:    ExecutorRandom,
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.IdempotencyTTL > 0, "http.idempotency_ttl must be positive")
+	check(c.HTTP.IdempotencyPurgeInterval > 0, "http.idempotency_purge_interval must be positive")
+	check(c.HTTP.IdempotencyLease > 0, "http.idempotency_lease must be positive")
+
+	switch c.Storage.Backend {
+	case BackendSQLite:
+		check(c.Storage.Path != "", "storage.path is required for the sqlite backend")
+	case BackendMemory:
+	default:
+		check(false, "storage.backend %q is not one of %q, %q", c.Storage.Backend, BackendSQLite, BackendMemory)
+	}
+
+	check(c.Dispatcher.PollInterval > 0, "dispatcher.poll_interval must be positive")
+	check(c.Dispatcher.BatchSize > 0, "dispatcher.batch_size must be positive")
+	check(c.Dispatcher.Workers > 0, "dispatcher.workers must be positive")
+	check(c.Dispatcher.Lease > 0, "dispatcher.lease must be positive")
+	errs = append(errs, c.Dispatcher.Retry.validate("dispatcher.retry")...)
+
+	check(c.Retry.PollInterval > 0, "retry.poll_interval must be positive")
+	check(c.Retry.BatchSize > 0, "retry.batch_size must be positive")
+	errs = append(errs, c.Retry.Policy.validate("retry.policy")...)
+	for code, p := range c.Retry.DeclineCodes {
+		errs = append(errs, p.validate("retry.decline_codes."+code)...)
+	}
+	for merchant, p := range c.Retry.Merchants {
+		errs = append(errs, p.validate("retry.merchants."+merchant)...)
+	}
+
+	check(c.Overdue.PollInterval > 0, "overdue.poll_interval must be positive")
+	check(c.Overdue.BatchSize > 0, "overdue.batch_size must be positive")
+
+	switch c.Executor.Kind {
+	case ExecutorRandom:
+	case ExecutorPSP:
+		check(c.Executor.PSPURL != "", "executor.psp_url is required for the psp executor")
+	default:
+		check(false, "executor.kind %q is not one of %q, %q", c.Executor.Kind, ExecutorRandom, ExecutorPSP)
+	}
+	check(c.Executor.Timeout > 0, "executor.timeout must be positive")
+
+	return errors.Join(errs...)
+}
+
+func (p Policy) validate(name string) []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(name+"."+format, args...))
+		}
+	}
+
+	switch p.Strategy {
+	case StrategyExponential, StrategyFullJitter, StrategyDecorrelatedJitter:
+		check(p.Base > 0, "base must be positive")
+		check(p.Max >= p.Base, "max must not be less than base")
+	case StrategyFixed:
+		check(p.Interval > 0, "interval must be positive")
+	case StrategyNone:
+		return nil
+	default:
+		check(false, "strategy %q is not one of %q, %q, %q, %q, %q", p.Strategy,
+			StrategyExponential, StrategyFullJitter, StrategyDecorrelatedJitter, StrategyFixed, StrategyNone)
+		return errs
+	}
+	check(p.MaxAttempts > 0, "max_attempts must be positive")
+
+	return errs
+}
@@ -0,0 +1,162 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/config"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad_ShouldUseDefaultsWhenNothingIsSet(t *testing.T) {
+	cfg, err := config.Load(nil, env(nil))
+
+	require.NoError(t, err)
+	require.Equal(t, config.Default(), cfg)
+}
+
+func TestLoad_ShouldReadYAMLFile(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+http:
+  addr: ":9000"
+storage:
+  backend: memory
+dispatcher:
+  batch_size: 10
+retry:
+  policy:
+    strategy: exponential
+    base: 2s
+    max: 1m
+    max_attempts: 5
+  merchants:
+    merchant-1:
+      strategy: none
+overdue:
+  poll_interval: 5m
+executor:
+  kind: psp
+  psp_url: http://localhost:9090
+`)
+
+	cfg, err := config.Load([]string{"-config", path}, env(nil))
+
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.HTTP.Addr)
+	require.Equal(t, config.BackendMemory, cfg.Storage.Backend)
+	require.Equal(t, 10, cfg.Dispatcher.BatchSize)
+	require.Equal(t, time.Second, cfg.Dispatcher.PollInterval, "unset keys keep their defaults")
+	require.Equal(t, config.Policy{
+		Strategy:    config.StrategyExponential,
+		Base:        2 * time.Second,
+		Max:         time.Minute,
+		MaxAttempts: 5,
+	}, cfg.Retry.Policy)
+	require.Equal(t, config.StrategyNone, cfg.Retry.Merchants["merchant-1"].Strategy)
+	require.Contains(t, cfg.Retry.DeclineCodes, "insufficient_funds")
+	require.Equal(t, 5*time.Minute, cfg.Overdue.PollInterval)
+	require.Equal(t, 100, cfg.Overdue.BatchSize)
+	require.Equal(t, config.ExecutorPSP, cfg.Executor.Kind)
+	require.Equal(t, "http://localhost:9090", cfg.Executor.PSPURL)
+}
+
+func TestLoad_ShouldReadTOMLFile(t *testing.T) {
+	path := writeFile(t, "server.toml", `
+[http]
+addr = ":9000"
+
+[retry.decline_codes.do_not_honor]
+strategy = "fixed"
+interval = "1h"
+max_attempts = 2
+`)
+
+	cfg, err := config.Load([]string{"-config", path}, env(nil))
+
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.HTTP.Addr)
+	require.Equal(t, config.Policy{
+		Strategy:    config.StrategyFixed,
+		Interval:    time.Hour,
+		MaxAttempts: 2,
+	}, cfg.Retry.DeclineCodes["do_not_honor"])
+}
+
+func TestLoad_ShouldRejectUnknownFileKeys(t *testing.T) {
+	yamlPath := writeFile(t, "server.yaml", "http:\n  adr: \":9000\"\n")
+	tomlPath := writeFile(t, "server.toml", "[http]\nadr = \":9000\"\n")
+
+	_, err := config.Load([]string{"-config", yamlPath}, env(nil))
+	require.Error(t, err)
+
+	_, err = config.Load([]string{"-config", tomlPath}, env(nil))
+	require.Error(t, err)
+}
+
+func TestLoad_ShouldApplyFileThenEnvThenFlags(t *testing.T) {
+	path := writeFile(t, "server.yaml", `
+http:
+  addr: ":9000"
+dispatcher:
+  batch_size: 10
+retry:
+  batch_size: 20
+`)
+
+	cfg, err := config.Load(
+		[]string{"-dispatcher-batch-size", "30"},
+		env(map[string]string{
+			"PAYMENTS_CONFIG":                path,
+			"PAYMENTS_DISPATCHER_BATCH_SIZE": "40",
+			"PAYMENTS_RETRY_BATCH_SIZE":      "50",
+		}),
+	)
+
+	require.NoError(t, err)
+	require.Equal(t, ":9000", cfg.HTTP.Addr, "file overrides defaults")
+	require.Equal(t, 50, cfg.Retry.BatchSize, "env overrides file")
+	require.Equal(t, 30, cfg.Dispatcher.BatchSize, "flags override env")
+}
+
+func TestLoad_ShouldRejectMalformedValues(t *testing.T) {
+	_, err := config.Load([]string{"-retry-base", "soon"}, env(nil))
+	require.Error(t, err)
+
+	_, err = config.Load(nil, env(map[string]string{"PAYMENTS_DISPATCHER_BATCH_SIZE": "many"}))
+	require.ErrorContains(t, err, "PAYMENTS_DISPATCHER_BATCH_SIZE")
+}
+
+func TestValidate_ShouldReportEveryInvalidSetting(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = "postgres"
+	cfg.Dispatcher.BatchSize = 0
+	cfg.Retry.DeclineCodes["do_not_honor"] = config.Policy{Strategy: config.StrategyFixed}
+	cfg.Overdue.BatchSize = 0
+	cfg.Executor.Kind = config.ExecutorPSP
+
+	err := cfg.Validate()
+
+	require.ErrorContains(t, err, "storage.backend")
+	require.ErrorContains(t, err, "dispatcher.batch_size")
+	require.ErrorContains(t, err, "retry.decline_codes.do_not_honor.interval")
+	require.ErrorContains(t, err, "retry.decline_codes.do_not_honor.max_attempts")
+	require.ErrorContains(t, err, "overdue.batch_size")
+	require.ErrorContains(t, err, "executor.psp_url")
+}
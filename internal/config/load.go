@@ -0,0 +1,179 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the environment variable of every setting.
+const EnvPrefix = "PAYMENTS_"
+
+// binding ties a setting to its command line flag and environment variable.
+type binding struct {
+	flag  string
+	env   string
+	usage string
+	set   func(*Config, string) error
+}
+
+var bindings = []binding{
+	{"http-addr", "HTTP_ADDR", "HTTP listen address", setString(func(c *Config) *string { return &c.HTTP.Addr })},
+	{"shutdown-timeout", "HTTP_SHUTDOWN_TIMEOUT", "time allowed for in-flight work on shutdown", setDuration(func(c *Config) *time.Duration { return &c.HTTP.ShutdownTimeout })},
+	{"idempotency-ttl", "HTTP_IDEMPOTENCY_TTL", "how long Idempotency-Key responses are kept", setDuration(func(c *Config) *time.Duration { return &c.HTTP.IdempotencyTTL })},
+	{"idempotency-purge-interval", "HTTP_IDEMPOTENCY_PURGE_INTERVAL", "how often expired Idempotency-Key responses are deleted", setDuration(func(c *Config) *time.Duration { return &c.HTTP.IdempotencyPurgeInterval })},
+	{"idempotency-lease", "HTTP_IDEMPOTENCY_LEASE", "how long a request being processed holds its Idempotency-Key", setDuration(func(c *Config) *time.Duration { return &c.HTTP.IdempotencyLease })},
+	{"storage", "STORAGE_BACKEND", `storage backend, "sqlite" or "memory"`, setString(func(c *Config) *string { return &c.Storage.Backend })},
+	{"db", "STORAGE_PATH", "SQLite database path", setString(func(c *Config) *string { return &c.Storage.Path })},
+	{"dispatcher-poll-interval", "DISPATCHER_POLL_INTERVAL", "how often the outbox is polled", setDuration(func(c *Config) *time.Duration { return &c.Dispatcher.PollInterval })},
+	{"dispatcher-batch-size", "DISPATCHER_BATCH_SIZE", "outbox events dispatched per poll", setInt(func(c *Config) *int { return &c.Dispatcher.BatchSize })},
+	{"dispatcher-workers", "DISPATCHER_WORKERS", "number of outbox dispatchers", setInt(func(c *Config) *int { return &c.Dispatcher.Workers })},
+	{"dispatcher-lease", "DISPATCHER_LEASE", "how long a dispatcher holds the events it claims", setDuration(func(c *Config) *time.Duration { return &c.Dispatcher.Lease })},
+	{"dispatcher-max-attempts", "DISPATCHER_MAX_ATTEMPTS", "publish attempts before an outbox event is dead lettered", setInt(func(c *Config) *int { return &c.Dispatcher.Retry.MaxAttempts })},
+	{"retry-poll-interval", "RETRY_POLL_INTERVAL", "how often due retries are polled", setDuration(func(c *Config) *time.Duration { return &c.Retry.PollInterval })},
+	{"retry-batch-size", "RETRY_BATCH_SIZE", "due retries requested per poll", setInt(func(c *Config) *int { return &c.Retry.BatchSize })},
+	{"retry-strategy", "RETRY_STRATEGY", "default retry strategy", setString(func(c *Config) *string { return &c.Retry.Policy.Strategy })},
+	{"retry-base", "RETRY_BASE", "default retry backoff base", setDuration(func(c *Config) *time.Duration { return &c.Retry.Policy.Base })},
+	{"retry-max", "RETRY_MAX", "default retry backoff cap", setDuration(func(c *Config) *time.Duration { return &c.Retry.Policy.Max })},
+	{"retry-interval", "RETRY_INTERVAL", "default retry interval for the fixed strategy", setDuration(func(c *Config) *time.Duration { return &c.Retry.Policy.Interval })},
+	{"retry-max-attempts", "RETRY_MAX_ATTEMPTS", "default number of attempts before giving up", setInt(func(c *Config) *int { return &c.Retry.Policy.MaxAttempts })},
+	{"overdue-poll-interval", "OVERDUE_POLL_INTERVAL", "how often overdue invoices are swept", setDuration(func(c *Config) *time.Duration { return &c.Overdue.PollInterval })},
+	{"overdue-batch-size", "OVERDUE_BATCH_SIZE", "overdue invoices marked per sweep", setInt(func(c *Config) *int { return &c.Overdue.BatchSize })},
+	{"executor", "EXECUTOR_KIND", `payment executor, "random" or "psp"`, setString(func(c *Config) *string { return &c.Executor.Kind })},
+	{"executor-timeout", "EXECUTOR_TIMEOUT", "deadline for each gateway call", setDuration(func(c *Config) *time.Duration { return &c.Executor.Timeout })},
+	{"psp-url", "PSP_URL", "PSP base URL", setString(func(c *Config) *string { return &c.Executor.PSPURL })},
+	{"psp-api-key", "PSP_API_KEY", "PSP API key", setString(func(c *Config) *string { return &c.Executor.PSPAPIKey })},
+}
+
+// Load builds the configuration from its defaults, the file named by -config
+// (or PAYMENTS_CONFIG), environment variables and command line flags, each
+// overriding the previous one, and validates the result.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg, _, err := LoadArgs(args, getenv)
+	return cfg, err
+}
+
+// LoadArgs is Load for commands taking arguments of their own after the
+// flags, which it returns.
+func LoadArgs(args []string, getenv func(string) string) (*Config, []string, error) {
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	path := fs.String("config", getenv(EnvPrefix+"CONFIG"), "YAML or TOML configuration file")
+
+	// flags are parsed before the file is read but must win over it, so their
+	// values are only applied once the file and environment have been.
+	var flagged []func(*Config) error
+	for _, b := range bindings {
+		fs.Func(b.flag, b.usage+" (env "+EnvPrefix+b.env+")", func(value string) error {
+			if err := b.set(&Config{}, value); err != nil {
+				return err
+			}
+			flagged = append(flagged, func(c *Config) error {
+				return b.set(c, value)
+			})
+			return nil
+		})
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg := Default()
+
+	if *path != "" {
+		if err := cfg.readFile(*path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, b := range bindings {
+		value := getenv(EnvPrefix + b.env)
+		if value == "" {
+			continue
+		}
+		if err := b.set(cfg, value); err != nil {
+			return nil, nil, fmt.Errorf("%s%s: %w", EnvPrefix, b.env, err)
+		}
+	}
+
+	for _, set := range flagged {
+		if err := set(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// readFile decodes the file over the current settings, picking the format
+// from its extension. Unknown keys are rejected so typos don't go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config format %q", path, ext)
+	}
+
+	return nil
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
@@ -1,14 +1,94 @@
 package event
 
+import (
+	"cmp"
+	"crypto/rand"
+	"time"
+)
+
 type Type string
 
 const (
-	PaymentRequested Type = "REQUESTED"
-	PaymentSucceeded Type = "SUCCEEDED"
-	PaymentFailed    Type = "FAILED"
+	PaymentRequested Type = "PAYMENT_REQUESTED"
+	PaymentSucceeded Type = "PAYMENT_SUCCEEDED"
+	PaymentFailed    Type = "PAYMENT_FAILED"
+	// PaymentRetriesExhausted is recorded when a retryable failure is not
+	// retried anymore; the payment has failed for good.
+	PaymentRetriesExhausted Type = "PAYMENT_RETRIES_EXHAUSTED"
+
+	RefundRequested Type = "REFUND_REQUESTED"
+	RefundSucceeded Type = "REFUND_SUCCEEDED"
+	RefundFailed    Type = "REFUND_FAILED"
+
+	InvoiceCanceled Type = "INVOICE_CANCELED"
+	InvoiceOverdue  Type = "INVOICE_OVERDUE"
+)
+
+// Aggregate identifies the entity an event is about.
+type Aggregate struct {
+	Type string
+	ID   string
+}
+
+const (
+	AggregateInvoice = "invoice"
+	AggregatePayment = "payment"
+	AggregateRefund  = "refund"
 )
 
+func Invoice(id string) Aggregate { return Aggregate{Type: AggregateInvoice, ID: id} }
+func Payment(id string) Aggregate { return Aggregate{Type: AggregatePayment, ID: id} }
+func Refund(id string) Aggregate  { return Aggregate{Type: AggregateRefund, ID: id} }
+
+// Event is the envelope every domain event travels in. CorrelationID is
+// shared by every event descending from the same first event, and
+// CausationID is the ID of the event that directly caused this one, so an
+// invoice's history can be followed from any of its events.
 type Event struct {
-	Type    Type
-	Payload any
+	ID            string
+	Type          Type
+	Version       int
+	OccurredAt    time.Time
+	Aggregate     Aggregate
+	CorrelationID string
+	CausationID   string
+	Payload       any
+}
+
+// New returns an event starting a new causal chain.
+func New(t Type, aggregate Aggregate, payload any) Event {
+	return Event{
+		Type:      t,
+		Aggregate: aggregate,
+		Payload:   payload,
+	}.WithDefaults()
+}
+
+// CausedBy returns an event caused by cause, in cause's causal chain. A cause
+// without ID is unknown, and the event starts a new chain instead.
+func CausedBy(cause Event, t Type, aggregate Aggregate, payload any) Event {
+	evt := New(t, aggregate, payload)
+	if cause.ID != "" {
+		evt.CorrelationID = cmp.Or(cause.CorrelationID, cause.ID)
+		evt.CausationID = cause.ID
+	}
+	return evt
+}
+
+// WithDefaults fills the envelope fields left unset: a fresh ID, the current
+// time, the registered version and, absent a correlation, the event's own ID.
+func (e Event) WithDefaults() Event {
+	if e.ID == "" {
+		e.ID = "evt_" + rand.Text()
+	}
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now().UTC()
+	}
+	if e.Version == 0 {
+		e.Version = CurrentVersion(e.Type)
+	}
+	if e.CorrelationID == "" {
+		e.CorrelationID = e.ID
+	}
+	return e
 }
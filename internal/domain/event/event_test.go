@@ -0,0 +1,39 @@
+package event_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+)
+
+func TestNew_ShouldStartACausalChain(t *testing.T) {
+	evt := event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{})
+
+	require.NotEmpty(t, evt.ID)
+	require.Equal(t, evt.ID, evt.CorrelationID)
+	require.Empty(t, evt.CausationID)
+	require.Equal(t, 1, evt.Version)
+	require.False(t, evt.OccurredAt.IsZero())
+	require.Equal(t, event.Aggregate{Type: event.AggregateInvoice, ID: "inv-1"}, evt.Aggregate)
+}
+
+func TestCausedBy_ShouldKeepTheCorrelationOfTheFirstEvent(t *testing.T) {
+	requested := event.New(event.PaymentRequested, event.Invoice("inv-1"), nil)
+	failed := event.CausedBy(requested, event.PaymentFailed, event.Payment("pay-1"), nil)
+	retried := event.CausedBy(failed, event.PaymentRequested, event.Invoice("inv-1"), nil)
+
+	require.Equal(t, requested.ID, failed.CorrelationID)
+	require.Equal(t, requested.ID, failed.CausationID)
+	require.Equal(t, requested.ID, retried.CorrelationID)
+	require.Equal(t, failed.ID, retried.CausationID)
+	require.NotEqual(t, failed.ID, retried.ID)
+}
+
+func TestCausedBy_ShouldStartANewChainWhenTheCauseIsUnknown(t *testing.T) {
+	evt := event.CausedBy(event.Event{Type: event.PaymentRequested}, event.PaymentFailed, event.Payment("pay-1"), nil)
+
+	require.Equal(t, evt.ID, evt.CorrelationID)
+	require.Empty(t, evt.CausationID)
+}
@@ -1,19 +1,79 @@
 package event
 
+import (
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
 type PaymentRequestPayload struct {
-	InvoiceID string
-	Amount    int64
-	Attempt   int
+	InvoiceID     string      `json:"invoice_id"`
+	MerchantID    string      `json:"merchant_id"`
+	PaymentID     string      `json:"payment_id"`
+	Amount        money.Money `json:"amount"`
+	PaymentMethod string      `json:"payment_method"`
+	Attempt       int         `json:"attempt"`
+	// RetryDelay is how long the attempt waited after the previous one; zero
+	// for first attempts.
+	RetryDelay time.Duration `json:"retry_delay"`
 }
 
 type PaymentSucceededPayload struct {
-	InvoiceID string
-	PaymentID string
+	InvoiceID        string      `json:"invoice_id"`
+	PaymentID        string      `json:"payment_id"`
+	Amount           money.Money `json:"amount"`
+	GatewayReference string      `json:"gateway_reference"`
 }
 
 type PaymentFailedPayload struct {
-	InvoiceID string
-	PaymentID string
-	Retryable bool
-	Reason    string
+	InvoiceID        string `json:"invoice_id"`
+	PaymentID        string `json:"payment_id"`
+	Attempt          int    `json:"attempt"`
+	Retryable        bool   `json:"retryable"`
+	Reason           string `json:"reason"`
+	DeclineCode      string `json:"decline_code"`
+	GatewayReference string `json:"gateway_reference"`
+}
+
+type PaymentRetriesExhaustedPayload struct {
+	InvoiceID   string `json:"invoice_id"`
+	PaymentID   string `json:"payment_id"`
+	Attempts    int    `json:"attempts"`
+	DeclineCode string `json:"decline_code"`
+}
+
+type RefundRequestedPayload struct {
+	RefundID  string      `json:"refund_id"`
+	PaymentID string      `json:"payment_id"`
+	InvoiceID string      `json:"invoice_id"`
+	Amount    money.Money `json:"amount"`
+	Reason    string      `json:"reason"`
+}
+
+type RefundSucceededPayload struct {
+	RefundID  string      `json:"refund_id"`
+	PaymentID string      `json:"payment_id"`
+	InvoiceID string      `json:"invoice_id"`
+	Amount    money.Money `json:"amount"`
+}
+
+type RefundFailedPayload struct {
+	RefundID  string      `json:"refund_id"`
+	PaymentID string      `json:"payment_id"`
+	InvoiceID string      `json:"invoice_id"`
+	Amount    money.Money `json:"amount"`
+	Reason    string      `json:"reason"`
+}
+
+type InvoiceCanceledPayload struct {
+	InvoiceID      string `json:"invoice_id"`
+	PreviousStatus string `json:"previous_status"`
+	Reason         string `json:"reason"`
+}
+
+type InvoiceOverduePayload struct {
+	InvoiceID      string      `json:"invoice_id"`
+	PreviousStatus string      `json:"previous_status"`
+	DueAt          time.Time   `json:"due_at"`
+	Balance        money.Money `json:"balance"`
 }
@@ -0,0 +1,88 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// ErrUnknownType is returned for event types missing from the registry.
+var ErrUnknownType = errors.New("unknown event type")
+
+// registration describes the payload an event type carries. Version is
+// bumped whenever the payload changes incompatibly.
+type registration struct {
+	payload reflect.Type
+	version int
+}
+
+func v1[P any]() registration {
+	return registration{payload: reflect.TypeFor[P](), version: 1}
+}
+
+// registry maps every event type to the payload it carries. A new event type
+// must be added here before it can be subscribed to or dispatched.
+var registry = map[Type]registration{
+	PaymentRequested:        v1[PaymentRequestPayload](),
+	PaymentSucceeded:        v1[PaymentSucceededPayload](),
+	PaymentFailed:           v1[PaymentFailedPayload](),
+	PaymentRetriesExhausted: v1[PaymentRetriesExhaustedPayload](),
+
+	RefundRequested: v1[RefundRequestedPayload](),
+	RefundSucceeded: v1[RefundSucceededPayload](),
+	RefundFailed:    v1[RefundFailedPayload](),
+
+	InvoiceCanceled: v1[InvoiceCanceledPayload](),
+	InvoiceOverdue:  v1[InvoiceOverduePayload](),
+}
+
+// Types returns every registered event type, sorted.
+func Types() []Type {
+	types := make([]Type, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+// Validate returns ErrUnknownType unless t is registered.
+func (t Type) Validate() error {
+	if _, ok := registry[t]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownType, t)
+	}
+	return nil
+}
+
+// PayloadType returns the Go type of the payload registered for t.
+func PayloadType(t Type) (reflect.Type, error) {
+	reg, ok := registry[t]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, t)
+	}
+	return reg.payload, nil
+}
+
+// CurrentVersion returns the payload version recorded for new events of type
+// t, or zero if t is unknown.
+func CurrentVersion(t Type) int {
+	return registry[t].version
+}
+
+// DecodePayload unmarshals data into the payload registered for t and
+// returns it by value, the way handlers expect it in Event.Payload.
+func DecodePayload(t Type, data []byte) (any, error) {
+	payloadType, err := PayloadType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := reflect.New(payloadType)
+	if err := json.Unmarshal(data, payload.Interface()); err != nil {
+		return nil, fmt.Errorf("decoding %s payload: %w", t, err)
+	}
+
+	return payload.Elem().Interface(), nil
+}
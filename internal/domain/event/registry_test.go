@@ -0,0 +1,52 @@
+package event_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+func TestRegistry_ShouldRegisterEveryEventType(t *testing.T) {
+	types := []event.Type{
+		event.PaymentRequested,
+		event.PaymentSucceeded,
+		event.PaymentFailed,
+		event.PaymentRetriesExhausted,
+		event.RefundRequested,
+		event.RefundSucceeded,
+		event.RefundFailed,
+		event.InvoiceCanceled,
+		event.InvoiceOverdue,
+	}
+
+	require.ElementsMatch(t, types, event.Types())
+	for _, typ := range types {
+		require.NoError(t, typ.Validate())
+	}
+}
+
+func TestRegistry_ShouldRejectUnknownTypes(t *testing.T) {
+	require.ErrorIs(t, event.Type("PaymentRequested").Validate(), event.ErrUnknownType)
+
+	_, err := event.DecodePayload("PaymentRequested", []byte(`{}`))
+	require.ErrorIs(t, err, event.ErrUnknownType)
+}
+
+func TestDecodePayload_ShouldReturnTheRegisteredPayloadType(t *testing.T) {
+	payload := event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 100, Currency: money.BRL},
+		Attempt:   2,
+	}
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+
+	decoded, err := event.DecodePayload(event.PaymentRequested, data)
+
+	require.NoError(t, err)
+	require.Equal(t, payload, decoded)
+}
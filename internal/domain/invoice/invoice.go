@@ -1,17 +1,330 @@
 package invoice
 
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
 type Status string
 
 const (
-	StatusPending    Status = "PENDING"
-	StatusProcessing Status = "PROCESSING"
-	StatusPaid       Status = "PAID"
-	StatusFailed     Status = "FAILED"
-	StatusCanceled   Status = "CANCELED"
+	StatusPending           Status = "PENDING"
+	StatusProcessing        Status = "PROCESSING"
+	StatusPaid              Status = "PAID"
+	StatusPartiallyPaid     Status = "PARTIALLY_PAID"
+	StatusFailed            Status = "FAILED"
+	StatusCanceled          Status = "CANCELED"
+	StatusRefunded          Status = "REFUNDED"
+	StatusPartiallyRefunded Status = "PARTIALLY_REFUNDED"
+	StatusOverdue           Status = "OVERDUE"
+)
+
+var statuses = []Status{
+	StatusPending,
+	StatusProcessing,
+	StatusPaid,
+	StatusPartiallyPaid,
+	StatusFailed,
+	StatusCanceled,
+	StatusRefunded,
+	StatusPartiallyRefunded,
+	StatusOverdue,
+}
+
+// ParseStatus accepts status names in any case.
+func ParseStatus(s string) (Status, error) {
+	status := Status(strings.ToUpper(strings.TrimSpace(s)))
+	if !slices.Contains(statuses, status) {
+		return "", fmt.Errorf("unknown invoice status %q", s)
+	}
+	return status, nil
+}
+
+var (
+	ErrNotFound          = errors.New("invoice not found")
+	ErrAlreadyExists     = errors.New("invoice already exists")
+	ErrInvalidInvoice    = errors.New("invalid invoice")
+	ErrInvalidTransition = errors.New("invalid invoice status transition")
+	ErrConcurrentUpdate  = errors.New("invoice was modified concurrently")
+	ErrInvalidPayment    = errors.New("invalid payment amount")
+	ErrInvalidRefund     = errors.New("invalid refund amount")
 )
 
+// transitions lists, for each status, the statuses an invoice may move to.
+// Statuses without an entry are terminal.
+var transitions = map[Status][]Status{
+	StatusPending:           {StatusProcessing, StatusCanceled, StatusOverdue},
+	StatusProcessing:        {StatusPaid, StatusPartiallyPaid, StatusFailed, StatusCanceled},
+	StatusPaid:              {StatusPartiallyRefunded, StatusRefunded},
+	StatusPartiallyPaid:     {StatusProcessing, StatusPartiallyRefunded, StatusRefunded, StatusOverdue},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+	StatusFailed:            {StatusProcessing, StatusCanceled, StatusOverdue},
+	StatusOverdue:           {StatusProcessing, StatusCanceled},
+}
+
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s -> %s", ErrInvalidTransition, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+func CanTransition(from, to Status) bool {
+	return slices.Contains(transitions[from], to)
+}
+
+// StatusesLeadingTo returns every status from which an invoice may move to
+// the given one, sorted for stable queries.
+func StatusesLeadingTo(to Status) []Status {
+	var from []Status
+	for status, allowed := range transitions {
+		if slices.Contains(allowed, to) {
+			from = append(from, status)
+		}
+	}
+	slices.Sort(from)
+	return from
+}
+
 type Invoice struct {
-	ID     string
-	Amount int64
-	Status Status
+	ID string
+	// MerchantID is optional and selects merchant-specific settings such as
+	// the payment retry policy.
+	MerchantID string
+	Amount     money.Money
+	Status     Status
+	Lines      []LineItem
+	// AmountPaid never exceeds Amount; anything paid beyond the total is kept
+	// as Credit.
+	AmountPaid     money.Money
+	Credit         money.Money
+	AmountRefunded money.Money
+	// AppliedPayments are the IDs of the payments settled against the
+	// invoice, in the order they were applied.
+	AppliedPayments []string
+	CancelReason    string
+	// PaymentAttempts is how many attempts the last failed payment made.
+	PaymentAttempts int
+	IssuedAt        time.Time
+	DueAt           time.Time
+	// Version is incremented on every persisted change and used for
+	// optimistic concurrency control.
+	Version int
+}
+
+// New builds a pending invoice whose amount is derived from its line items.
+// Zero discounts may be left without a currency.
+func New(id string, currency money.Currency, lines []LineItem, issuedAt, dueAt time.Time) (*Invoice, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalidInvoice)
+	}
+	if dueAt.Before(issuedAt) {
+		return nil, fmt.Errorf("%w: due date must not be before issue date", ErrInvalidInvoice)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: at least one line item is required", ErrInvalidInvoice)
+	}
+
+	normalized := make([]LineItem, len(lines))
+	total := money.Zero(currency)
+
+	for i, line := range lines {
+		if line.Discount.IsZero() {
+			line.Discount = money.Zero(currency)
+		}
+		if err := line.Validate(currency); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		normalized[i] = line
+		sum, err := total.Add(line.Total())
+		if err != nil {
+			return nil, fmt.Errorf("%w: total is too large", ErrInvalidInvoice)
+		}
+		total = sum
+	}
+
+	if !total.IsPositive() {
+		return nil, fmt.Errorf("%w: total must be positive", ErrInvalidInvoice)
+	}
+
+	return &Invoice{
+		ID:             id,
+		Amount:         total,
+		Status:         StatusPending,
+		Lines:          normalized,
+		AmountPaid:     money.Zero(currency),
+		Credit:         money.Zero(currency),
+		AmountRefunded: money.Zero(currency),
+		IssuedAt:       issuedAt.UTC(),
+		DueAt:          dueAt.UTC(),
+	}, nil
+}
+
+func (i *Invoice) Transition(to Status) error {
+	if !CanTransition(i.Status, to) {
+		return &TransitionError{From: i.Status, To: to}
+	}
+
+	i.Status = to
+	return nil
+}
+
+func (i *Invoice) Balance() money.Money {
+	balance, _ := i.Amount.Sub(i.AmountPaid)
+	return balance
+}
+
+// ValidatePaymentAmount checks that amount can be requested against the
+// outstanding balance.
+func (i *Invoice) ValidatePaymentAmount(amount money.Money) error {
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidPayment)
+	}
+
+	cmp, err := amount.Cmp(i.Balance())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayment, err)
+	}
+	if cmp > 0 {
+		return fmt.Errorf("%w: amount %s exceeds balance %s", ErrInvalidPayment, amount, i.Balance())
+	}
+
+	return nil
+}
+
+// ApplyPayment settles a successful payment against the balance, moving the
+// invoice to PAID or PARTIALLY_PAID. Overpayments are recorded as credit.
+// Applying the same payment again is a no-op, which keeps redelivered
+// payment events harmless.
+func (i *Invoice) ApplyPayment(paymentID string, amount money.Money) error {
+	if i.PaymentApplied(paymentID) {
+		return nil
+	}
+
+	if !amount.IsPositive() {
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidPayment)
+	}
+
+	paid, err := i.AmountPaid.Add(amount)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayment, err)
+	}
+
+	to := StatusPartiallyPaid
+	excess, _ := paid.Sub(i.Amount)
+	if !excess.IsNegative() {
+		to = StatusPaid
+		paid = i.Amount
+	}
+
+	if err := i.Transition(to); err != nil {
+		return err
+	}
+
+	i.AmountPaid = paid
+	if excess.IsPositive() {
+		i.Credit, _ = i.Credit.Add(excess)
+	}
+	i.AppliedPayments = append(i.AppliedPayments, paymentID)
+
+	return nil
+}
+
+// PaymentApplied reports whether the payment was already settled against the
+// invoice.
+func (i *Invoice) PaymentApplied(paymentID string) bool {
+	return slices.Contains(i.AppliedPayments, paymentID)
+}
+
+// AcceptsRefunds reports whether refunds can be recorded against the invoice
+// in its current status. While it is PROCESSING, FAILED or OVERDUE they
+// can't: its next status is still decided by the payments.
+func (i *Invoice) AcceptsRefunds() bool {
+	return CanTransition(i.Status, StatusPartiallyRefunded) || CanTransition(i.Status, StatusRefunded)
+}
+
+// RecordRefunds sets the total refunded so far, moving the invoice to
+// REFUNDED once everything received, credit included, has been returned.
+// Recording the same total again is a no-op, which keeps redelivered refund
+// events harmless.
+func (i *Invoice) RecordRefunds(total money.Money) error {
+	if total.IsNegative() {
+		return fmt.Errorf("%w: amount must not be negative", ErrInvalidRefund)
+	}
+
+	cmp, err := total.Cmp(i.AmountRefunded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRefund, err)
+	}
+	if cmp == 0 {
+		return nil
+	}
+
+	received, _ := i.AmountPaid.Add(i.Credit)
+	remaining, err := received.Sub(total)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRefund, err)
+	}
+	if remaining.IsNegative() {
+		return fmt.Errorf("%w: refunded %s exceeds received %s", ErrInvalidRefund, total, received)
+	}
+
+	to := StatusPartiallyRefunded
+	if remaining.IsZero() {
+		to = StatusRefunded
+	}
+
+	if err := i.Transition(to); err != nil {
+		return err
+	}
+
+	i.AmountRefunded = total
+	return nil
+}
+
+// FailPayment marks the invoice FAILED once its payment will not be retried
+// anymore, keeping how many attempts were made.
+func (i *Invoice) FailPayment(attempts int) error {
+	if err := i.Transition(StatusFailed); err != nil {
+		return err
+	}
+
+	i.PaymentAttempts = attempts
+	return nil
+}
+
+// Cancel is only allowed for PENDING, FAILED and OVERDUE invoices, and for
+// PROCESSING ones once the caller has aborted their pending retries.
+func (i *Invoice) Cancel(reason string) error {
+	if err := i.Transition(StatusCanceled); err != nil {
+		return err
+	}
+
+	i.CancelReason = reason
+	return nil
+}
+
+// IsOverdue reports whether the invoice is past due while money is still
+// expected for it.
+func (i *Invoice) IsOverdue(now time.Time) bool {
+	return now.After(i.DueAt) && CanTransition(i.Status, StatusOverdue)
+}
+
+func (i *Invoice) MarkOverdue(now time.Time) error {
+	if !now.After(i.DueAt) {
+		return fmt.Errorf("%w: invoice is due at %s", ErrInvalidTransition, i.DueAt.Format(time.RFC3339))
+	}
+	return i.Transition(StatusOverdue)
 }
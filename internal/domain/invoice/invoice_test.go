@@ -0,0 +1,322 @@
+package invoice_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+var (
+	issuedAt = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	dueAt    = issuedAt.Add(30 * 24 * time.Hour)
+)
+
+func TestInvoice_Transition(t *testing.T) {
+	tests := []struct {
+		from    invoice.Status
+		to      invoice.Status
+		allowed bool
+	}{
+		{invoice.StatusPending, invoice.StatusProcessing, true},
+		{invoice.StatusPending, invoice.StatusCanceled, true},
+		{invoice.StatusPending, invoice.StatusPaid, false},
+		{invoice.StatusProcessing, invoice.StatusPaid, true},
+		{invoice.StatusProcessing, invoice.StatusFailed, true},
+		{invoice.StatusFailed, invoice.StatusProcessing, true},
+		{invoice.StatusPaid, invoice.StatusFailed, false},
+		{invoice.StatusPaid, invoice.StatusPending, false},
+		{invoice.StatusCanceled, invoice.StatusPaid, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			inv := &invoice.Invoice{ID: "inv-1", Status: tt.from}
+
+			err := inv.Transition(tt.to)
+
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if inv.Status != tt.to {
+					t.Fatalf("expected status %s, got %s", tt.to, inv.Status)
+				}
+				return
+			}
+
+			if !errors.Is(err, invoice.ErrInvalidTransition) {
+				t.Fatalf("expected ErrInvalidTransition, got %v", err)
+			}
+
+			var transitionErr *invoice.TransitionError
+			if !errors.As(err, &transitionErr) {
+				t.Fatalf("expected *TransitionError, got %T", err)
+			}
+
+			if inv.Status != tt.from {
+				t.Fatalf("expected status to remain %s, got %s", tt.from, inv.Status)
+			}
+		})
+	}
+}
+
+func TestNew_ShouldDeriveTotalFromLineItems(t *testing.T) {
+	lines := []invoice.LineItem{
+		{
+			Description: "consulting",
+			Quantity:    3,
+			UnitPrice:   money.Money{Amount: 10000, Currency: money.BRL},
+			TaxRate:     1000,
+			Discount:    money.Money{Amount: 5000, Currency: money.BRL},
+		},
+		{
+			Description: "support",
+			Quantity:    1,
+			UnitPrice:   money.Money{Amount: 2999, Currency: money.BRL},
+		},
+	}
+
+	inv, err := invoice.New("inv-1", money.BRL, lines, issuedAt, dueAt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// (3 * 100.00 - 50.00) * 1.10 + 29.99
+	want := money.Money{Amount: 30499, Currency: money.BRL}
+	if inv.Amount != want {
+		t.Fatalf("expected total %s, got %s", want, inv.Amount)
+	}
+
+	if inv.Status != invoice.StatusPending {
+		t.Fatalf("expected status PENDING, got %s", inv.Status)
+	}
+}
+
+func TestNew_ShouldRejectInvalidLineItems(t *testing.T) {
+	valid := invoice.LineItem{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 100, Currency: money.BRL},
+	}
+
+	tests := map[string]func(l *invoice.LineItem){
+		"missing description": func(l *invoice.LineItem) { l.Description = "" },
+		"zero quantity":       func(l *invoice.LineItem) { l.Quantity = 0 },
+		"negative tax rate":   func(l *invoice.LineItem) { l.TaxRate = -1 },
+		"other currency":      func(l *invoice.LineItem) { l.UnitPrice.Currency = money.EUR },
+		"discount above gross": func(l *invoice.LineItem) {
+			l.Discount = money.Money{Amount: 101, Currency: money.BRL}
+		},
+		"gross overflows": func(l *invoice.LineItem) { l.Quantity = math.MaxInt64 },
+		"tax overflows": func(l *invoice.LineItem) {
+			l.UnitPrice.Amount = math.MaxInt64 / 2
+			l.TaxRate = 10000
+		},
+	}
+
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			line := valid
+			mutate(&line)
+
+			_, err := invoice.New("inv-1", money.BRL, []invoice.LineItem{line}, issuedAt, dueAt)
+			if !errors.Is(err, invoice.ErrInvalidInvoice) {
+				t.Fatalf("expected ErrInvalidInvoice, got %v", err)
+			}
+		})
+	}
+
+	if _, err := invoice.New("inv-1", money.BRL, nil, issuedAt, dueAt); !errors.Is(err, invoice.ErrInvalidInvoice) {
+		t.Fatalf("expected ErrInvalidInvoice for invoice without lines, got %v", err)
+	}
+}
+
+func TestNew_ShouldRejectTotalTooLarge(t *testing.T) {
+	line := invoice.LineItem{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: math.MaxInt64 / 2, Currency: money.BRL},
+	}
+
+	_, err := invoice.New("inv-1", money.BRL, []invoice.LineItem{line, line, line}, issuedAt, dueAt)
+	if !errors.Is(err, invoice.ErrInvalidInvoice) {
+		t.Fatalf("expected ErrInvalidInvoice, got %v", err)
+	}
+}
+
+func newProcessingInvoice(t *testing.T, amount int64) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := invoice.New("inv-1", money.BRL, []invoice.LineItem{{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: amount, Currency: money.BRL},
+	}}, issuedAt, dueAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.Transition(invoice.StatusProcessing); err != nil {
+		t.Fatal(err)
+	}
+
+	return inv
+}
+
+func TestInvoice_ApplyPayment_ShouldAccumulateInstallments(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 400, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Status != invoice.StatusPartiallyPaid {
+		t.Fatalf("expected status PARTIALLY_PAID, got %s", inv.Status)
+	}
+	if inv.Balance().Amount != 600 {
+		t.Fatalf("expected balance 600, got %d", inv.Balance().Amount)
+	}
+
+	if err := inv.Transition(invoice.StatusProcessing); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.ApplyPayment("pay-2", money.Money{Amount: 600, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Status != invoice.StatusPaid {
+		t.Fatalf("expected status PAID, got %s", inv.Status)
+	}
+	if !inv.Balance().IsZero() || !inv.Credit.IsZero() {
+		t.Fatalf("expected zero balance and credit, got %s and %s", inv.Balance(), inv.Credit)
+	}
+}
+
+func TestInvoice_ApplyPayment_ShouldApplyEachPaymentOnce(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 400, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := inv.Transition(invoice.StatusProcessing); err != nil {
+		t.Fatal(err)
+	}
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 400, Currency: money.BRL}); err != nil {
+		t.Fatalf("expected applying the same payment to be a no-op, got %v", err)
+	}
+
+	if inv.Status != invoice.StatusProcessing || inv.AmountPaid.Amount != 400 {
+		t.Fatalf("expected PROCESSING with 400 paid, got %s with %s", inv.Status, inv.AmountPaid)
+	}
+	if !inv.PaymentApplied("pay-1") || inv.PaymentApplied("pay-2") {
+		t.Fatalf("expected only pay-1 to be applied, got %v", inv.AppliedPayments)
+	}
+}
+
+func TestInvoice_ApplyPayment_ShouldRecordOverpaymentAsCredit(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 1250, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if inv.Status != invoice.StatusPaid {
+		t.Fatalf("expected status PAID, got %s", inv.Status)
+	}
+	if inv.AmountPaid.Amount != 1000 {
+		t.Fatalf("expected amount paid 1000, got %d", inv.AmountPaid.Amount)
+	}
+	if inv.Credit.Amount != 250 {
+		t.Fatalf("expected credit 250, got %d", inv.Credit.Amount)
+	}
+}
+
+func TestInvoice_ApplyPayment_ShouldRejectOtherCurrency(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+
+	err := inv.ApplyPayment("pay-1", money.Money{Amount: 1000, Currency: money.EUR})
+	if !errors.Is(err, invoice.ErrInvalidPayment) {
+		t.Fatalf("expected ErrInvalidPayment, got %v", err)
+	}
+	if inv.Status != invoice.StatusProcessing {
+		t.Fatalf("expected status to remain PROCESSING, got %s", inv.Status)
+	}
+}
+
+func TestInvoice_ValidatePaymentAmount_ShouldRejectAmountAboveBalance(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+
+	err := inv.ValidatePaymentAmount(money.Money{Amount: 1001, Currency: money.BRL})
+	if !errors.Is(err, invoice.ErrInvalidPayment) {
+		t.Fatalf("expected ErrInvalidPayment, got %v", err)
+	}
+}
+
+func TestInvoice_RecordRefunds(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 1000, Currency: money.BRL}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := inv.RecordRefunds(money.Money{Amount: 300, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Status != invoice.StatusPartiallyRefunded {
+		t.Fatalf("expected status PARTIALLY_REFUNDED, got %s", inv.Status)
+	}
+
+	if err := inv.RecordRefunds(money.Money{Amount: 300, Currency: money.BRL}); err != nil {
+		t.Fatalf("expected recording the same total to be a no-op, got %v", err)
+	}
+
+	err := inv.RecordRefunds(money.Money{Amount: 1001, Currency: money.BRL})
+	if !errors.Is(err, invoice.ErrInvalidRefund) {
+		t.Fatalf("expected ErrInvalidRefund, got %v", err)
+	}
+
+	if err := inv.RecordRefunds(money.Money{Amount: 1000, Currency: money.BRL}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Status != invoice.StatusRefunded {
+		t.Fatalf("expected status REFUNDED, got %s", inv.Status)
+	}
+}
+
+func TestNew_ShouldRejectDueDateBeforeIssueDate(t *testing.T) {
+	line := invoice.LineItem{
+		Description: "item",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 100, Currency: money.BRL},
+	}
+
+	_, err := invoice.New("inv-1", money.BRL, []invoice.LineItem{line}, dueAt, issuedAt)
+	if !errors.Is(err, invoice.ErrInvalidInvoice) {
+		t.Fatalf("expected ErrInvalidInvoice, got %v", err)
+	}
+}
+
+func TestInvoice_MarkOverdue(t *testing.T) {
+	inv := newProcessingInvoice(t, 1000)
+	if err := inv.ApplyPayment("pay-1", money.Money{Amount: 400, Currency: money.BRL}); err != nil {
+		t.Fatal(err)
+	}
+
+	if inv.IsOverdue(dueAt) {
+		t.Fatalf("expected invoice not to be overdue on its due date")
+	}
+	if err := inv.MarkOverdue(dueAt); !errors.Is(err, invoice.ErrInvalidTransition) {
+		t.Fatalf("expected ErrInvalidTransition, got %v", err)
+	}
+
+	later := dueAt.Add(time.Second)
+	if !inv.IsOverdue(later) {
+		t.Fatalf("expected partially paid invoice to be overdue after its due date")
+	}
+	if err := inv.MarkOverdue(later); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inv.Status != invoice.StatusOverdue {
+		t.Fatalf("expected status OVERDUE, got %s", inv.Status)
+	}
+}
@@ -0,0 +1,75 @@
+package invoice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+type LineItem struct {
+	Description string
+	Quantity    int64
+	UnitPrice   money.Money
+	// TaxRate is expressed in basis points (1250 = 12.5%) and applied to the
+	// discounted subtotal of the line.
+	TaxRate  int64
+	Discount money.Money
+}
+
+func (l LineItem) Validate(currency money.Currency) error {
+	switch {
+	case strings.TrimSpace(l.Description) == "":
+		return fmt.Errorf("%w: line description is required", ErrInvalidInvoice)
+	case l.Quantity <= 0:
+		return fmt.Errorf("%w: line quantity must be positive", ErrInvalidInvoice)
+	case l.UnitPrice.IsNegative():
+		return fmt.Errorf("%w: line unit price must not be negative", ErrInvalidInvoice)
+	case l.Discount.IsNegative():
+		return fmt.Errorf("%w: line discount must not be negative", ErrInvalidInvoice)
+	case l.TaxRate < 0:
+		return fmt.Errorf("%w: line tax rate must not be negative", ErrInvalidInvoice)
+	case l.UnitPrice.Currency != currency || l.Discount.Currency != currency:
+		return fmt.Errorf("%w: line currency must be %s", ErrInvalidInvoice, currency)
+	}
+
+	gross, err := l.UnitPrice.Multiply(l.Quantity)
+	if err != nil {
+		return fmt.Errorf("%w: line amount is too large", ErrInvalidInvoice)
+	}
+	if l.Discount.Amount > gross.Amount {
+		return fmt.Errorf("%w: line discount exceeds line amount", ErrInvalidInvoice)
+	}
+
+	tax, err := l.Subtotal().Percentage(l.TaxRate)
+	if err == nil {
+		_, err = l.Subtotal().Add(tax)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: line amount is too large", ErrInvalidInvoice)
+	}
+
+	return nil
+}
+
+// Gross, Subtotal, Tax and Total assume a line that passed Validate, which
+// rules out amounts too large to compute.
+func (l LineItem) Gross() money.Money {
+	gross, _ := l.UnitPrice.Multiply(l.Quantity)
+	return gross
+}
+
+func (l LineItem) Subtotal() money.Money {
+	subtotal, _ := l.Gross().Sub(l.Discount)
+	return subtotal
+}
+
+func (l LineItem) Tax() money.Money {
+	tax, _ := l.Subtotal().Percentage(l.TaxRate)
+	return tax
+}
+
+func (l LineItem) Total() money.Money {
+	total, _ := l.Subtotal().Add(l.Tax())
+	return total
+}
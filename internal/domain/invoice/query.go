@@ -0,0 +1,25 @@
+package invoice
+
+import "time"
+
+// ListQuery selects invoices ordered by issue date, then ID. Zero fields do
+// not filter.
+type ListQuery struct {
+	Status Status
+	// IssuedFrom is inclusive and IssuedTo exclusive.
+	IssuedFrom time.Time
+	IssuedTo   time.Time
+	// After resumes the listing past the given invoice.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of an invoice in a listing.
+type Cursor struct {
+	IssuedAt time.Time
+	ID       string
+}
+
+func CursorOf(inv *Invoice) *Cursor {
+	return &Cursor{IssuedAt: inv.IssuedAt, ID: inv.ID}
+}
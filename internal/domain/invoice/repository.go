@@ -1,7 +1,19 @@
 package invoice
 
+import "time"
+
 type Repository interface {
 	Save(*Invoice) error
 	FindByID(string) (*Invoice, error)
-	UpdateStatus(id string, status Status) error
+	// UpdateStatus moves the invoice from one status to another only if it is
+	// still in the expected status, returning ErrConcurrentUpdate otherwise.
+	UpdateStatus(id string, from, to Status) error
+	// Update persists the mutable state of the invoice only if its stored
+	// version still matches inv.Version, returning ErrConcurrentUpdate
+	// otherwise. On success inv.Version is incremented.
+	Update(inv *Invoice) error
+	// FindOverdue returns up to limit invoices due before now that may still
+	// become OVERDUE, oldest due date first.
+	FindOverdue(now time.Time, limit int) ([]*Invoice, error)
+	List(ListQuery) ([]*Invoice, error)
 }
@@ -0,0 +1,104 @@
+package money_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+func TestNew_ShouldRejectUnknownCurrency(t *testing.T) {
+	if _, err := money.New(100, "XYZ"); !errors.Is(err, money.ErrUnknownCurrency) {
+		t.Fatalf("expected ErrUnknownCurrency, got %v", err)
+	}
+}
+
+func TestNew_ShouldNormalizeCurrencyCode(t *testing.T) {
+	m, err := money.New(100, " eur ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Currency != money.EUR {
+		t.Fatalf("expected EUR, got %s", m.Currency)
+	}
+}
+
+func TestMoney_ShouldRejectMixedCurrencyArithmetic(t *testing.T) {
+	brl := money.Money{Amount: 100, Currency: money.BRL}
+	eur := money.Money{Amount: 100, Currency: money.EUR}
+
+	if _, err := brl.Add(eur); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("Add: expected ErrCurrencyMismatch, got %v", err)
+	}
+	if _, err := brl.Sub(eur); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("Sub: expected ErrCurrencyMismatch, got %v", err)
+	}
+	if _, err := brl.Cmp(eur); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("Cmp: expected ErrCurrencyMismatch, got %v", err)
+	}
+}
+
+func TestMoney_String_ShouldUseCurrencyExponent(t *testing.T) {
+	tests := []struct {
+		money money.Money
+		want  string
+	}{
+		{money.Money{Amount: 1234, Currency: money.BRL}, "12.34 BRL"},
+		{money.Money{Amount: -5, Currency: money.EUR}, "-0.05 EUR"},
+		{money.Money{Amount: 1234, Currency: "JPY"}, "1234 JPY"},
+		{money.Money{Amount: 1234, Currency: "KWD"}, "1.234 KWD"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestMoney_Percentage_ShouldRoundHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		amount      int64
+		basisPoints int64
+		want        int64
+	}{
+		{1000, 1250, 125},
+		{999, 1000, 100},
+		{5, 1000, 1},
+		{4, 1000, 0},
+		{-5, 1000, -1},
+	}
+
+	for _, tt := range tests {
+		got, err := money.Money{Amount: tt.amount, Currency: money.BRL}.Percentage(tt.basisPoints)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.Amount != tt.want {
+			t.Errorf("%d @ %d bps: expected %d, got %d", tt.amount, tt.basisPoints, tt.want, got.Amount)
+		}
+	}
+}
+
+func TestMoney_ShouldRejectOverflow(t *testing.T) {
+	largest := money.Money{Amount: math.MaxInt64, Currency: money.BRL}
+	one := money.Money{Amount: 1, Currency: money.BRL}
+	smallest := money.Money{Amount: math.MinInt64, Currency: money.BRL}
+
+	if _, err := largest.Add(one); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Add: expected ErrOverflow, got %v", err)
+	}
+	if _, err := smallest.Sub(one); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Sub: expected ErrOverflow, got %v", err)
+	}
+	if _, err := largest.Multiply(2); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Multiply: expected ErrOverflow, got %v", err)
+	}
+	if _, err := smallest.Multiply(-1); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Multiply by -1: expected ErrOverflow, got %v", err)
+	}
+	if _, err := largest.Percentage(1250); !errors.Is(err, money.ErrOverflow) {
+		t.Errorf("Percentage: expected ErrOverflow, got %v", err)
+	}
+}
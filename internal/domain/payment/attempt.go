@@ -0,0 +1,17 @@
+package payment
+
+import "time"
+
+// Attempt is a single charge of a payment against the gateway. Retries of a
+// payment are new attempts, numbered from 1, each with its own idempotency
+// key so the gateway executes them.
+type Attempt struct {
+	PaymentID        string
+	InvoiceID        string
+	Number           int
+	Status           Status
+	IdempotencyKey   string
+	DeclineCode      DeclineCode
+	GatewayReference string
+	CreatedAt        time.Time
+}
@@ -0,0 +1,29 @@
+package payment
+
+// DeclineCode classifies why a gateway refused a charge.
+type DeclineCode string
+
+const (
+	DeclineInsufficientFunds  DeclineCode = "insufficient_funds"
+	DeclineDoNotHonor         DeclineCode = "do_not_honor"
+	DeclineProcessingError    DeclineCode = "processing_error"
+	DeclineGatewayUnavailable DeclineCode = "gateway_unavailable"
+	DeclineCardStolen         DeclineCode = "card_stolen"
+	DeclineLostCard           DeclineCode = "lost_card"
+	DeclineExpiredCard        DeclineCode = "expired_card"
+	DeclineInvalidCard        DeclineCode = "invalid_card"
+	DeclineFraudSuspected     DeclineCode = "fraud_suspected"
+)
+
+var retryableDeclines = map[DeclineCode]bool{
+	DeclineInsufficientFunds:  true,
+	DeclineDoNotHonor:         true,
+	DeclineProcessingError:    true,
+	DeclineGatewayUnavailable: true,
+}
+
+// Retryable reports whether a later attempt may succeed. Unknown codes are
+// treated as permanent so that cards are not charged blindly.
+func (c DeclineCode) Retryable() bool {
+	return retryableDeclines[c]
+}
@@ -1,5 +1,13 @@
 package payment
 
+import (
+	"errors"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+var ErrNotFound = errors.New("payment not found")
+
 type Status string
 
 const (
@@ -10,8 +18,10 @@ const (
 )
 
 type Payment struct {
-	ID             string
-	InvoiceID      string
+	ID        string
+	InvoiceID string
+	Amount    money.Money
+	// Attempt is the number of the latest attempt, zero until one is made.
 	Attempt        int
 	Status         Status
 	IdempotencyKey string
@@ -2,7 +2,17 @@ package payment
 
 type Repository interface {
 	Save(*Payment) error
+	FindByID(string) (*Payment, error)
+	// FindByInvoiceID returns the payments of the invoice in the order they
+	// were requested.
+	FindByInvoiceID(invoiceID string) ([]*Payment, error)
 	SaveIfNotExist(*Payment) (bool, error)
 	FindByIdempotencyKey(string) (*Payment, error)
 	UpdateStatus(string, Status) error
+	// SaveAttemptIfNotExist stores the attempt unless the payment already has
+	// one with the same number, and makes it the payment's latest attempt.
+	SaveAttemptIfNotExist(*Attempt) (bool, error)
+	UpdateAttempt(*Attempt) error
+	// FindAttempts returns the attempts of the payment, first attempt first.
+	FindAttempts(paymentID string) ([]*Attempt, error)
 }
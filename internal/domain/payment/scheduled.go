@@ -0,0 +1,34 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+// ScheduledPayment is a payment attempt waiting to be requested at RunAt.
+type ScheduledPayment struct {
+	ID            string
+	InvoiceID     string
+	MerchantID    string
+	PaymentID     string
+	Amount        money.Money
+	PaymentMethod string
+	Attempt       int
+	// Delay is how long the attempt waits after the previous one.
+	Delay time.Duration
+	RunAt time.Time
+	// CorrelationID and CausationID carry the causal chain of the failure
+	// that scheduled the attempt over to the request recorded for it.
+	CorrelationID string
+	CausationID   string
+}
+
+type ScheduleRepository interface {
+	Save(*ScheduledPayment) error
+	// FindDue returns up to limit scheduled payments whose RunAt is not after
+	// now, earliest first.
+	FindDue(now time.Time, limit int) ([]*ScheduledPayment, error)
+	Delete(id string) error
+	DeleteByInvoiceID(invoiceID string) error
+}
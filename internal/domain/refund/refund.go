@@ -0,0 +1,33 @@
+package refund
+
+import (
+	"errors"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+type Status string
+
+const (
+	StatusRequested  Status = "REQUESTED"
+	StatusProcessing Status = "PROCESSING"
+	StatusSucceeded  Status = "SUCCEEDED"
+	StatusFailed     Status = "FAILED"
+)
+
+var ErrConcurrentUpdate = errors.New("refund was modified concurrently")
+
+type Refund struct {
+	ID        string
+	PaymentID string
+	InvoiceID string
+	Amount    money.Money
+	Reason    string
+	Status    Status
+}
+
+// Pending reports whether the refund still counts against the refundable
+// amount of its payment.
+func (r *Refund) Pending() bool {
+	return r.Status != StatusFailed
+}
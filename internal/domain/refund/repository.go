@@ -0,0 +1,11 @@
+package refund
+
+type Repository interface {
+	Save(*Refund) error
+	FindByID(string) (*Refund, error)
+	FindByPaymentID(string) ([]*Refund, error)
+	FindByInvoiceID(string) ([]*Refund, error)
+	// UpdateStatus moves the refund from one status to another only if it is
+	// still in the expected status, returning ErrConcurrentUpdate otherwise.
+	UpdateStatus(id string, from, to Status) error
+}
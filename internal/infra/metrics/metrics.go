@@ -6,6 +6,9 @@ type Counters struct {
 	PaymentsProcessed uint64
 	PaymentsFailed    uint64
 	PaymentsSucceeded uint64
+	RefundsProcessed  uint64
+	RefundsFailed     uint64
+	RefundsSucceeded  uint64
 }
 
 func (c *Counters) IncProcessed() {
@@ -19,3 +22,15 @@ func (c *Counters) IncFailed() {
 func (c *Counters) IncSucceeded() {
 	atomic.AddUint64(&c.PaymentsSucceeded, 1)
 }
+
+func (c *Counters) IncRefundsProcessed() {
+	atomic.AddUint64(&c.RefundsProcessed, 1)
+}
+
+func (c *Counters) IncRefundsFailed() {
+	atomic.AddUint64(&c.RefundsFailed, 1)
+}
+
+func (c *Counters) IncRefundsSucceeded() {
+	atomic.AddUint64(&c.RefundsSucceeded, 1)
+}
@@ -19,11 +19,19 @@ func NewInMemoryBus() *InMemoryBus {
 	}
 }
 
-func (b *InMemoryBus) Subscribe(eventType event.Type, handler HandlerFunc) {
+// Subscribe registers handler for eventType. It fails for types missing from
+// the event registry, so a misspelled subscription is caught at startup
+// instead of silently never firing.
+func (b *InMemoryBus) Subscribe(eventType event.Type, handler HandlerFunc) error {
+	if err := eventType.Validate(); err != nil {
+		return err
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	b.handlers[eventType] = append(b.handlers[eventType], handler)
+	return nil
 }
 
 func (b *InMemoryBus) Publish(evt event.Event) error {
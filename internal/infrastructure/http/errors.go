@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	invoiceApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/invoice"
+	refundApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/refund"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+// statusOf maps the errors of the services to the status clients get.
+// Conflicts depend on the current state and may clear on retry; anything
+// not listed is the server's fault.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, domainInvoice.ErrNotFound),
+		errors.Is(err, payment.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domainInvoice.ErrAlreadyExists),
+		errors.Is(err, invoiceApplication.ErrInvalidInvoiceState),
+		errors.Is(err, refundApplication.ErrPaymentNotRefundable),
+		errors.Is(err, domainInvoice.ErrConcurrentUpdate),
+		errors.Is(err, refund.ErrConcurrentUpdate):
+		return http.StatusConflict
+	case errors.Is(err, domainInvoice.ErrInvalidInvoice),
+		errors.Is(err, domainInvoice.ErrInvalidPayment),
+		errors.Is(err, refundApplication.ErrInvalidRefundAmount),
+		errors.Is(err, money.ErrUnknownCurrency),
+		errors.Is(err, money.ErrCurrencyMismatch),
+		errors.Is(err, money.ErrOverflow):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), statusOf(err))
+}
@@ -1,10 +1,20 @@
 package httpapi
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	invoiceApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/invoice"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
 )
 
 type InvoiceHandler struct {
@@ -12,8 +22,81 @@ type InvoiceHandler struct {
 }
 
 type CreateInvoiceRequest struct {
-	ID     string `json:"id"`
-	Amount int64  `json:"amount"`
+	ID         string                     `json:"id"`
+	MerchantID string                     `json:"merchant_id"`
+	Currency   string                     `json:"currency"`
+	Lines      []CreateInvoiceLineRequest `json:"lines"`
+	DueAt      time.Time                  `json:"due_at"`
+}
+
+type CreateInvoiceLineRequest struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	TaxRate     int64  `json:"tax_rate_bps"`
+	Discount    int64  `json:"discount"`
+}
+
+// InvoiceResponse is how invoices are returned. Line amounts are in the
+// invoice currency, as in CreateInvoiceRequest.
+type InvoiceResponse struct {
+	ID              string                `json:"id"`
+	MerchantID      string                `json:"merchant_id,omitempty"`
+	Status          domainInvoice.Status  `json:"status"`
+	Amount          money.Money           `json:"amount"`
+	AmountPaid      money.Money           `json:"amount_paid"`
+	AmountRefunded  money.Money           `json:"amount_refunded"`
+	Credit          money.Money           `json:"credit"`
+	Balance         money.Money           `json:"balance"`
+	Lines           []InvoiceLineResponse `json:"lines"`
+	PaymentIDs      []string              `json:"payment_ids"`
+	PaymentAttempts int                   `json:"payment_attempts,omitempty"`
+	CancelReason    string                `json:"cancel_reason,omitempty"`
+	IssuedAt        time.Time             `json:"issued_at"`
+	DueAt           time.Time             `json:"due_at"`
+}
+
+type InvoiceLineResponse struct {
+	Description string `json:"description"`
+	Quantity    int64  `json:"quantity"`
+	UnitPrice   int64  `json:"unit_price"`
+	TaxRate     int64  `json:"tax_rate_bps"`
+	Discount    int64  `json:"discount"`
+}
+
+func newInvoiceResponse(inv *domainInvoice.Invoice) InvoiceResponse {
+	lines := make([]InvoiceLineResponse, len(inv.Lines))
+	for i, line := range inv.Lines {
+		lines[i] = InvoiceLineResponse{
+			Description: line.Description,
+			Quantity:    line.Quantity,
+			UnitPrice:   line.UnitPrice.Amount,
+			TaxRate:     line.TaxRate,
+			Discount:    line.Discount.Amount,
+		}
+	}
+
+	paymentIDs := inv.AppliedPayments
+	if paymentIDs == nil {
+		paymentIDs = []string{}
+	}
+
+	return InvoiceResponse{
+		ID:              inv.ID,
+		MerchantID:      inv.MerchantID,
+		Status:          inv.Status,
+		Amount:          inv.Amount,
+		AmountPaid:      inv.AmountPaid,
+		AmountRefunded:  inv.AmountRefunded,
+		Credit:          inv.Credit,
+		Balance:         inv.Balance(),
+		Lines:           lines,
+		PaymentIDs:      paymentIDs,
+		PaymentAttempts: inv.PaymentAttempts,
+		CancelReason:    inv.CancelReason,
+		IssuedAt:        inv.IssuedAt,
+		DueAt:           inv.DueAt,
+	}
 }
 
 func (h *InvoiceHandler) CreateInvoice(w http.ResponseWriter, r *http.Request) {
@@ -23,24 +106,196 @@ func (h *InvoiceHandler) CreateInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inv, err := h.Service.CreateInvoice(req.ID, req.Amount)
+	currency, err := money.ParseCurrency(req.Currency)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lines := make([]domainInvoice.LineItem, len(req.Lines))
+	for i, line := range req.Lines {
+		lines[i] = domainInvoice.LineItem{
+			Description: line.Description,
+			Quantity:    line.Quantity,
+			UnitPrice:   money.Money{Amount: line.UnitPrice, Currency: currency},
+			TaxRate:     line.TaxRate,
+			Discount:    money.Money{Amount: line.Discount, Currency: currency},
+		}
+	}
+
+	inv, err := h.Service.CreateInvoice(req.ID, req.MerchantID, currency, lines, req.DueAt)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(inv)
+	json.NewEncoder(w).Encode(newInvoiceResponse(inv))
+}
+
+// RequestPaymentRequest is optional; without an amount the outstanding
+// balance is requested.
+type RequestPaymentRequest struct {
+	Amount        *int64 `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentMethod string `json:"payment_method"`
 }
 
 func (h *InvoiceHandler) RequestPayment(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
-	if err := h.Service.RequestPayment(id); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req RequestPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.Amount == nil {
+		err = h.Service.RequestPayment(id, req.PaymentMethod)
+	} else {
+		var amount money.Money
+		amount, err = money.New(*req.Amount, req.Currency)
+		if err == nil {
+			err = h.Service.RequestPartialPayment(id, amount, req.PaymentMethod)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
+
+type CancelInvoiceRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h *InvoiceHandler) CancelInvoice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var req CancelInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := h.Service.CancelInvoice(id, req.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newInvoiceResponse(inv))
+}
+
+func (h *InvoiceHandler) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	inv, err := h.Service.GetInvoice(r.PathValue("id"))
+	if errors.Is(err, domainInvoice.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newInvoiceResponse(inv))
+}
+
+type ListInvoicesResponse struct {
+	Invoices   []InvoiceResponse `json:"invoices"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// ListInvoices accepts the status, issued_from, issued_to (RFC 3339), limit
+// and cursor query parameters.
+func (h *InvoiceHandler) ListInvoices(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invoices, next, err := h.Service.ListInvoices(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := ListInvoicesResponse{Invoices: make([]InvoiceResponse, len(invoices))}
+	for i, inv := range invoices {
+		resp.Invoices[i] = newInvoiceResponse(inv)
+	}
+	if next != nil {
+		resp.NextCursor = encodeCursor(next)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseListQuery(values url.Values) (domainInvoice.ListQuery, error) {
+	var q domainInvoice.ListQuery
+	var err error
+
+	if v := values.Get("status"); v != "" {
+		if q.Status, err = domainInvoice.ParseStatus(v); err != nil {
+			return q, err
+		}
+	}
+	if v := values.Get("issued_from"); v != "" {
+		if q.IssuedFrom, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid issued_from: %w", err)
+		}
+	}
+	if v := values.Get("issued_to"); v != "" {
+		if q.IssuedTo, err = time.Parse(time.RFC3339, v); err != nil {
+			return q, fmt.Errorf("invalid issued_to: %w", err)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		if q.After, err = decodeCursor(v); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// Cursors are opaque to clients: the issue date and ID of the last invoice
+// of a page.
+func encodeCursor(c *domainInvoice.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.IssuedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+func decodeCursor(s string) (*domainInvoice.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	issuedAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, issuedAt)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &domainInvoice.Cursor{IssuedAt: t, ID: id}, nil
+}
@@ -0,0 +1,204 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	invoiceApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/invoice"
+	paymentApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/payment"
+	refundApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/refund"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	domainPayment "github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	httpapi "github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/http"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+type failingInvoiceRepository struct {
+	domainInvoice.Repository
+}
+
+func (failingInvoiceRepository) FindByID(string) (*domainInvoice.Invoice, error) {
+	return nil, errors.New("database is locked")
+}
+
+func newRouter(t *testing.T, invoices domainInvoice.Repository, payments domainPayment.Repository) http.Handler {
+	t.Helper()
+
+	recorder := &outbox.Recorder{Repo: inmemory.NewOutboxRepository()}
+
+	return httpapi.NewRouter(
+		&httpapi.InvoiceHandler{Service: &invoiceApplication.Service{
+			Repo:      invoices,
+			Recorder:  recorder,
+			Scheduled: inmemory.NewScheduledPaymentRepository(),
+		}},
+		&httpapi.PaymentHandler{
+			Payments: &paymentApplication.Service{Payments: payments, Invoices: invoices},
+			Refunds: &refundApplication.Service{
+				Invoices: invoices,
+				Payments: payments,
+				Refunds:  inmemory.NewRefundRepository(),
+				Recorder: recorder,
+			},
+		},
+		nil,
+	)
+}
+
+func saveInvoice(t *testing.T, repo domainInvoice.Repository, id string, status domainInvoice.Status) {
+	t.Helper()
+
+	now := time.Now()
+	inv, err := domainInvoice.New(id, money.BRL, []domainInvoice.LineItem{{
+		Description: "Plan",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	inv.Status = status
+	require.NoError(t, repo.Save(inv))
+}
+
+func post(router http.Handler, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+func get(t *testing.T, router http.Handler, path string, body any) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), body))
+}
+
+func TestRequestPayment_ShouldMapErrorsToStatus(t *testing.T) {
+	invoices := inmemory.NewInvoiceRepository()
+	saveInvoice(t, invoices, "inv-processing", domainInvoice.StatusProcessing)
+	saveInvoice(t, invoices, "inv-pending", domainInvoice.StatusPending)
+	router := newRouter(t, invoices, inmemory.NewPaymentRepository())
+
+	require.Equal(t, http.StatusNotFound, post(router, "/invoices/inv-missing/pay", `{}`).Code)
+	require.Equal(t, http.StatusConflict, post(router, "/invoices/inv-processing/pay", `{}`).Code)
+	require.Equal(t, http.StatusBadRequest, post(router, "/invoices/inv-pending/pay", `{"amount":5000,"currency":"BRL"}`).Code)
+	require.Equal(t, http.StatusBadRequest, post(router, "/invoices/inv-pending/pay", `{"amount":500,"currency":"XXX"}`).Code)
+	require.Equal(t, http.StatusAccepted, post(router, "/invoices/inv-pending/pay", `{}`).Code)
+}
+
+func TestRequestPayment_WhenStorageFails_ShouldAnswerServerError(t *testing.T) {
+	router := newRouter(t, failingInvoiceRepository{inmemory.NewInvoiceRepository()}, inmemory.NewPaymentRepository())
+
+	require.Equal(t, http.StatusInternalServerError, post(router, "/invoices/inv-1/pay", `{}`).Code)
+}
+
+func TestRequestRefund_WhenPaymentIsUnknown_ShouldAnswerNotFound(t *testing.T) {
+	router := newRouter(t, inmemory.NewInvoiceRepository(), inmemory.NewPaymentRepository())
+
+	require.Equal(t, http.StatusNotFound, post(router, "/payments/pay-missing/refunds", `{}`).Code)
+}
+
+func TestGetInvoice_ShouldAnswerInSnakeCase(t *testing.T) {
+	invoices := inmemory.NewInvoiceRepository()
+	saveInvoice(t, invoices, "inv-1", domainInvoice.StatusPending)
+	router := newRouter(t, invoices, inmemory.NewPaymentRepository())
+
+	var body map[string]any
+	get(t, router, "/invoices/inv-1", &body)
+	require.Equal(t, "inv-1", body["id"])
+	require.Equal(t, "PENDING", body["status"])
+	require.Equal(t, map[string]any{"amount": 1000.0, "currency": "BRL"}, body["balance"])
+	require.Equal(t, []any{}, body["payment_ids"])
+	require.Contains(t, body, "due_at")
+	require.NotContains(t, body, "Version")
+
+	lines := body["lines"].([]any)
+	require.Len(t, lines, 1)
+	require.Equal(t, 1000.0, lines[0].(map[string]any)["unit_price"])
+}
+
+func savePayment(t *testing.T, payments *inmemory.PaymentRepository) {
+	t.Helper()
+
+	require.NoError(t, payments.Save(&domainPayment.Payment{
+		ID:             "pay-1",
+		InvoiceID:      "inv-1",
+		Amount:         money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:        1,
+		Status:         domainPayment.StatusSuccess,
+		IdempotencyKey: "payment:pay-1:1",
+	}))
+	_, err := payments.SaveAttemptIfNotExist(&domainPayment.Attempt{
+		PaymentID:        "pay-1",
+		InvoiceID:        "inv-1",
+		Number:           1,
+		Status:           domainPayment.StatusSuccess,
+		IdempotencyKey:   "payment:pay-1:1",
+		GatewayReference: "ch_1",
+		CreatedAt:        time.Now(),
+	})
+	require.NoError(t, err)
+}
+
+func TestPaymentHandlers_ShouldAnswerInSnakeCase(t *testing.T) {
+	invoices := inmemory.NewInvoiceRepository()
+	saveInvoice(t, invoices, "inv-1", domainInvoice.StatusPaid)
+	payments := inmemory.NewPaymentRepository()
+	savePayment(t, payments)
+	router := newRouter(t, invoices, payments)
+
+	var pay map[string]any
+	get(t, router, "/payments/pay-1", &pay)
+	require.Equal(t, "pay-1", pay["id"])
+	require.Equal(t, "inv-1", pay["invoice_id"])
+	require.Equal(t, "SUCCESS", pay["status"])
+	require.NotContains(t, pay, "idempotency_key")
+	require.NotContains(t, pay, "IdempotencyKey")
+
+	attempts := pay["attempts"].([]any)
+	require.Len(t, attempts, 1)
+	attempt := attempts[0].(map[string]any)
+	require.Equal(t, 1.0, attempt["number"])
+	require.Equal(t, "ch_1", attempt["gateway_reference"])
+	require.NotContains(t, attempt, "idempotency_key")
+
+	var list []map[string]any
+	get(t, router, "/invoices/inv-1/payments", &list)
+	require.Len(t, list, 1)
+	require.Equal(t, pay, list[0])
+
+	rec := post(router, "/payments/pay-1/refunds", `{"amount":400,"currency":"BRL","reason":"damaged"}`)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var ref map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ref))
+	require.NotEmpty(t, ref["id"])
+	require.Equal(t, "pay-1", ref["payment_id"])
+	require.Equal(t, "inv-1", ref["invoice_id"])
+	require.Equal(t, map[string]any{"amount": 400.0, "currency": "BRL"}, ref["amount"])
+	require.Equal(t, "damaged", ref["reason"])
+	require.Equal(t, "REQUESTED", ref["status"])
+}
+
+func TestCreateInvoice_WhenIDIsTaken_ShouldAnswerConflict(t *testing.T) {
+	invoices := inmemory.NewInvoiceRepository()
+	saveInvoice(t, invoices, "inv-1", domainInvoice.StatusPaid)
+	router := newRouter(t, invoices, inmemory.NewPaymentRepository())
+
+	rec := post(router, "/invoices", `{"id":"inv-1","currency":"BRL","lines":[{"description":"Plan","quantity":1,"unit_price":500}]}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	inv, err := invoices.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, domainInvoice.StatusPaid, inv.Status)
+}
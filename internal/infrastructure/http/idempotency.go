@@ -0,0 +1,215 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/idempotency"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	idempotentReplayed   = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	defaultLease          = time.Minute
+	defaultPurgeInterval  = time.Hour
+	maxIdempotentBody     = 1 << 20
+)
+
+// Idempotency replays the stored response of requests retried with the same
+// Idempotency-Key header. Requests without the header are served as usual.
+type Idempotency struct {
+	Store idempotency.Store
+	// TTL is how long keys are kept; zero means a day.
+	TTL time.Duration
+	// PurgeInterval is how often Run deletes expired keys; zero means an
+	// hour.
+	PurgeInterval time.Duration
+	// Lease is how long a request being processed holds its key, so a
+	// request lost with the process doesn't block retries for the whole
+	// TTL; zero means a minute.
+	Lease time.Duration
+	Now   func() time.Time
+}
+
+// Run deletes expired keys until ctx is done, so the store doesn't keep
+// growing with every request sent with a key.
+func (i *Idempotency) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.purgeInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			i.PurgeOnce()
+		}
+	}
+}
+
+func (i *Idempotency) PurgeOnce() {
+	if err := i.Store.DeleteExpired(i.now()); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func (i *Idempotency) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	if i == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		// the body is hashed and kept whole, so it must fit in memory
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := i.now()
+		existing, err := i.Store.Reserve(&idempotency.Record{
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(i.lease()),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if existing != nil {
+			replay(w, r, body, existing)
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				if err := i.Store.Release(key); err != nil {
+					log.Println(err.Error())
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		if final(rec.status) {
+			err = i.Store.Complete(key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), i.now().Add(i.ttl()))
+		} else {
+			err = i.Store.Release(key)
+		}
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// final reports whether a response is stored for replay. Server errors and
+// the client errors that may clear on their own, such as conflicts with the
+// current state, are not, so the client can retry them.
+func final(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooEarly, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+func replay(w http.ResponseWriter, r *http.Request, body []byte, existing *idempotency.Record) {
+	if existing.RequestHash != requestHash(r, body) {
+		http.Error(w, "idempotency key was used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !existing.Completed() {
+		http.Error(w, "a request with this idempotency key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(idempotentReplayed, "true")
+	w.WriteHeader(existing.StatusCode)
+	w.Write(existing.Body)
+}
+
+// requestHash covers the route as well as the body, so a key cannot be
+// reused across endpoints.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (i *Idempotency) now() time.Time {
+	if i.Now == nil {
+		return time.Now()
+	}
+	return i.Now()
+}
+
+func (i *Idempotency) purgeInterval() time.Duration {
+	if i.PurgeInterval <= 0 {
+		return defaultPurgeInterval
+	}
+	return i.PurgeInterval
+}
+
+func (i *Idempotency) lease() time.Duration {
+	if i.Lease <= 0 {
+		return defaultLease
+	}
+	return i.Lease
+}
+
+func (i *Idempotency) ttl() time.Duration {
+	if i.TTL <= 0 {
+		return defaultIdempotencyTTL
+	}
+	return i.TTL
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
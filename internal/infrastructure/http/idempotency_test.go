@@ -0,0 +1,201 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	httpapi "github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/http"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/idempotency"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func newIdempotentHandler(status int) (http.HandlerFunc, *int) {
+	calls := 0
+	idem := &httpapi.Idempotency{Store: inmemory.NewIdempotencyRepository()}
+
+	return idem.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"call":` + strconv.Itoa(calls) + `}`))
+	}), &calls
+}
+
+func send(handler http.HandlerFunc, key, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(httpapi.IdempotencyKeyHeader, key)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestIdempotency_ShouldReplayStoredResponse(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusCreated)
+
+	first := send(handler, "key-1", "/invoices", `{"id":"inv-1"}`)
+	second := send(handler, "key-1", "/invoices", `{"id":"inv-1"}`)
+
+	require.Equal(t, 1, *calls)
+	require.Equal(t, http.StatusCreated, second.Code)
+	require.Equal(t, first.Body.String(), second.Body.String())
+	require.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	require.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotency_ShouldRejectKeyReusedWithDifferentRequest(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusCreated)
+
+	send(handler, "key-1", "/invoices", `{"id":"inv-1"}`)
+
+	changedBody := send(handler, "key-1", "/invoices", `{"id":"inv-2"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, changedBody.Code)
+
+	changedPath := send(handler, "key-1", "/invoices/inv-1/pay", `{"id":"inv-1"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, changedPath.Code)
+
+	require.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_ShouldNotStoreServerErrors(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusInternalServerError)
+
+	send(handler, "key-1", "/invoices", `{}`)
+	send(handler, "key-1", "/invoices", `{}`)
+
+	require.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_ShouldNotStoreConflicts(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusConflict)
+
+	send(handler, "key-1", "/invoices/inv-1/pay", `{}`)
+	send(handler, "key-1", "/invoices/inv-1/pay", `{}`)
+
+	require.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_ShouldReplayDefinitiveClientErrors(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusBadRequest)
+
+	send(handler, "key-1", "/invoices", `{}`)
+	second := send(handler, "key-1", "/invoices", `{}`)
+
+	require.Equal(t, 1, *calls)
+	require.Equal(t, http.StatusBadRequest, second.Code)
+}
+
+func TestIdempotency_ShouldRejectBodiesTooLargeToHash(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusCreated)
+
+	rec := send(handler, "key-1", "/invoices", `{"id":"`+strings.Repeat("x", 1<<20)+`"}`)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Equal(t, 0, *calls)
+}
+
+func TestIdempotency_ShouldPassThroughRequestsWithoutKey(t *testing.T) {
+	handler, calls := newIdempotentHandler(http.StatusCreated)
+
+	send(handler, "", "/invoices", `{}`)
+	send(handler, "", "/invoices", `{}`)
+
+	require.Equal(t, 2, *calls)
+}
+
+func TestIdempotency_PurgeOnce_ShouldDeleteExpiredKeys(t *testing.T) {
+	store := inmemory.NewIdempotencyRepository()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	idem := &httpapi.Idempotency{Store: store, TTL: time.Hour, Now: func() time.Time { return now }}
+	handler := idem.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send(handler, "key-old", "/invoices", `{}`)
+	now = now.Add(30 * time.Minute)
+	send(handler, "key-new", "/invoices", `{}`)
+	now = now.Add(45 * time.Minute)
+
+	idem.PurgeOnce()
+
+	require.ErrorIs(t, store.Complete("key-old", http.StatusCreated, "", nil, now.Add(time.Hour)), idempotency.ErrKeyNotFound)
+	require.NoError(t, store.Complete("key-new", http.StatusCreated, "", nil, now.Add(time.Hour)))
+}
+
+func TestIdempotency_ShouldReleaseKeyWhenHandlerPanics(t *testing.T) {
+	calls := 0
+	idem := &httpapi.Idempotency{Store: inmemory.NewIdempotencyRepository()}
+	handler := idem.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	require.Panics(t, func() { send(handler, "key-1", "/invoices", `{}`) })
+	retry := send(handler, "key-1", "/invoices", `{}`)
+
+	require.Equal(t, 2, calls)
+	require.Equal(t, http.StatusCreated, retry.Code)
+}
+
+func TestIdempotency_ShouldHandOverKeyOnceTheLeaseRunsOut(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	idem := &httpapi.Idempotency{
+		Store: inmemory.NewIdempotencyRepository(),
+		TTL:   time.Hour,
+		Lease: time.Minute,
+		Now:   func() time.Time { return now },
+	}
+	var retries []int
+	calls := 0
+	var handler http.HandlerFunc
+	handler = idem.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// the client retries while the first request is still running
+			retries = append(retries, send(handler, "key-1", "/invoices", `{}`).Code)
+			now = now.Add(2 * time.Minute)
+			retries = append(retries, send(handler, "key-1", "/invoices", `{}`).Code)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send(handler, "key-1", "/invoices", `{}`)
+
+	require.Equal(t, []int{http.StatusConflict, http.StatusCreated}, retries)
+	require.Equal(t, 2, calls)
+}
+
+func TestIdempotency_ShouldKeepCompletedResponsesForTheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	idem := &httpapi.Idempotency{
+		Store: inmemory.NewIdempotencyRepository(),
+		TTL:   time.Hour,
+		Lease: time.Minute,
+		Now:   func() time.Time { return now },
+	}
+	calls := 0
+	handler := idem.Wrap(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send(handler, "key-1", "/invoices", `{}`)
+	now = now.Add(30 * time.Minute)
+	replayed := send(handler, "key-1", "/invoices", `{}`)
+	now = now.Add(31 * time.Minute)
+	send(handler, "key-1", "/invoices", `{}`)
+
+	require.Equal(t, "true", replayed.Header().Get("Idempotent-Replayed"))
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,156 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	paymentApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/payment"
+	refundApplication "github.com/rcarvalho-pb/payment_system-go/internal/application/refund"
+	domainInvoice "github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+type PaymentHandler struct {
+	Payments *paymentApplication.Service
+	Refunds  *refundApplication.Service
+}
+
+// RequestRefundRequest refunds everything still refundable when no amount
+// is given.
+type RequestRefundRequest struct {
+	Amount   *int64 `json:"amount"`
+	Currency string `json:"currency"`
+	Reason   string `json:"reason"`
+}
+
+// PaymentResponse is how payments are returned, along with their attempts.
+type PaymentResponse struct {
+	ID        string            `json:"id"`
+	InvoiceID string            `json:"invoice_id"`
+	Amount    money.Money       `json:"amount"`
+	Status    payment.Status    `json:"status"`
+	Attempts  []AttemptResponse `json:"attempts"`
+}
+
+type AttemptResponse struct {
+	Number           int                 `json:"number"`
+	Status           payment.Status      `json:"status"`
+	DeclineCode      payment.DeclineCode `json:"decline_code,omitempty"`
+	GatewayReference string              `json:"gateway_reference,omitempty"`
+	CreatedAt        time.Time           `json:"created_at"`
+}
+
+type RefundResponse struct {
+	ID        string        `json:"id"`
+	PaymentID string        `json:"payment_id"`
+	InvoiceID string        `json:"invoice_id"`
+	Amount    money.Money   `json:"amount"`
+	Reason    string        `json:"reason,omitempty"`
+	Status    refund.Status `json:"status"`
+}
+
+func newPaymentResponse(details *paymentApplication.Details) PaymentResponse {
+	attempts := make([]AttemptResponse, len(details.Attempts))
+	for i, attempt := range details.Attempts {
+		attempts[i] = AttemptResponse{
+			Number:           attempt.Number,
+			Status:           attempt.Status,
+			DeclineCode:      attempt.DeclineCode,
+			GatewayReference: attempt.GatewayReference,
+			CreatedAt:        attempt.CreatedAt,
+		}
+	}
+
+	return PaymentResponse{
+		ID:        details.ID,
+		InvoiceID: details.InvoiceID,
+		Amount:    details.Amount,
+		Status:    details.Status,
+		Attempts:  attempts,
+	}
+}
+
+func newRefundResponse(ref *refund.Refund) RefundResponse {
+	return RefundResponse{
+		ID:        ref.ID,
+		PaymentID: ref.PaymentID,
+		InvoiceID: ref.InvoiceID,
+		Amount:    ref.Amount,
+		Reason:    ref.Reason,
+		Status:    ref.Status,
+	}
+}
+
+func (h *PaymentHandler) RequestRefund(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	var req RequestRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		ref *refund.Refund
+		err error
+	)
+	if req.Amount == nil {
+		ref, err = h.Refunds.RequestRefund(paymentID, req.Reason)
+	} else {
+		var amount money.Money
+		amount, err = money.New(*req.Amount, req.Currency)
+		if err == nil {
+			ref, err = h.Refunds.RequestPartialRefund(paymentID, amount, req.Reason)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newRefundResponse(ref))
+}
+
+func (h *PaymentHandler) GetPayment(w http.ResponseWriter, r *http.Request) {
+	details, err := h.Payments.GetPayment(r.PathValue("id"))
+	if errors.Is(err, payment.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newPaymentResponse(details))
+}
+
+func (h *PaymentHandler) ListInvoicePayments(w http.ResponseWriter, r *http.Request) {
+	payments, err := h.Payments.ListInvoicePayments(r.PathValue("id"))
+	if errors.Is(err, domainInvoice.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]PaymentResponse, len(payments))
+	for i, details := range payments {
+		resp[i] = newPaymentResponse(details)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -2,11 +2,20 @@ package httpapi
 
 import "net/http"
 
-func NewRouter(handler *InvoiceHandler) http.Handler {
+// NewRouter wires the API routes. idem may be nil to disable Idempotency-Key
+// support.
+func NewRouter(invoices *InvoiceHandler, payments *PaymentHandler, idem *Idempotency) http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /invoices", handler.CreateInvoice)
-	mux.HandleFunc("POST /invoices/{id}/pay", handler.RequestPayment)
+	mux.HandleFunc("POST /invoices", idem.Wrap(invoices.CreateInvoice))
+	mux.HandleFunc("POST /invoices/{id}/pay", idem.Wrap(invoices.RequestPayment))
+	mux.HandleFunc("POST /invoices/{id}/cancel", idem.Wrap(invoices.CancelInvoice))
+	mux.HandleFunc("POST /payments/{id}/refunds", idem.Wrap(payments.RequestRefund))
+
+	mux.HandleFunc("GET /invoices", invoices.ListInvoices)
+	mux.HandleFunc("GET /invoices/{id}", invoices.GetInvoice)
+	mux.HandleFunc("GET /invoices/{id}/payments", payments.ListInvoicePayments)
+	mux.HandleFunc("GET /payments/{id}", payments.GetPayment)
 
 	return mux
 }
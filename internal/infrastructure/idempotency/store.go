@@ -0,0 +1,41 @@
+// Package idempotency keeps the responses of requests sent with a
+// client-supplied Idempotency-Key so retries can be answered with them.
+package idempotency
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrKeyNotFound = errors.New("idempotency key not found")
+
+// Record is the stored outcome of a request. A record without a StatusCode
+// belongs to a request still being processed, and its ExpiresAt ends the
+// lease that request holds on the key.
+type Record struct {
+	Key         string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (r *Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+type Store interface {
+	// Reserve claims rec.Key for a new request. When the key is already held
+	// by an unexpired record, that record is returned instead and nothing is
+	// stored.
+	Reserve(rec *Record) (*Record, error)
+	// Complete stores the response of the request holding the key and keeps
+	// it until expiresAt.
+	Complete(key string, statusCode int, contentType string, body []byte, expiresAt time.Time) error
+	// Release frees the key so the request can be sent again.
+	Release(key string) error
+	// DeleteExpired removes records expired at now.
+	DeleteExpired(now time.Time) error
+}
@@ -2,19 +2,47 @@ package outbox
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 )
 
+// DefaultLease is how long a dispatcher holds the events it claims when
+// Lease is not set.
+const DefaultLease = 30 * time.Second
+
+// DefaultRetry spaces failed publishes when Retry is not set.
+var DefaultRetry worker.RetryPolicy = worker.ExponentialBackoff{
+	Base:        time.Second,
+	Max:         5 * time.Minute,
+	MaxAttempts: 10,
+}
+
+// Dispatcher publishes the events it claims from the outbox. Several
+// dispatchers, in one process or many, can share an outbox: each claims its
+// own batch, and takes over events whose owner let the lease run out.
 type Dispatcher struct {
 	Repo         Repository
 	EventBus     worker.EventPublisher
 	PollInterval time.Duration
 	BatchSize    int
+	// ID names the dispatcher in the leases it takes; it must be unique
+	// among dispatchers sharing the outbox. A random one is used if empty.
+	ID string
+	// Lease should outlast publishing a whole batch. Events not published
+	// by the time it runs out are left for whoever claims them next.
+	Lease time.Duration
+	// Retry decides when an event whose publish failed is attempted again;
+	// once it gives up the event is dead lettered.
+	Retry worker.RetryPolicy
+	Now   func() time.Time
+
+	idOnce sync.Once
 }
 
 func (d *Dispatcher) Run(ctx context.Context) {
@@ -26,39 +54,117 @@ func (d *Dispatcher) Run(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			log.Println("ticker")
 			d.DispatchOnce()
 		}
 	}
 }
 
 func (d *Dispatcher) DispatchOnce() {
-	log.Println("Dispatch one")
-	events, err := d.Repo.FindUnpublished(d.BatchSize)
+	now, lease := d.now(), d.lease()
+	expires := now.Add(lease)
+	events, err := d.Repo.Claim(d.owner(), now, lease, d.BatchSize)
 	if err != nil {
+		// the next tick tries again
 		log.Println(err.Error())
-		return // logável, mas não fatal
+		return
 	}
 
-	log.Println(events)
-	log.Println("events")
-
-	for _, evt := range events {
-		var payload any
+	for i, evt := range events {
+		if !d.now().Before(expires) {
+			log.Printf("outbox lease of %s expired, leaving %d events", d.owner(), len(events)-i)
+			return
+		}
 
-		if err := json.Unmarshal(evt.Payload, &payload); err != nil {
+		payload, err := event.DecodePayload(evt.Type, evt.Payload)
+		if err != nil {
+			// retrying can't fix an undecodable row, so it is set aside
+			// instead of blocking the outbox
+			log.Printf("poisoned outbox event %s: %v", evt.ID, err)
+			if err := d.Repo.MarkPoisoned(evt.ID, err.Error()); err != nil {
+				log.Println(err.Error())
+			}
 			continue
 		}
 
 		domainEvent := event.Event{
-			Type:    evt.Type,
-			Payload: payload,
+			ID:            evt.ID,
+			Type:          evt.Type,
+			Version:       evt.Version,
+			OccurredAt:    evt.OccurredAt,
+			Aggregate:     evt.Aggregate,
+			CorrelationID: evt.CorrelationID,
+			CausationID:   evt.CausationID,
+			Payload:       payload,
 		}
 
 		if err := d.EventBus.Publish(domainEvent); err != nil {
+			err = d.fail(evt, err)
+			if errors.Is(err, ErrLeaseLost) {
+				log.Printf("outbox lease of %s lost, leaving %d events", d.owner(), len(events)-i-1)
+				return
+			}
+			if err != nil {
+				log.Println(err.Error())
+			}
 			continue
 		}
 
-		_ = d.Repo.MarkPublished(evt.ID)
+		err = d.Repo.MarkPublished(evt.ID, d.owner())
+		if errors.Is(err, ErrLeaseLost) {
+			// whoever holds the event now publishes it again, which
+			// consumers must tolerate anyway
+			log.Printf("outbox lease of %s lost, leaving %d events", d.owner(), len(events)-i-1)
+			return
+		}
+		if err != nil {
+			// the event goes out again once the lease runs out
+			log.Printf("marking outbox event %s published: %v", evt.ID, err)
+		}
+	}
+}
+
+// fail schedules the next attempt of an event that could not be published,
+// or dead letters it when the retry policy gives up.
+func (d *Dispatcher) fail(evt OutboxEvent, publishErr error) error {
+	now := d.now()
+	attempt := evt.Attempts + 1
+
+	delay, retry := d.retry().NextDelay(worker.FailedAttempt{Attempt: attempt})
+	if !retry {
+		log.Printf("dead lettering outbox event %s after %d attempts: %v", evt.ID, attempt, publishErr)
+		return d.Repo.DeadLetter(evt.ID, d.owner(), publishErr.Error(), now)
+	}
+
+	log.Printf("publishing outbox event %s failed (attempt %d), retrying in %s: %v", evt.ID, attempt, delay, publishErr)
+	return d.Repo.MarkFailed(evt.ID, d.owner(), publishErr.Error(), now.Add(delay))
+}
+
+func (d *Dispatcher) owner() string {
+	d.idOnce.Do(func() {
+		if d.ID == "" {
+			d.ID = "dispatcher_" + rand.Text()
+		}
+	})
+	return d.ID
+}
+
+func (d *Dispatcher) lease() time.Duration {
+	if d.Lease > 0 {
+		return d.Lease
+	}
+	return DefaultLease
+}
+
+func (d *Dispatcher) retry() worker.RetryPolicy {
+	if d.Retry != nil {
+		return d.Retry
+	}
+	return DefaultRetry
+}
+
+func (d *Dispatcher) now() time.Time {
+	if d.Now != nil {
+		return d.Now()
 	}
+	return time.Now()
 }
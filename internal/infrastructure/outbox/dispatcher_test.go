@@ -1,14 +1,35 @@
 package outbox_test
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
 )
 
+func setupTestDB(t *testing.T) *sql.DB {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := sqlite.RunMigrations(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
 type fakeBus struct {
 	published []event.Event
 	fail      bool
@@ -24,7 +45,7 @@ func (f *fakeBus) Publish(evt event.Event) error {
 
 func TestDispatcher_ShouldPublishAndMarkEvent(t *testing.T) {
 	db := setupTestDB(t)
-	repo := outbox.NewSQLiteRepository(db)
+	repo := sqlite.NewOutboxRepository(db)
 
 	bus := &fakeBus{}
 
@@ -58,3 +79,280 @@ func TestDispatcher_ShouldPublishAndMarkEvent(t *testing.T) {
 		t.Fatalf("expected no unpublished events")
 	}
 }
+
+func TestDispatcher_ShouldDecodeRegisteredPayloadType(t *testing.T) {
+	db := setupTestDB(t)
+	repo := sqlite.NewOutboxRepository(db)
+	bus := &fakeBus{}
+
+	dispatcher := &outbox.Dispatcher{
+		Repo:      repo,
+		EventBus:  bus,
+		BatchSize: 10,
+	}
+
+	recorder := &outbox.Recorder{Repo: repo}
+	want := event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 1000, Currency: money.BRL},
+		Attempt:   2,
+	}
+	cause := event.New(event.PaymentFailed, event.Payment("pay-1"), nil)
+	recorded := event.CausedBy(cause, event.PaymentRequested, event.Invoice("inv-1"), want)
+	if err := recorder.Record(recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher.DispatchOnce()
+
+	if len(bus.published) != 1 {
+		t.Fatalf("expected 1 event published, got %d", len(bus.published))
+	}
+
+	got, ok := bus.published[0].Payload.(event.PaymentRequestPayload)
+	if !ok {
+		t.Fatalf("expected PaymentRequestPayload, got %T", bus.published[0].Payload)
+	}
+	if got != want {
+		t.Fatalf("expected payload %+v, got %+v", want, got)
+	}
+
+	dispatched := bus.published[0]
+	dispatched.Payload = recorded.Payload
+	if !dispatched.OccurredAt.Equal(recorded.OccurredAt) {
+		t.Fatalf("expected occurred at %v, got %v", recorded.OccurredAt, dispatched.OccurredAt)
+	}
+	dispatched.OccurredAt = recorded.OccurredAt
+	if dispatched != recorded {
+		t.Fatalf("expected envelope %+v, got %+v", recorded, dispatched)
+	}
+}
+
+func TestDispatcher_ShouldPoisonUndecodableEvents(t *testing.T) {
+	db := setupTestDB(t)
+	repo := sqlite.NewOutboxRepository(db)
+	bus := &fakeBus{}
+
+	dispatcher := &outbox.Dispatcher{
+		Repo:      repo,
+		EventBus:  bus,
+		BatchSize: 10,
+	}
+
+	rows := []outbox.OutboxEvent{
+		{ID: "evt-unknown", Type: "SOMETHING_HAPPENED", Payload: []byte(`{}`)},
+		{ID: "evt-garbage", Type: event.PaymentSucceeded, Payload: []byte(`not json`)},
+		{ID: "evt-ok", Type: event.PaymentSucceeded, Payload: []byte(`{"invoice_id":"inv-1"}`)},
+	}
+	for i, row := range rows {
+		row.CreatedAt = time.Now().Add(time.Duration(i) * time.Millisecond)
+		if err := repo.Save(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatcher.DispatchOnce()
+
+	if len(bus.published) != 1 {
+		t.Fatalf("expected only the decodable event published, got %d", len(bus.published))
+	}
+
+	events, _ := repo.FindUnpublished(10)
+	if len(events) != 0 {
+		t.Fatalf("expected poisoned events to leave the dispatch queue, got %d", len(events))
+	}
+
+	var poisoned int
+	var lastError string
+	if err := db.QueryRow(
+		`SELECT COUNT(*), MAX(last_error) FROM outbox_events WHERE poisoned_at IS NOT NULL`,
+	).Scan(&poisoned, &lastError); err != nil {
+		t.Fatal(err)
+	}
+	if poisoned != 2 {
+		t.Fatalf("expected 2 poisoned events, got %d", poisoned)
+	}
+	if lastError == "" {
+		t.Fatalf("expected the decoding error to be kept")
+	}
+}
+
+type countingBus struct {
+	mu        sync.Mutex
+	published map[string]int
+}
+
+func (b *countingBus) Publish(evt event.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.published[evt.ID]++
+	return nil
+}
+
+func TestDispatcher_ShouldSplitTheOutboxBetweenDispatchers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := sqlite.NewOutboxRepository(db)
+	bus := &countingBus{published: make(map[string]int)}
+
+	const events = 30
+	for i := range events {
+		if err := repo.Save(outbox.OutboxEvent{
+			ID:        fmt.Sprintf("evt-%d", i),
+			Type:      event.PaymentSucceeded,
+			Payload:   []byte(`{"invoice_id":"inv-1"}`),
+			CreatedAt: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range 3 {
+		dispatcher := &outbox.Dispatcher{
+			Repo:      repo,
+			EventBus:  bus,
+			BatchSize: 4,
+			ID:        fmt.Sprintf("dispatcher-%d", i),
+		}
+		wg.Go(func() {
+			for range events {
+				dispatcher.DispatchOnce()
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(bus.published) != events {
+		t.Fatalf("expected %d events published, got %d", events, len(bus.published))
+	}
+	for id, times := range bus.published {
+		if times != 1 {
+			t.Fatalf("expected %s published once, got %d", id, times)
+		}
+	}
+}
+
+func TestDispatcher_ShouldLeaveEventsOnceItsLeaseExpires(t *testing.T) {
+	db := setupTestDB(t)
+	repo := sqlite.NewOutboxRepository(db)
+
+	for _, id := range []string{"evt-1", "evt-2", "evt-3"} {
+		if err := repo.Save(outbox.OutboxEvent{
+			ID:        id,
+			Type:      event.PaymentSucceeded,
+			Payload:   []byte(`{"invoice_id":"inv-1"}`),
+			CreatedAt: time.Now(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every publish takes the whole lease, so only the first event is
+	// published before the lease runs out
+	now := time.Now()
+	slow := &outbox.Dispatcher{
+		Repo:      repo,
+		BatchSize: 10,
+		ID:        "slow",
+		Lease:     time.Minute,
+		Now:       func() time.Time { return now },
+	}
+	slow.EventBus = publisherFunc(func(event.Event) error {
+		now = now.Add(time.Minute)
+		return nil
+	})
+
+	slow.DispatchOnce()
+
+	taker := &outbox.Dispatcher{
+		Repo:      repo,
+		EventBus:  &fakeBus{},
+		BatchSize: 10,
+		ID:        "taker",
+		Now:       func() time.Time { return now },
+	}
+	taker.DispatchOnce()
+
+	got := taker.EventBus.(*fakeBus).published
+	if len(got) != 2 || got[0].ID != "evt-2" || got[1].ID != "evt-3" {
+		t.Fatalf("expected the taker to publish evt-2 and evt-3, got %+v", got)
+	}
+}
+
+type publisherFunc func(event.Event) error
+
+func (f publisherFunc) Publish(evt event.Event) error {
+	return f(evt)
+}
+
+func TestDispatcher_ShouldBackOffAndDeadLetterFailingEvents(t *testing.T) {
+	db := setupTestDB(t)
+	repo := sqlite.NewOutboxRepository(db)
+
+	if err := repo.Save(outbox.OutboxEvent{
+		ID:        "evt-1",
+		Type:      event.PaymentSucceeded,
+		Payload:   []byte(`{"invoice_id":"inv-1"}`),
+		CreatedAt: time.Now(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	bus := &fakeBus{fail: true}
+	dispatcher := &outbox.Dispatcher{
+		Repo:      repo,
+		EventBus:  bus,
+		BatchSize: 10,
+		Retry:     worker.FixedInterval{Interval: time.Minute, MaxAttempts: 3},
+		Now:       func() time.Time { return now },
+	}
+
+	dispatcher.DispatchOnce()
+
+	events, err := repo.FindUnpublished(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Attempts != 1 || events[0].LastError != "bus down" {
+		t.Fatalf("expected one failed attempt recorded, got %+v", events)
+	}
+	if !events[0].NextAttemptAt.Equal(now.Add(time.Minute).UTC()) {
+		t.Fatalf("expected next attempt at %v, got %v", now.Add(time.Minute), events[0].NextAttemptAt)
+	}
+
+	// not due yet
+	dispatcher.DispatchOnce()
+	if events, _ := repo.FindUnpublished(10); events[0].Attempts != 1 {
+		t.Fatalf("expected no attempt before the backoff, got %d", events[0].Attempts)
+	}
+
+	for range 2 {
+		now = now.Add(time.Minute)
+		dispatcher.DispatchOnce()
+	}
+
+	if events, _ := repo.FindUnpublished(10); len(events) != 0 {
+		t.Fatalf("expected the event to leave the outbox, got %+v", events)
+	}
+
+	letter, err := repo.FindDeadLetter("evt-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if letter.Attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", letter.Attempts)
+	}
+
+	// replayed events start over
+	if err := repo.Replay("evt-1"); err != nil {
+		t.Fatal(err)
+	}
+	bus.fail = false
+	dispatcher.DispatchOnce()
+
+	if len(bus.published) != 1 {
+		t.Fatalf("expected the replayed event published, got %d", len(bus.published))
+	}
+}
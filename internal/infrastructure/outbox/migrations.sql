@@ -1,10 +0,0 @@
-CREATE TABLE IF NOT EXISTS outbox_events (
-    id TEXT PRIMARY KEY,
-    event_type TEXT NOT NULL,
-    payload BLOB NOT NULL,
-    published INTEGER NOT NULL DEFAULT 0,
-    created_at DATETIME NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_outbox_unpublished
-ON outbox_events(published, created_at);
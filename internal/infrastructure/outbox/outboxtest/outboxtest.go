@@ -0,0 +1,397 @@
+// Package outboxtest holds the contract every outbox.Repository must honour.
+package outboxtest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+)
+
+// Store is an outbox repository that also keeps its dead letters.
+type Store interface {
+	outbox.Repository
+	outbox.DeadLetters
+}
+
+// Run checks a store against the contract. newRepo must return an empty
+// store on each call.
+func Run(t *testing.T, newRepo func(t *testing.T) Store) {
+	t.Run("SaveKeepsTheEnvelope", func(t *testing.T) {
+		repo := newRepo(t)
+		want := newEvent(1)
+
+		require.NoError(t, repo.Save(want))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		requireSameEvent(t, want, events[0])
+		require.False(t, events[0].Published)
+		require.False(t, events[0].Poisoned)
+	})
+
+	t.Run("SaveRejectsDuplicateIDs", func(t *testing.T) {
+		repo := newRepo(t)
+
+		require.NoError(t, repo.Save(newEvent(1)))
+		require.Error(t, repo.Save(newEvent(1)))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+	})
+
+	t.Run("FindUnpublishedReturnsOldestFirstUpToLimit", func(t *testing.T) {
+		repo := newRepo(t)
+		for _, n := range []int{3, 1, 2} {
+			require.NoError(t, repo.Save(newEvent(n)))
+		}
+
+		events, err := repo.FindUnpublished(2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-2"}, ids(events))
+	})
+
+	t.Run("FindUnpublishedOnEmptyRepository", func(t *testing.T) {
+		events, err := newRepo(t).FindUnpublished(10)
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("ClaimLeasesEventsToOneOwner", func(t *testing.T) {
+		repo := newRepo(t)
+		for n := 1; n <= 3; n++ {
+			require.NoError(t, repo.Save(newEvent(n)))
+		}
+
+		first, err := repo.Claim("dispatcher-a", base, time.Minute, 2)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1", "evt-2"}, ids(first))
+		for _, evt := range first {
+			require.Equal(t, "dispatcher-a", evt.ClaimedBy)
+			require.True(t, evt.LeaseExpiresAt.Equal(base.Add(time.Minute)))
+		}
+
+		second, err := repo.Claim("dispatcher-b", base, time.Minute, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-3"}, ids(second))
+	})
+
+	t.Run("ClaimRenewsTheOwnersLease", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+
+		_, err := repo.Claim("dispatcher-a", base, time.Minute, 10)
+		require.NoError(t, err)
+
+		again, err := repo.Claim("dispatcher-a", base.Add(time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1"}, ids(again))
+		require.True(t, again[0].LeaseExpiresAt.Equal(base.Add(time.Second+time.Minute)))
+	})
+
+	t.Run("ClaimTakesOverExpiredLeases", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+
+		_, err := repo.Claim("dispatcher-a", base, time.Minute, 10)
+		require.NoError(t, err)
+
+		early, err := repo.Claim("dispatcher-b", base.Add(time.Minute-time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		require.Empty(t, early)
+
+		late, err := repo.Claim("dispatcher-b", base.Add(time.Minute), time.Minute, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1"}, ids(late))
+		require.Equal(t, "dispatcher-b", late[0].ClaimedBy)
+	})
+
+	t.Run("ClaimSkipsPublishedAndPoisonedEvents", func(t *testing.T) {
+		repo := newRepo(t)
+		for n := 1; n <= 3; n++ {
+			require.NoError(t, repo.Save(newEvent(n)))
+		}
+		first, err := repo.Claim("dispatcher-a", base, time.Minute, 1)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1"}, ids(first))
+		require.NoError(t, repo.MarkPublished("evt-1", "dispatcher-a"))
+		require.NoError(t, repo.MarkPoisoned("evt-2", "cannot decode"))
+
+		claimed, err := repo.Claim("dispatcher-a", base, time.Minute, 10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-3"}, ids(claimed))
+	})
+
+	t.Run("ConcurrentClaimsNeverShareEvents", func(t *testing.T) {
+		repo := newRepo(t)
+		const events, owners = 40, 4
+		for n := 1; n <= events; n++ {
+			require.NoError(t, repo.Save(newEvent(n)))
+		}
+
+		claimed := make([][]outbox.OutboxEvent, owners)
+		var wg sync.WaitGroup
+		for i := range owners {
+			wg.Go(func() {
+				owner := fmt.Sprintf("dispatcher-%d", i)
+				for {
+					batch, err := repo.Claim(owner, base, time.Minute, 3)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if len(batch) == 0 {
+						return
+					}
+					claimed[i] = append(claimed[i], batch...)
+					for _, evt := range batch {
+						if err := repo.MarkPublished(evt.ID, owner); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}
+			})
+		}
+		wg.Wait()
+
+		seen := make(map[string]bool)
+		for _, batch := range claimed {
+			for _, evt := range batch {
+				require.False(t, seen[evt.ID], "%s claimed twice", evt.ID)
+				seen[evt.ID] = true
+			}
+		}
+		require.Len(t, seen, events)
+	})
+
+	t.Run("MarkFailedDelaysTheNextClaim", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+
+		_, err := repo.Claim("dispatcher-a", base, time.Minute, 10)
+		require.NoError(t, err)
+		require.NoError(t, repo.MarkFailed("evt-1", "dispatcher-a", "bus down", base.Add(10*time.Second)))
+
+		early, err := repo.Claim("dispatcher-a", base.Add(9*time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		require.Empty(t, early)
+
+		// the lease was released, so anyone may take the next attempt
+		due, err := repo.Claim("dispatcher-b", base.Add(10*time.Second), time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.Equal(t, 1, due[0].Attempts)
+		require.Equal(t, "bus down", due[0].LastError)
+		require.True(t, due[0].NextAttemptAt.Equal(base.Add(10*time.Second)))
+	})
+
+	t.Run("DeadLetterMovesTheEventOutOfTheOutbox", func(t *testing.T) {
+		repo := newRepo(t)
+		want := newEvent(1)
+		require.NoError(t, repo.Save(want))
+		require.NoError(t, repo.Save(newEvent(2)))
+		claim(t, repo, "dispatcher-a", base)
+		require.NoError(t, repo.MarkFailed("evt-1", "dispatcher-a", "bus down", base))
+
+		claim(t, repo, "dispatcher-a", base)
+		require.NoError(t, repo.DeadLetter("evt-1", "dispatcher-a", "still down", base.Add(time.Hour)))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-2"}, ids(events))
+
+		letter, err := repo.FindDeadLetter("evt-1")
+		require.NoError(t, err)
+		require.Equal(t, 2, letter.Attempts)
+		require.Equal(t, "still down", letter.LastError)
+		require.True(t, letter.DeadAt.Equal(base.Add(time.Hour)))
+		letter.Attempts, letter.LastError = 0, ""
+		requireSameEvent(t, want, letter.OutboxEvent)
+
+		letters, err := repo.ListDeadLetters(10)
+		require.NoError(t, err)
+		require.Len(t, letters, 1)
+	})
+
+	t.Run("ListDeadLettersReturnsOldestFirstUpToLimit", func(t *testing.T) {
+		repo := newRepo(t)
+		for n := 1; n <= 3; n++ {
+			require.NoError(t, repo.Save(newEvent(n)))
+			claim(t, repo, "dispatcher-a", base)
+			require.NoError(t, repo.DeadLetter(fmt.Sprintf("evt-%d", n), "dispatcher-a", "bus down", base.Add(time.Duration(n)*time.Second)))
+		}
+
+		letters, err := repo.ListDeadLetters(2)
+		require.NoError(t, err)
+		require.Len(t, letters, 2)
+		require.Equal(t, "evt-1", letters[0].ID)
+		require.Equal(t, "evt-2", letters[1].ID)
+	})
+
+	t.Run("ReplayReturnsTheEventToTheOutbox", func(t *testing.T) {
+		repo := newRepo(t)
+		want := newEvent(1)
+		require.NoError(t, repo.Save(want))
+		claim(t, repo, "dispatcher-a", base)
+		require.NoError(t, repo.MarkFailed("evt-1", "dispatcher-a", "bus down", base.Add(time.Hour)))
+		claim(t, repo, "dispatcher-a", base.Add(time.Hour))
+		require.NoError(t, repo.DeadLetter("evt-1", "dispatcher-a", "bus down", base))
+
+		require.NoError(t, repo.Replay("evt-1"))
+
+		_, err := repo.FindDeadLetter("evt-1")
+		require.ErrorIs(t, err, outbox.ErrDeadLetterNotFound)
+
+		claimed, err := repo.Claim("dispatcher-a", base, time.Minute, 10)
+		require.NoError(t, err)
+		require.Len(t, claimed, 1)
+		require.Zero(t, claimed[0].Attempts)
+		require.Empty(t, claimed[0].LastError)
+		claimed[0].ClaimedBy, claimed[0].LeaseExpiresAt = "", time.Time{}
+		requireSameEvent(t, want, claimed[0])
+	})
+
+	t.Run("DiscardDropsTheDeadLetter", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+		claim(t, repo, "dispatcher-a", base)
+		require.NoError(t, repo.DeadLetter("evt-1", "dispatcher-a", "bus down", base))
+
+		require.NoError(t, repo.Discard("evt-1"))
+
+		letters, err := repo.ListDeadLetters(10)
+		require.NoError(t, err)
+		require.Empty(t, letters)
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Empty(t, events)
+	})
+
+	t.Run("UnknownDeadLetters", func(t *testing.T) {
+		repo := newRepo(t)
+
+		_, err := repo.FindDeadLetter("evt-missing")
+		require.ErrorIs(t, err, outbox.ErrDeadLetterNotFound)
+		require.ErrorIs(t, repo.Replay("evt-missing"), outbox.ErrDeadLetterNotFound)
+		require.ErrorIs(t, repo.Discard("evt-missing"), outbox.ErrDeadLetterNotFound)
+	})
+
+	t.Run("MarkPublishedLeavesTheQueue", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+		require.NoError(t, repo.Save(newEvent(2)))
+		claim(t, repo, "dispatcher-a", base)
+
+		require.NoError(t, repo.MarkPublished("evt-1", "dispatcher-a"))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-2"}, ids(events))
+	})
+
+	t.Run("MarkPoisonedLeavesTheQueue", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+		require.NoError(t, repo.Save(newEvent(2)))
+
+		require.NoError(t, repo.MarkPoisoned("evt-2", "cannot decode"))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1"}, ids(events))
+	})
+
+	t.Run("OnlyTheLeaseHolderCompletesAnEvent", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+
+		claim(t, repo, "dispatcher-a", base)
+		late := claim(t, repo, "dispatcher-b", base.Add(time.Minute))
+		require.Equal(t, []string{"evt-1"}, ids(late))
+
+		require.ErrorIs(t, repo.MarkPublished("evt-1", "dispatcher-a"), outbox.ErrLeaseLost)
+		require.ErrorIs(t, repo.MarkFailed("evt-1", "dispatcher-a", "bus down", base), outbox.ErrLeaseLost)
+		require.ErrorIs(t, repo.DeadLetter("evt-1", "dispatcher-a", "bus down", base), outbox.ErrLeaseLost)
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		require.Zero(t, events[0].Attempts)
+		require.Equal(t, "dispatcher-b", events[0].ClaimedBy)
+
+		letters, err := repo.ListDeadLetters(10)
+		require.NoError(t, err)
+		require.Empty(t, letters)
+
+		require.NoError(t, repo.MarkPublished("evt-1", "dispatcher-b"))
+		require.ErrorIs(t, repo.MarkPublished("evt-1", "dispatcher-b"), outbox.ErrLeaseLost)
+	})
+
+	t.Run("UnknownEventsAreNotLeased", func(t *testing.T) {
+		repo := newRepo(t)
+		require.NoError(t, repo.Save(newEvent(1)))
+
+		require.ErrorIs(t, repo.MarkPublished("evt-missing", "dispatcher-a"), outbox.ErrLeaseLost)
+		require.ErrorIs(t, repo.MarkFailed("evt-missing", "dispatcher-a", "bus down", base), outbox.ErrLeaseLost)
+		require.ErrorIs(t, repo.DeadLetter("evt-missing", "dispatcher-a", "bus down", base), outbox.ErrLeaseLost)
+		require.NoError(t, repo.MarkPoisoned("evt-missing", "cannot decode"))
+
+		events, err := repo.FindUnpublished(10)
+		require.NoError(t, err)
+		require.Equal(t, []string{"evt-1"}, ids(events))
+	})
+}
+
+// base is truncated so the timestamps survive any storage precision.
+var base = time.Date(2026, time.January, 2, 15, 4, 5, 0, time.UTC)
+
+// newEvent builds the n-th event; a higher n was created later.
+func newEvent(n int) outbox.OutboxEvent {
+	id := fmt.Sprintf("evt-%d", n)
+	return outbox.OutboxEvent{
+		ID:            id,
+		Type:          event.PaymentSucceeded,
+		Version:       1,
+		OccurredAt:    base.Add(time.Duration(n) * time.Second),
+		Aggregate:     event.Payment("pay-1"),
+		CorrelationID: "evt-0",
+		CausationID:   "evt-0",
+		Payload:       []byte(fmt.Sprintf(`{"invoice_id":"inv-%d"}`, n)),
+		CreatedAt:     base.Add(time.Duration(n) * time.Second),
+	}
+}
+
+func requireSameEvent(t *testing.T, want, got outbox.OutboxEvent) {
+	t.Helper()
+
+	require.True(t, want.OccurredAt.Equal(got.OccurredAt), "occurred at %v, got %v", want.OccurredAt, got.OccurredAt)
+	require.True(t, want.CreatedAt.Equal(got.CreatedAt), "created at %v, got %v", want.CreatedAt, got.CreatedAt)
+	got.OccurredAt, got.CreatedAt = want.OccurredAt, want.CreatedAt
+	require.Equal(t, want, got)
+}
+
+// claim leases owner every event it may take at now.
+func claim(t *testing.T, repo Store, owner string, now time.Time) []outbox.OutboxEvent {
+	t.Helper()
+
+	claimed, err := repo.Claim(owner, now, time.Minute, 100)
+	require.NoError(t, err)
+	return claimed
+}
+
+func ids(events []outbox.OutboxEvent) []string {
+	ids := make([]string, 0, len(events))
+	for _, evt := range events {
+		ids = append(ids, evt.ID)
+	}
+	return ids
+}
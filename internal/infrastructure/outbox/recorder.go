@@ -2,7 +2,6 @@ package outbox
 
 import (
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
@@ -12,20 +11,23 @@ type Recorder struct {
 	Repo Repository
 }
 
-func generateOutboxID() string {
-	return fmt.Sprintf("outbox_%d", time.Now().UnixNano())
-}
-
 func (r *Recorder) Record(evt event.Event) error {
 	payload, err := json.Marshal(evt.Payload)
 	if err != nil {
-		return nil
+		return err
 	}
 
+	evt = evt.WithDefaults()
+
 	return r.Repo.Save(OutboxEvent{
-		ID:        generateOutboxID(),
-		Type:      evt.Type,
-		Payload:   payload,
-		CreatedAt: time.Now(),
+		ID:            evt.ID,
+		Type:          evt.Type,
+		Version:       evt.Version,
+		OccurredAt:    evt.OccurredAt,
+		Aggregate:     evt.Aggregate,
+		CorrelationID: evt.CorrelationID,
+		CausationID:   evt.CausationID,
+		Payload:       payload,
+		CreatedAt:     time.Now(),
 	})
 }
@@ -0,0 +1,24 @@
+package outbox_test
+
+import (
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func TestRecorder_ShouldFailWhenPayloadCannotBeEncoded(t *testing.T) {
+	repo := inmemory.NewOutboxRepository()
+	recorder := &outbox.Recorder{Repo: repo}
+
+	err := recorder.Record(event.New(event.PaymentRequested, event.Invoice("inv-1"), make(chan int)))
+	if err == nil {
+		t.Fatal("expected an encoding error")
+	}
+
+	events, _ := repo.FindUnpublished(10)
+	if len(events) != 0 {
+		t.Fatalf("expected nothing recorded, got %d events", len(events))
+	}
+}
@@ -1,21 +1,78 @@
 package outbox
 
 import (
+	"errors"
 	"time"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 )
 
+// OutboxEvent is a recorded event waiting to be dispatched. ID and the
+// envelope fields are the event's own.
 type OutboxEvent struct {
-	ID        string
-	Type      event.Type
-	Payload   []byte
-	Published bool
+	ID            string
+	Type          event.Type
+	Version       int
+	OccurredAt    time.Time
+	Aggregate     event.Aggregate
+	CorrelationID string
+	CausationID   string
+	Payload       []byte
+	Published     bool
+	// Poisoned events could not be decoded and are never dispatched again;
+	// LastError tells why.
+	Poisoned  bool
+	LastError string
 	CreatedAt time.Time
+	// Attempts counts failed publishes; the event is not claimed again
+	// before NextAttemptAt.
+	Attempts      int
+	NextAttemptAt time.Time
+	// ClaimedBy is the dispatcher holding the event until LeaseExpiresAt.
+	ClaimedBy      string
+	LeaseExpiresAt time.Time
 }
 
 type Repository interface {
 	Save(OutboxEvent) error
 	FindUnpublished(int) ([]OutboxEvent, error)
-	MarkPublished(string) error
+	// Claim leases up to limit unpublished events to owner for lease,
+	// oldest first. Events leased to someone else are skipped until their
+	// lease expires, so a crashed owner's events are taken over then.
+	Claim(owner string, now time.Time, lease time.Duration, limit int) ([]OutboxEvent, error)
+	// MarkPublished, MarkFailed and DeadLetter only act on an event still
+	// claimed by owner, and fail with ErrLeaseLost otherwise: someone else
+	// may be publishing it by now.
+	MarkPublished(id string, owner string) error
+	// MarkPoisoned takes an event out of dispatch, keeping it for inspection.
+	MarkPoisoned(id string, reason string) error
+	// MarkFailed records a failed publish and releases the event's lease;
+	// it can be claimed again from nextAttemptAt.
+	MarkFailed(id string, owner string, reason string, nextAttemptAt time.Time) error
+	// DeadLetter records a final failed publish and moves the event from
+	// the outbox to the dead letters.
+	DeadLetter(id string, owner string, reason string, now time.Time) error
+}
+
+var (
+	ErrLeaseLost          = errors.New("outbox event is not leased to this owner")
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+)
+
+// DeadLetter is an event the dispatcher gave up publishing.
+type DeadLetter struct {
+	OutboxEvent
+	DeadAt time.Time
+}
+
+// DeadLetters lets operators inspect dead letters and either put them back
+// in the outbox or drop them.
+type DeadLetters interface {
+	// ListDeadLetters returns up to limit dead letters, oldest first.
+	ListDeadLetters(limit int) ([]DeadLetter, error)
+	FindDeadLetter(id string) (DeadLetter, error)
+	// Replay moves a dead letter back to the outbox with no failed
+	// attempts, to be dispatched as soon as a dispatcher claims it.
+	Replay(id string) error
+	Discard(id string) error
 }
@@ -0,0 +1,75 @@
+package inmemory
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/idempotency"
+)
+
+type IdempotencyRepository struct {
+	mu      sync.Mutex
+	records map[string]*idempotency.Record
+}
+
+func NewIdempotencyRepository() *IdempotencyRepository {
+	return &IdempotencyRepository{
+		mu:      sync.Mutex{},
+		records: make(map[string]*idempotency.Record),
+	}
+}
+
+func (r *IdempotencyRepository) Reserve(rec *idempotency.Record) (*idempotency.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.records[rec.Key]; ok && existing.ExpiresAt.After(rec.CreatedAt) {
+		return cloneRecord(existing), nil
+	}
+
+	r.records[rec.Key] = cloneRecord(rec)
+	return nil, nil
+}
+
+func (r *IdempotencyRepository) Complete(key string, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.records[key]
+	if !ok {
+		return idempotency.ErrKeyNotFound
+	}
+
+	rec.StatusCode = statusCode
+	rec.ContentType = contentType
+	rec.Body = slices.Clone(body)
+	rec.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.records, key)
+	return nil
+}
+
+func (r *IdempotencyRepository) DeleteExpired(now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, rec := range r.records {
+		if !rec.ExpiresAt.After(now) {
+			delete(r.records, key)
+		}
+	}
+	return nil
+}
+
+func cloneRecord(rec *idempotency.Record) *idempotency.Record {
+	cloned := *rec
+	cloned.Body = slices.Clone(rec.Body)
+	return &cloned
+}
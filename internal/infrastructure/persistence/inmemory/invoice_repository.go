@@ -1,17 +1,20 @@
 package inmemory
 
 import (
-	"errors"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
 )
 
-var ErrInvoiceNotFound = errors.New("invoice not found")
+var ErrInvoiceNotFound = invoice.ErrNotFound
 
 type InvoiceRepository struct {
 	mu       sync.RWMutex
 	invoices map[string]*invoice.Invoice
+	journal  *journal
 }
 
 func NewInvoiceRepository() *InvoiceRepository {
@@ -25,7 +28,12 @@ func (r *InvoiceRepository) Save(inv *invoice.Invoice) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.invoices[inv.ID] = inv
+	if _, ok := r.invoices[inv.ID]; ok {
+		return invoice.ErrAlreadyExists
+	}
+
+	remember(r.journal, r.invoices, inv.ID)
+	r.invoices[inv.ID] = cloneInvoice(inv)
 	return nil
 }
 
@@ -38,10 +46,14 @@ func (r *InvoiceRepository) FindByID(id string) (*invoice.Invoice, error) {
 		return nil, ErrInvoiceNotFound
 	}
 
-	return inv, nil
+	return cloneInvoice(inv), nil
 }
 
-func (r *InvoiceRepository) UpdateStatus(id string, status invoice.Status) error {
+func (r *InvoiceRepository) UpdateStatus(id string, from, to invoice.Status) error {
+	if !invoice.CanTransition(from, to) {
+		return &invoice.TransitionError{From: from, To: to}
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -50,6 +62,109 @@ func (r *InvoiceRepository) UpdateStatus(id string, status invoice.Status) error
 		return ErrInvoiceNotFound
 	}
 
-	inv.Status = status
+	if inv.Status != from {
+		return invoice.ErrConcurrentUpdate
+	}
+
+	updated := cloneInvoice(inv)
+	updated.Status = to
+	updated.Version++
+
+	remember(r.journal, r.invoices, id)
+	r.invoices[id] = updated
+	return nil
+}
+
+func (r *InvoiceRepository) Update(inv *invoice.Invoice) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.invoices[inv.ID]
+	if !ok {
+		return ErrInvoiceNotFound
+	}
+
+	if stored.Version != inv.Version {
+		return invoice.ErrConcurrentUpdate
+	}
+
+	inv.Version++
+	remember(r.journal, r.invoices, inv.ID)
+	r.invoices[inv.ID] = cloneInvoice(inv)
 	return nil
 }
+
+func (r *InvoiceRepository) FindOverdue(now time.Time, limit int) ([]*invoice.Invoice, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var overdue []*invoice.Invoice
+	for _, inv := range r.invoices {
+		if inv.IsOverdue(now) {
+			overdue = append(overdue, cloneInvoice(inv))
+		}
+	}
+
+	slices.SortFunc(overdue, func(a, b *invoice.Invoice) int {
+		if c := a.DueAt.Compare(b.DueAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if len(overdue) > limit {
+		overdue = overdue[:limit]
+	}
+
+	return overdue, nil
+}
+
+func (r *InvoiceRepository) List(q invoice.ListQuery) ([]*invoice.Invoice, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var invoices []*invoice.Invoice
+	for _, inv := range r.invoices {
+		if matches(inv, q) {
+			invoices = append(invoices, cloneInvoice(inv))
+		}
+	}
+
+	slices.SortFunc(invoices, func(a, b *invoice.Invoice) int {
+		return compareCursors(invoice.CursorOf(a), invoice.CursorOf(b))
+	})
+
+	if len(invoices) > q.Limit {
+		invoices = invoices[:q.Limit]
+	}
+
+	return invoices, nil
+}
+
+func matches(inv *invoice.Invoice, q invoice.ListQuery) bool {
+	switch {
+	case q.Status != "" && inv.Status != q.Status:
+		return false
+	case !q.IssuedFrom.IsZero() && inv.IssuedAt.Before(q.IssuedFrom):
+		return false
+	case !q.IssuedTo.IsZero() && !inv.IssuedAt.Before(q.IssuedTo):
+		return false
+	case q.After != nil && compareCursors(invoice.CursorOf(inv), q.After) <= 0:
+		return false
+	}
+	return true
+}
+
+func compareCursors(a, b *invoice.Cursor) int {
+	if c := a.IssuedAt.Compare(b.IssuedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+func cloneInvoice(inv *invoice.Invoice) *invoice.Invoice {
+	cloned := *inv
+	cloned.Lines = slices.Clone(inv.Lines)
+	cloned.AppliedPayments = slices.Clone(inv.AppliedPayments)
+	return &cloned
+}
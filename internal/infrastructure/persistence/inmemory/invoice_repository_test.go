@@ -0,0 +1,62 @@
+package inmemory_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func TestInvoiceRepository_UpdateStatus_ShouldAllowOnlyOneConcurrentTransition(t *testing.T) {
+	repo := inmemory.NewInvoiceRepository()
+	if err := repo.Save(&invoice.Invoice{ID: "inv-1", Amount: money.Money{Amount: 100, Currency: money.BRL}, Status: invoice.StatusProcessing}); err != nil {
+		t.Fatal(err)
+	}
+
+	targets := []invoice.Status{invoice.StatusPaid, invoice.StatusFailed}
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, to := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.UpdateStatus("inv-1", invoice.StatusProcessing, to)
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, invoice.ErrConcurrentUpdate):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Fatalf("expected exactly 1 transition to succeed, got %d", succeeded)
+	}
+}
+
+func TestInvoiceRepository_UpdateStatus_ShouldRejectInvalidTransition(t *testing.T) {
+	repo := inmemory.NewInvoiceRepository()
+	if err := repo.Save(&invoice.Invoice{ID: "inv-1", Amount: money.Money{Amount: 100, Currency: money.BRL}, Status: invoice.StatusPaid}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := repo.UpdateStatus("inv-1", invoice.StatusPaid, invoice.StatusFailed)
+	if !errors.Is(err, invoice.ErrInvalidTransition) {
+		t.Fatalf("expected ErrInvalidTransition, got %v", err)
+	}
+
+	inv, _ := repo.FindByID("inv-1")
+	if inv.Status != invoice.StatusPaid {
+		t.Fatalf("expected status PAID, got %s", inv.Status)
+	}
+}
@@ -0,0 +1,242 @@
+package inmemory
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+)
+
+type OutboxRepository struct {
+	mu          sync.Mutex
+	events      []*outbox.OutboxEvent
+	deadLetters []*outbox.DeadLetter
+}
+
+func NewOutboxRepository() *OutboxRepository {
+	return &OutboxRepository{
+		mu: sync.Mutex{},
+	}
+}
+
+func (r *OutboxRepository) Save(evt outbox.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, saved := range r.events {
+		if saved.ID == evt.ID {
+			return fmt.Errorf("outbox event %s already saved", evt.ID)
+		}
+	}
+
+	evt.Payload = slices.Clone(evt.Payload)
+	r.events = append(r.events, &evt)
+	return nil
+}
+
+func (r *OutboxRepository) FindUnpublished(limit int) ([]outbox.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []outbox.OutboxEvent
+	for _, evt := range r.events {
+		if !evt.Published && !evt.Poisoned {
+			found := *evt
+			found.Payload = slices.Clone(evt.Payload)
+			events = append(events, found)
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b outbox.OutboxEvent) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return events[:min(limit, len(events))], nil
+}
+
+func (r *OutboxRepository) Claim(owner string, now time.Time, lease time.Duration, limit int) ([]outbox.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var claimable []*outbox.OutboxEvent
+	for _, evt := range r.events {
+		if evt.Published || evt.Poisoned {
+			continue
+		}
+		if evt.NextAttemptAt.After(now) {
+			continue
+		}
+		if evt.ClaimedBy == owner || evt.LeaseExpiresAt.IsZero() || !evt.LeaseExpiresAt.After(now) {
+			claimable = append(claimable, evt)
+		}
+	}
+
+	slices.SortStableFunc(claimable, func(a, b *outbox.OutboxEvent) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	claimed := make([]outbox.OutboxEvent, 0, min(limit, len(claimable)))
+	for _, evt := range claimable[:min(limit, len(claimable))] {
+		evt.ClaimedBy = owner
+		evt.LeaseExpiresAt = now.Add(lease)
+
+		found := *evt
+		found.Payload = slices.Clone(evt.Payload)
+		claimed = append(claimed, found)
+	}
+
+	return claimed, nil
+}
+
+func (r *OutboxRepository) MarkPublished(id string, owner string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evt, err := r.leased(id, owner)
+	if err != nil {
+		return err
+	}
+
+	evt.Published = true
+	return nil
+}
+
+func (r *OutboxRepository) MarkPoisoned(id string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, evt := range r.events {
+		if evt.ID == id {
+			evt.Poisoned = true
+			evt.LastError = reason
+		}
+	}
+	return nil
+}
+
+func (r *OutboxRepository) MarkFailed(id string, owner string, reason string, nextAttemptAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	evt, err := r.leased(id, owner)
+	if err != nil {
+		return err
+	}
+
+	evt.Attempts++
+	evt.LastError = reason
+	evt.NextAttemptAt = nextAttemptAt
+	evt.ClaimedBy = ""
+	evt.LeaseExpiresAt = time.Time{}
+	return nil
+}
+
+func (r *OutboxRepository) DeadLetter(id string, owner string, reason string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.leased(id, owner); err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(r.events, func(evt *outbox.OutboxEvent) bool { return evt.ID == id })
+	evt := *r.events[i]
+	r.events = slices.Delete(r.events, i, i+1)
+
+	r.deadLetters = append(r.deadLetters, &outbox.DeadLetter{
+		OutboxEvent: outbox.OutboxEvent{
+			ID:            evt.ID,
+			Type:          evt.Type,
+			Version:       evt.Version,
+			OccurredAt:    evt.OccurredAt,
+			Aggregate:     evt.Aggregate,
+			CorrelationID: evt.CorrelationID,
+			CausationID:   evt.CausationID,
+			Payload:       evt.Payload,
+			CreatedAt:     evt.CreatedAt,
+			Attempts:      evt.Attempts + 1,
+			LastError:     reason,
+		},
+		DeadAt: now,
+	})
+	return nil
+}
+
+// leased finds an unpublished event claimed by owner. The caller holds mu.
+func (r *OutboxRepository) leased(id string, owner string) (*outbox.OutboxEvent, error) {
+	for _, evt := range r.events {
+		if evt.ID == id && evt.ClaimedBy == owner && !evt.Published {
+			return evt, nil
+		}
+	}
+	return nil, outbox.ErrLeaseLost
+}
+
+func (r *OutboxRepository) ListDeadLetters(limit int) ([]outbox.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	letters := make([]outbox.DeadLetter, 0, min(limit, len(r.deadLetters)))
+	for _, letter := range r.deadLetters[:min(limit, len(r.deadLetters))] {
+		found := *letter
+		found.Payload = slices.Clone(letter.Payload)
+		letters = append(letters, found)
+	}
+
+	return letters, nil
+}
+
+func (r *OutboxRepository) FindDeadLetter(id string) (outbox.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, letter := range r.deadLetters {
+		if letter.ID == id {
+			found := *letter
+			found.Payload = slices.Clone(letter.Payload)
+			return found, nil
+		}
+	}
+	return outbox.DeadLetter{}, outbox.ErrDeadLetterNotFound
+}
+
+func (r *OutboxRepository) Replay(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.deadLetters, func(letter *outbox.DeadLetter) bool { return letter.ID == id })
+	if i < 0 {
+		return outbox.ErrDeadLetterNotFound
+	}
+
+	letter := r.deadLetters[i]
+	r.deadLetters = slices.Delete(r.deadLetters, i, i+1)
+
+	r.events = append(r.events, &outbox.OutboxEvent{
+		ID:            letter.ID,
+		Type:          letter.Type,
+		Version:       letter.Version,
+		OccurredAt:    letter.OccurredAt,
+		Aggregate:     letter.Aggregate,
+		CorrelationID: letter.CorrelationID,
+		CausationID:   letter.CausationID,
+		Payload:       letter.Payload,
+		CreatedAt:     letter.CreatedAt,
+	})
+	return nil
+}
+
+func (r *OutboxRepository) Discard(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.deadLetters, func(letter *outbox.DeadLetter) bool { return letter.ID == id })
+	if i < 0 {
+		return outbox.ErrDeadLetterNotFound
+	}
+
+	r.deadLetters = slices.Delete(r.deadLetters, i, i+1)
+	return nil
+}
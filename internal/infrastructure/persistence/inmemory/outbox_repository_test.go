@@ -0,0 +1,14 @@
+package inmemory_test
+
+import (
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox/outboxtest"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func TestOutboxRepository(t *testing.T) {
+	outboxtest.Run(t, func(t *testing.T) outboxtest.Store {
+		return inmemory.NewOutboxRepository()
+	})
+}
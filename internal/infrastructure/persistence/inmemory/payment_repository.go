@@ -3,17 +3,24 @@ package inmemory
 import (
 	"errors"
 	"maps"
+	"slices"
 	"sync"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
 )
 
-var ErrPaymentNotFound = errors.New("payment not found")
+var (
+	ErrPaymentNotFound = payment.ErrNotFound
+	ErrAttemptNotFound = errors.New("payment attempt not found")
+)
 
 type PaymentRepository struct {
 	mu              sync.RWMutex
 	payments        map[string]*payment.Payment
 	idempotencyKeys map[string]string
+	attempts        map[string][]*payment.Attempt
+	order           []string
+	journal         *journal
 }
 
 func NewPaymentRepository() *PaymentRepository {
@@ -21,6 +28,7 @@ func NewPaymentRepository() *PaymentRepository {
 		mu:              sync.RWMutex{},
 		payments:        make(map[string]*payment.Payment),
 		idempotencyKeys: make(map[string]string),
+		attempts:        make(map[string][]*payment.Attempt),
 	}
 }
 
@@ -28,8 +36,11 @@ func (r *PaymentRepository) Save(p *payment.Payment) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.payments[p.ID] = p
-	r.idempotencyKeys[p.IdempotencyKey] = p.ID
+	if _, exists := r.payments[p.ID]; !exists {
+		r.order = append(r.order, p.ID)
+	}
+
+	r.put(p)
 	return nil
 }
 
@@ -41,12 +52,40 @@ func (r *PaymentRepository) SaveIfNotExist(p *payment.Payment) (bool, error) {
 		return false, nil
 	}
 
-	r.payments[p.ID] = p
-	r.idempotencyKeys[p.IdempotencyKey] = p.ID
+	r.order = append(r.order, p.ID)
+	r.put(p)
 
 	return true, nil
 }
 
+func (r *PaymentRepository) FindByID(id string) (*payment.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.payments[id]
+	if !ok {
+		return nil, ErrPaymentNotFound
+	}
+
+	found := *p
+	return &found, nil
+}
+
+func (r *PaymentRepository) FindByInvoiceID(invoiceID string) ([]*payment.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var payments []*payment.Payment
+	for _, id := range r.order {
+		if p := r.payments[id]; p.InvoiceID == invoiceID {
+			found := *p
+			payments = append(payments, &found)
+		}
+	}
+
+	return payments, nil
+}
+
 func (r *PaymentRepository) FindByIdempotencyKey(key string) (*payment.Payment, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -60,7 +99,8 @@ func (r *PaymentRepository) FindByIdempotencyKey(key string) (*payment.Payment,
 		return nil, ErrPaymentNotFound
 	}
 
-	return p, nil
+	found := *p
+	return &found, nil
 }
 
 func (r *PaymentRepository) UpdateStatus(id string, paymentStatus payment.Status) error {
@@ -72,10 +112,83 @@ func (r *PaymentRepository) UpdateStatus(id string, paymentStatus payment.Status
 		return ErrPaymentNotFound
 	}
 
-	p.Status = paymentStatus
+	updated := *p
+	updated.Status = paymentStatus
+	r.put(&updated)
 	return nil
 }
 
+func (r *PaymentRepository) SaveAttemptIfNotExist(a *payment.Attempt) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.attempts[a.PaymentID] {
+		if existing.Number == a.Number {
+			return false, nil
+		}
+	}
+
+	stored := *a
+	attempts := append(slices.Clone(r.attempts[a.PaymentID]), &stored)
+	slices.SortFunc(attempts, func(x, y *payment.Attempt) int {
+		return x.Number - y.Number
+	})
+	remember(r.journal, r.attempts, a.PaymentID)
+	r.attempts[a.PaymentID] = attempts
+
+	if p, ok := r.payments[a.PaymentID]; ok && p.Attempt < a.Number {
+		updated := *p
+		updated.Attempt = a.Number
+		r.put(&updated)
+	}
+
+	return true, nil
+}
+
+func (r *PaymentRepository) UpdateAttempt(a *payment.Attempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, existing := range r.attempts[a.PaymentID] {
+		if existing.Number == a.Number {
+			updated := *existing
+			updated.Status = a.Status
+			updated.DeclineCode = a.DeclineCode
+			updated.GatewayReference = a.GatewayReference
+
+			attempts := slices.Clone(r.attempts[a.PaymentID])
+			attempts[i] = &updated
+			remember(r.journal, r.attempts, a.PaymentID)
+			r.attempts[a.PaymentID] = attempts
+			return nil
+		}
+	}
+
+	return ErrAttemptNotFound
+}
+
+func (r *PaymentRepository) FindAttempts(paymentID string) ([]*payment.Attempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attempts := make([]*payment.Attempt, 0, len(r.attempts[paymentID]))
+	for _, a := range r.attempts[paymentID] {
+		found := *a
+		attempts = append(attempts, &found)
+	}
+
+	return attempts, nil
+}
+
+// put stores a copy of p. The caller holds mu.
+func (r *PaymentRepository) put(p *payment.Payment) {
+	stored := *p
+	remember(r.journal, r.payments, p.ID)
+	r.payments[p.ID] = &stored
+	remember(r.journal, r.idempotencyKeys, p.IdempotencyKey)
+	r.idempotencyKeys[p.IdempotencyKey] = p.ID
+}
+
 func (r *PaymentRepository) Payments() map[string]*payment.Payment {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -0,0 +1,98 @@
+package inmemory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+var ErrRefundNotFound = errors.New("refund not found")
+
+type RefundRepository struct {
+	mu      sync.RWMutex
+	refunds map[string]*refund.Refund
+	order   []string
+	journal *journal
+}
+
+func NewRefundRepository() *RefundRepository {
+	return &RefundRepository{
+		mu:      sync.RWMutex{},
+		refunds: make(map[string]*refund.Refund),
+	}
+}
+
+func (r *RefundRepository) Save(ref *refund.Refund) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.refunds[ref.ID]; !exists {
+		r.order = append(r.order, ref.ID)
+	}
+
+	stored := *ref
+	remember(r.journal, r.refunds, ref.ID)
+	r.refunds[ref.ID] = &stored
+	return nil
+}
+
+func (r *RefundRepository) FindByID(id string) (*refund.Refund, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ref, ok := r.refunds[id]
+	if !ok {
+		return nil, ErrRefundNotFound
+	}
+
+	found := *ref
+	return &found, nil
+}
+
+func (r *RefundRepository) FindByPaymentID(paymentID string) ([]*refund.Refund, error) {
+	return r.filter(func(ref *refund.Refund) bool {
+		return ref.PaymentID == paymentID
+	}), nil
+}
+
+func (r *RefundRepository) FindByInvoiceID(invoiceID string) ([]*refund.Refund, error) {
+	return r.filter(func(ref *refund.Refund) bool {
+		return ref.InvoiceID == invoiceID
+	}), nil
+}
+
+func (r *RefundRepository) UpdateStatus(id string, from, to refund.Status) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ref, ok := r.refunds[id]
+	if !ok {
+		return ErrRefundNotFound
+	}
+
+	if ref.Status != from {
+		return refund.ErrConcurrentUpdate
+	}
+
+	updated := *ref
+	updated.Status = to
+	remember(r.journal, r.refunds, id)
+	r.refunds[id] = &updated
+	return nil
+}
+
+func (r *RefundRepository) filter(match func(*refund.Refund) bool) []*refund.Refund {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var refunds []*refund.Refund
+	for _, id := range r.order {
+		if ref := r.refunds[id]; match(ref) {
+			found := *ref
+			refunds = append(refunds, &found)
+		}
+	}
+
+	return refunds
+}
@@ -0,0 +1,89 @@
+package inmemory
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+type ScheduledPaymentRepository struct {
+	mu        sync.RWMutex
+	scheduled map[string]*payment.ScheduledPayment
+	journal   *journal
+}
+
+func NewScheduledPaymentRepository() *ScheduledPaymentRepository {
+	return &ScheduledPaymentRepository{
+		mu:        sync.RWMutex{},
+		scheduled: make(map[string]*payment.ScheduledPayment),
+	}
+}
+
+func (r *ScheduledPaymentRepository) Save(s *payment.ScheduledPayment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := *s
+	remember(r.journal, r.scheduled, s.ID)
+	r.scheduled[s.ID] = &stored
+	return nil
+}
+
+func (r *ScheduledPaymentRepository) FindDue(now time.Time, limit int) ([]*payment.ScheduledPayment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var due []*payment.ScheduledPayment
+	for _, s := range r.scheduled {
+		if !s.RunAt.After(now) {
+			found := *s
+			due = append(due, &found)
+		}
+	}
+
+	slices.SortFunc(due, func(a, b *payment.ScheduledPayment) int {
+		if c := a.RunAt.Compare(b.RunAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (r *ScheduledPaymentRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remember(r.journal, r.scheduled, id)
+	delete(r.scheduled, id)
+	return nil
+}
+
+func (r *ScheduledPaymentRepository) DeleteByInvoiceID(invoiceID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.scheduled {
+		if s.InvoiceID == invoiceID {
+			remember(r.journal, r.scheduled, id)
+			delete(r.scheduled, id)
+		}
+	}
+	return nil
+}
+
+// Scheduled returns how many payments are waiting to be requested.
+func (r *ScheduledPaymentRepository) Scheduled() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.scheduled)
+}
@@ -0,0 +1,122 @@
+package inmemory
+
+import (
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+)
+
+// UnitOfWork mirrors the SQLite unit of work. A unit locks every repository
+// for as long as it runs and writes to them in place, journaling how to undo
+// each change; the journal is replayed backwards if the unit fails. Nobody
+// else reads or writes the repositories meanwhile, so a unit never exposes
+// changes it may roll back, nor rolls back changes made outside it.
+//
+// fn must only use the repositories it is given; the ones the unit was built
+// from stay locked until it returns.
+type UnitOfWork struct {
+	invoices  *InvoiceRepository
+	payments  *PaymentRepository
+	refunds   *RefundRepository
+	scheduled *ScheduledPaymentRepository
+	outbox    *OutboxRepository
+}
+
+func NewUnitOfWork(
+	invoices *InvoiceRepository,
+	payments *PaymentRepository,
+	refunds *RefundRepository,
+	scheduled *ScheduledPaymentRepository,
+	outbox *OutboxRepository,
+) *UnitOfWork {
+	return &UnitOfWork{
+		invoices:  invoices,
+		payments:  payments,
+		refunds:   refunds,
+		scheduled: scheduled,
+		outbox:    outbox,
+	}
+}
+
+func (u *UnitOfWork) Do(fn func(contracts.Repositories) error) error {
+	// always locked in this order, so units can't deadlock each other
+	u.invoices.mu.Lock()
+	defer u.invoices.mu.Unlock()
+	u.payments.mu.Lock()
+	defer u.payments.mu.Unlock()
+	u.refunds.mu.Lock()
+	defer u.refunds.mu.Unlock()
+	u.scheduled.mu.Lock()
+	defer u.scheduled.mu.Unlock()
+	u.outbox.mu.Lock()
+	defer u.outbox.mu.Unlock()
+
+	j := &journal{}
+	committed := false
+	defer func() {
+		if !committed {
+			j.rollback()
+		}
+	}()
+
+	invoices := &InvoiceRepository{invoices: u.invoices.invoices, journal: j}
+	payments := &PaymentRepository{
+		payments:        u.payments.payments,
+		idempotencyKeys: u.payments.idempotencyKeys,
+		attempts:        u.payments.attempts,
+		order:           u.payments.order,
+		journal:         j,
+	}
+	refunds := &RefundRepository{refunds: u.refunds.refunds, order: u.refunds.order, journal: j}
+	scheduled := &ScheduledPaymentRepository{scheduled: u.scheduled.scheduled, journal: j}
+	// the unit only appends events, which the outbox doesn't see until its
+	// slice is replaced below
+	events := &OutboxRepository{events: u.outbox.events, deadLetters: u.outbox.deadLetters}
+
+	if err := fn(contracts.Repositories{
+		Invoices:  invoices,
+		Payments:  payments,
+		Refunds:   refunds,
+		Scheduled: scheduled,
+		Events:    &outbox.Recorder{Repo: events},
+	}); err != nil {
+		return err
+	}
+	committed = true
+
+	u.payments.order = payments.order
+	u.refunds.order = refunds.order
+	u.outbox.events = events.events
+	u.outbox.deadLetters = events.deadLetters
+
+	return nil
+}
+
+// journal holds what a unit of work must do to undo its changes to the maps
+// it shares with the repositories.
+type journal struct {
+	undo []func()
+}
+
+func (j *journal) rollback() {
+	for i := len(j.undo) - 1; i >= 0; i-- {
+		j.undo[i]()
+	}
+	j.undo = nil
+}
+
+// remember journals m[key] before it is overwritten or deleted. Outside a
+// unit of work j is nil and nothing is journaled.
+func remember[K comparable, V any](j *journal, m map[K]V, key K) {
+	if j == nil {
+		return
+	}
+
+	old, ok := m[key]
+	j.undo = append(j.undo, func() {
+		if ok {
+			m[key] = old
+		} else {
+			delete(m, key)
+		}
+	})
+}
@@ -0,0 +1,240 @@
+package inmemory_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/inmemory"
+)
+
+func setupUnitOfWork(t *testing.T) (*inmemory.UnitOfWork, *inmemory.InvoiceRepository, *inmemory.PaymentRepository, *inmemory.OutboxRepository) {
+	t.Helper()
+
+	invoices := inmemory.NewInvoiceRepository()
+	payments := inmemory.NewPaymentRepository()
+	outbox := inmemory.NewOutboxRepository()
+
+	require.NoError(t, invoices.Save(&invoice.Invoice{
+		ID:     "inv-1",
+		Amount: money.Money{Amount: 100, Currency: money.BRL},
+		Status: invoice.StatusPending,
+	}))
+	require.NoError(t, payments.Save(&payment.Payment{
+		ID:             "pay-1",
+		InvoiceID:      "inv-1",
+		Status:         payment.StatusCreated,
+		IdempotencyKey: "payment:inv-1",
+	}))
+
+	return inmemory.NewUnitOfWork(invoices, payments, inmemory.NewRefundRepository(), inmemory.NewScheduledPaymentRepository(), outbox), invoices, payments, outbox
+}
+
+func changeEverything(tx contracts.Repositories) error {
+	if err := tx.Invoices.UpdateStatus("inv-1", invoice.StatusPending, invoice.StatusProcessing); err != nil {
+		return err
+	}
+	if err := tx.Payments.UpdateStatus("pay-1", payment.StatusProcessing); err != nil {
+		return err
+	}
+	return tx.Events.Record(event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{InvoiceID: "inv-1"}))
+}
+
+func TestUnitOfWork_ShouldCommitEveryChange(t *testing.T) {
+	uow, invoices, payments, outbox := setupUnitOfWork(t)
+
+	require.NoError(t, uow.Do(changeEverything))
+
+	inv, err := invoices.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusProcessing, inv.Status)
+
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusProcessing, pay.Status)
+
+	events, err := outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+}
+
+func TestUnitOfWork_ShouldRollBackEveryChangeOnError(t *testing.T) {
+	uow, invoices, payments, outbox := setupUnitOfWork(t)
+	errBoom := errors.New("boom")
+
+	err := uow.Do(func(tx contracts.Repositories) error {
+		if err := changeEverything(tx); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	require.ErrorIs(t, err, errBoom)
+
+	inv, err := invoices.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusPending, inv.Status)
+
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusCreated, pay.Status)
+
+	events, err := outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestUnitOfWork_ShouldHideChangesUntilCommitted(t *testing.T) {
+	uow, invoices, _, _ := setupUnitOfWork(t)
+
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- uow.Do(func(tx contracts.Repositories) error {
+			if err := tx.Invoices.UpdateStatus("inv-1", invoice.StatusPending, invoice.StatusProcessing); err != nil {
+				return err
+			}
+			close(inside)
+			<-release
+			return nil
+		})
+	}()
+	<-inside
+
+	read := make(chan invoice.Status)
+	go func() {
+		inv, err := invoices.FindByID("inv-1")
+		if err != nil {
+			t.Error(err)
+		}
+		read <- inv.Status
+	}()
+
+	select {
+	case status := <-read:
+		t.Fatalf("read %s while the unit was running", status)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, invoice.StatusProcessing, <-read)
+}
+
+func TestUnitOfWork_ShouldKeepWritesMadeOutsideAFailedUnit(t *testing.T) {
+	uow, invoices, payments, _ := setupUnitOfWork(t)
+	errBoom := errors.New("boom")
+
+	inside := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- uow.Do(func(tx contracts.Repositories) error {
+			if err := changeEverything(tx); err != nil {
+				return err
+			}
+			close(inside)
+			<-release
+			return errBoom
+		})
+	}()
+	<-inside
+
+	written := make(chan error)
+	go func() {
+		written <- payments.UpdateStatus("pay-1", payment.StatusFailed)
+	}()
+
+	close(release)
+	require.ErrorIs(t, <-done, errBoom)
+	require.NoError(t, <-written)
+
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusFailed, pay.Status)
+
+	inv, err := invoices.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusPending, inv.Status)
+}
+
+func TestUnitOfWork_ShouldRollBackInsertsAndDeletes(t *testing.T) {
+	invoices := inmemory.NewInvoiceRepository()
+	payments := inmemory.NewPaymentRepository()
+	refunds := inmemory.NewRefundRepository()
+	scheduled := inmemory.NewScheduledPaymentRepository()
+	uow := inmemory.NewUnitOfWork(invoices, payments, refunds, scheduled, inmemory.NewOutboxRepository())
+	errBoom := errors.New("boom")
+
+	require.NoError(t, payments.Save(&payment.Payment{ID: "pay-1", InvoiceID: "inv-1", IdempotencyKey: "payment:inv-1"}))
+	_, err := payments.SaveAttemptIfNotExist(&payment.Attempt{PaymentID: "pay-1", Number: 1, Status: payment.StatusFailed})
+	require.NoError(t, err)
+	require.NoError(t, scheduled.Save(&payment.ScheduledPayment{ID: "sch-1", InvoiceID: "inv-1"}))
+
+	err = uow.Do(func(tx contracts.Repositories) error {
+		if err := tx.Invoices.Save(&invoice.Invoice{ID: "inv-2"}); err != nil {
+			return err
+		}
+		if err := tx.Payments.Save(&payment.Payment{ID: "pay-2", InvoiceID: "inv-1", IdempotencyKey: "payment:inv-1:2"}); err != nil {
+			return err
+		}
+		if _, err := tx.Payments.SaveAttemptIfNotExist(&payment.Attempt{PaymentID: "pay-1", Number: 2}); err != nil {
+			return err
+		}
+		if err := tx.Refunds.Save(&refund.Refund{ID: "ref-1", PaymentID: "pay-1"}); err != nil {
+			return err
+		}
+		if err := tx.Scheduled.DeleteByInvoiceID("inv-1"); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	require.ErrorIs(t, err, errBoom)
+
+	_, err = invoices.FindByID("inv-2")
+	require.ErrorIs(t, err, invoice.ErrNotFound)
+
+	listed, err := payments.FindByInvoiceID("inv-1")
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, 1, listed[0].Attempt)
+	_, err = payments.FindByIdempotencyKey("payment:inv-1:2")
+	require.ErrorIs(t, err, payment.ErrNotFound)
+
+	attempts, err := payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+
+	_, err = refunds.FindByID("ref-1")
+	require.ErrorIs(t, err, inmemory.ErrRefundNotFound)
+	require.Equal(t, 1, scheduled.Scheduled())
+}
+
+func TestUnitOfWork_ShouldRollBackWhenFnPanics(t *testing.T) {
+	uow, invoices, _, outbox := setupUnitOfWork(t)
+
+	require.Panics(t, func() {
+		uow.Do(func(tx contracts.Repositories) error {
+			if err := changeEverything(tx); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	})
+
+	inv, err := invoices.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusPending, inv.Status)
+
+	events, err := outbox.FindUnpublished(10)
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
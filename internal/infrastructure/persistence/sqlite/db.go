@@ -2,31 +2,69 @@ package sqlite
 
 import (
 	"database/sql"
+	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// Every connection runs in WAL mode, so readers don't block the writer, and
+// waits up to busyTimeout for a lock instead of failing with SQLITE_BUSY.
+// Transactions take the write lock as they begin: a deferred transaction
+// that reads and then writes can't wait its way out of a conflicting writer.
+const (
+	busyTimeout  = 5 * time.Second
+	maxOpenConns = 8
+)
+
 func Open(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	dsn := fmt.Sprintf(
+		"%s?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d&_txlock=immediate",
+		path,
+		busyTimeout.Milliseconds(),
+	)
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// pragmas := []string{
-	// 	"PRAGMA journal_mode = WAL;",
-	// 	"PRAGMA foreign_keys = ON;",
-	// 	"PRAGMA synchronous = NORMAL;",
-	// }
-
-	// for _, p := range pragmas {
-	// 	if _, err := db.Exec(p); err != nil {
-	// 		return nil, err
-	// 	}
-	// }
+	// SQLite has a single writer; more connections only queue up on it
+	db.SetMaxOpenConns(maxOpenConns)
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so repositories can run
+// standalone or inside a unit of work.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// withTx runs fn in a new transaction, or in the current one if db already
+// is a transaction.
+func withTx(db dbtx, fn func(dbtx) error) error {
+	sqlDB, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,72 @@
+package sqlite_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func TestOpen_ShouldConfigureEveryConnection(t *testing.T) {
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	for range 2 {
+		// hold the first connection so the second check gets a new one
+		conn, err := db.Conn(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		var mode string
+		var timeout int
+		require.NoError(t, conn.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&mode))
+		require.NoError(t, conn.QueryRowContext(ctx, `PRAGMA busy_timeout`).Scan(&timeout))
+		require.Equal(t, "wal", mode)
+		require.Equal(t, 5000, timeout)
+	}
+}
+
+func TestOpen_ConcurrentWritersShouldWaitForTheLock(t *testing.T) {
+	db := setupDB(t)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer tx.Rollback()
+
+			key := fmt.Sprintf("key-%d", i)
+			var n int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM idempotency_keys WHERE key = ?`, key).Scan(&n); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO idempotency_keys (key, request_hash, status_code, content_type, created_at, expires_at) VALUES (?, '', 200, '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`,
+				key,
+			); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys`).Scan(&n))
+	require.Equal(t, 20, n)
+}
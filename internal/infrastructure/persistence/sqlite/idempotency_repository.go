@@ -0,0 +1,128 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/idempotency"
+)
+
+type IdempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// Reserve inserts the record, taking over the key only if the record holding
+// it has expired. A key released between the insert and the lookup is
+// reserved again.
+func (r *IdempotencyRepository) Reserve(rec *idempotency.Record) (*idempotency.Record, error) {
+	for {
+		existing, err := r.reserve(rec)
+		if errors.Is(err, idempotency.ErrKeyNotFound) {
+			continue
+		}
+		return existing, err
+	}
+}
+
+func (r *IdempotencyRepository) reserve(rec *idempotency.Record) (*idempotency.Record, error) {
+	res, err := r.db.Exec(
+		`INSERT INTO idempotency_keys
+		 (key, request_hash, status_code, content_type, body, created_at, expires_at)
+		 VALUES (?, ?, 0, '', NULL, ?, ?)
+		 ON CONFLICT (key) DO UPDATE SET
+		     request_hash = excluded.request_hash,
+		     status_code = 0,
+		     content_type = '',
+		     body = NULL,
+		     created_at = excluded.created_at,
+		     expires_at = excluded.expires_at
+		 WHERE idempotency_keys.expires_at <= excluded.created_at`,
+		rec.Key,
+		rec.RequestHash,
+		rec.CreatedAt.UTC(),
+		rec.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 1 {
+		return nil, nil
+	}
+
+	return r.find(rec.Key)
+}
+
+func (r *IdempotencyRepository) Complete(key string, statusCode int, contentType string, body []byte, expiresAt time.Time) error {
+	res, err := r.db.Exec(
+		`UPDATE idempotency_keys
+		 SET status_code = ?, content_type = ?, body = ?, expires_at = ?
+		 WHERE key = ?`,
+		statusCode,
+		contentType,
+		body,
+		expiresAt.UTC(),
+		key,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return idempotency.ErrKeyNotFound
+	}
+
+	return nil
+}
+
+func (r *IdempotencyRepository) Release(key string) error {
+	_, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
+
+func (r *IdempotencyRepository) DeleteExpired(now time.Time) error {
+	_, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, now.UTC())
+	return err
+}
+
+func (r *IdempotencyRepository) find(key string) (*idempotency.Record, error) {
+	row := r.db.QueryRow(
+		`SELECT key, request_hash, status_code, content_type, body, created_at, expires_at
+		 FROM idempotency_keys
+		 WHERE key = ?`,
+		key,
+	)
+
+	var rec idempotency.Record
+	if err := row.Scan(
+		&rec.Key,
+		&rec.RequestHash,
+		&rec.StatusCode,
+		&rec.ContentType,
+		&rec.Body,
+		&rec.CreatedAt,
+		&rec.ExpiresAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, idempotency.ErrKeyNotFound
+		}
+		return nil, err
+	}
+
+	rec.CreatedAt = rec.CreatedAt.UTC()
+	rec.ExpiresAt = rec.ExpiresAt.UTC()
+	return &rec, nil
+}
@@ -3,14 +3,19 @@ package sqlite
 import (
 	"database/sql"
 	"errors"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
 
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
 )
 
-var ErrInvoiceNotFound = errors.New("invoice not found")
+var ErrInvoiceNotFound = invoice.ErrNotFound
 
 type InvoiceRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
@@ -20,45 +25,304 @@ func NewInvoiceRepository(db *sql.DB) *InvoiceRepository {
 }
 
 func (r *InvoiceRepository) Save(inv *invoice.Invoice) error {
-	_, err := r.db.Exec(
-		`INSERT INTO invoices (id, amount, status)
-		 VALUES (?, ?, ?)`,
+	return withTx(r.db, func(tx dbtx) error {
+		return saveInvoice(tx, inv)
+	})
+}
+
+func saveInvoice(tx dbtx, inv *invoice.Invoice) error {
+	if _, err := tx.Exec(
+		`INSERT INTO invoices (`+invoiceColumns+`)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		inv.ID,
-		inv.Amount,
+		inv.MerchantID,
+		inv.Amount.Amount,
+		string(inv.Amount.Currency),
 		string(inv.Status),
-	)
-	return err
+		inv.AmountPaid.Amount,
+		inv.Credit.Amount,
+		inv.AmountRefunded.Amount,
+		inv.CancelReason,
+		inv.PaymentAttempts,
+		inv.IssuedAt.UTC(),
+		inv.DueAt.UTC(),
+		inv.Version,
+	); err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return invoice.ErrAlreadyExists
+		}
+		return err
+	}
+
+	for i, line := range inv.Lines {
+		if _, err := tx.Exec(
+			`INSERT INTO invoice_lines
+			 (invoice_id, position, description, quantity, unit_price, tax_rate, discount)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			inv.ID,
+			i,
+			line.Description,
+			line.Quantity,
+			line.UnitPrice.Amount,
+			line.TaxRate,
+			line.Discount.Amount,
+		); err != nil {
+			return err
+		}
+	}
+
+	return saveAppliedPayments(tx, inv)
+}
+
+// saveAppliedPayments stores the payments applied to the invoice that are not
+// stored yet; applied payments are only ever appended.
+func saveAppliedPayments(tx dbtx, inv *invoice.Invoice) error {
+	for i, paymentID := range inv.AppliedPayments {
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO invoice_payments (invoice_id, position, payment_id)
+			 VALUES (?, ?, ?)`,
+			inv.ID,
+			i,
+			paymentID,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const invoiceColumns = `id, merchant_id, amount, currency, status, amount_paid, credit, amount_refunded,
+	cancel_reason, payment_attempts, issued_at, due_at, version`
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
 func (r *InvoiceRepository) FindByID(id string) (*invoice.Invoice, error) {
 	row := r.db.QueryRow(
-		`SELECT id, amount, status
+		`SELECT `+invoiceColumns+`
 		 FROM invoices
 		 WHERE id = ?`,
 		id,
 	)
 
-	var inv invoice.Invoice
-	var status string
-
-	if err := row.Scan(&inv.ID, &inv.Amount, &status); err != nil {
+	inv, err := scanInvoice(row)
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrInvoiceNotFound
 		}
 		return nil, err
 	}
 
+	if err := r.loadDetails(inv); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+func (r *InvoiceRepository) FindOverdue(now time.Time, limit int) ([]*invoice.Invoice, error) {
+	statuses := invoice.StatusesLeadingTo(invoice.StatusOverdue)
+
+	args := []any{now.UTC()}
+	placeholders := make([]string, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args = append(args, string(status))
+	}
+	args = append(args, limit)
+
+	return r.findMany(
+		`SELECT `+invoiceColumns+`
+		 FROM invoices
+		 WHERE due_at < ? AND status IN (`+strings.Join(placeholders, ", ")+`)
+		 ORDER BY due_at, id
+		 LIMIT ?`,
+		args...,
+	)
+}
+
+func (r *InvoiceRepository) List(q invoice.ListQuery) ([]*invoice.Invoice, error) {
+	var where []string
+	var args []any
+
+	if q.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(q.Status))
+	}
+	if !q.IssuedFrom.IsZero() {
+		where = append(where, "issued_at >= ?")
+		args = append(args, q.IssuedFrom.UTC())
+	}
+	if !q.IssuedTo.IsZero() {
+		where = append(where, "issued_at < ?")
+		args = append(args, q.IssuedTo.UTC())
+	}
+	if q.After != nil {
+		where = append(where, "(issued_at > ? OR (issued_at = ? AND id > ?))")
+		args = append(args, q.After.IssuedAt.UTC(), q.After.IssuedAt.UTC(), q.After.ID)
+	}
+
+	query := `SELECT ` + invoiceColumns + ` FROM invoices`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY issued_at, id LIMIT ?`
+	args = append(args, q.Limit)
+
+	return r.findMany(query, args...)
+}
+
+// findMany reads every invoice before loading their lines, so no two
+// statements hold connections at the same time.
+func (r *InvoiceRepository) findMany(query string, args ...any) ([]*invoice.Invoice, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var invoices []*invoice.Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, inv := range invoices {
+		if err := r.loadDetails(inv); err != nil {
+			return nil, err
+		}
+	}
+
+	return invoices, nil
+}
+
+func scanInvoice(row rowScanner) (*invoice.Invoice, error) {
+	var inv invoice.Invoice
+	var currency, status string
+
+	if err := row.Scan(
+		&inv.ID,
+		&inv.MerchantID,
+		&inv.Amount.Amount,
+		&currency,
+		&status,
+		&inv.AmountPaid.Amount,
+		&inv.Credit.Amount,
+		&inv.AmountRefunded.Amount,
+		&inv.CancelReason,
+		&inv.PaymentAttempts,
+		&inv.IssuedAt,
+		&inv.DueAt,
+		&inv.Version,
+	); err != nil {
+		return nil, err
+	}
+
+	inv.Amount.Currency = money.Currency(currency)
+	inv.AmountPaid.Currency = money.Currency(currency)
+	inv.Credit.Currency = money.Currency(currency)
+	inv.AmountRefunded.Currency = money.Currency(currency)
 	inv.Status = invoice.Status(status)
+	inv.IssuedAt = inv.IssuedAt.UTC()
+	inv.DueAt = inv.DueAt.UTC()
+
 	return &inv, nil
 }
 
-func (r *InvoiceRepository) UpdateStatus(id string, status invoice.Status) error {
+// loadDetails reads the lines and applied payments of the invoice.
+func (r *InvoiceRepository) loadDetails(inv *invoice.Invoice) error {
+	var err error
+	if inv.Lines, err = r.findLines(inv.ID, inv.Amount.Currency); err != nil {
+		return err
+	}
+	inv.AppliedPayments, err = r.findAppliedPayments(inv.ID)
+	return err
+}
+
+func (r *InvoiceRepository) findAppliedPayments(invoiceID string) ([]string, error) {
+	rows, err := r.db.Query(
+		`SELECT payment_id
+		 FROM invoice_payments
+		 WHERE invoice_id = ?
+		 ORDER BY position`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []string
+	for rows.Next() {
+		var paymentID string
+		if err := rows.Scan(&paymentID); err != nil {
+			return nil, err
+		}
+		applied = append(applied, paymentID)
+	}
+
+	return applied, rows.Err()
+}
+
+func (r *InvoiceRepository) findLines(invoiceID string, currency money.Currency) ([]invoice.LineItem, error) {
+	rows, err := r.db.Query(
+		`SELECT description, quantity, unit_price, tax_rate, discount
+		 FROM invoice_lines
+		 WHERE invoice_id = ?
+		 ORDER BY position`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []invoice.LineItem
+
+	for rows.Next() {
+		line := invoice.LineItem{
+			UnitPrice: money.Zero(currency),
+			Discount:  money.Zero(currency),
+		}
+
+		if err := rows.Scan(
+			&line.Description,
+			&line.Quantity,
+			&line.UnitPrice.Amount,
+			&line.TaxRate,
+			&line.Discount.Amount,
+		); err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+func (r *InvoiceRepository) UpdateStatus(id string, from, to invoice.Status) error {
+	if !invoice.CanTransition(from, to) {
+		return &invoice.TransitionError{From: from, To: to}
+	}
+
 	res, err := r.db.Exec(
 		`UPDATE invoices
-		 SET status = ?
-		 WHERE id = ?`,
-		string(status),
+		 SET status = ?, version = version + 1
+		 WHERE id = ? AND status = ?`,
+		string(to),
 		id,
+		string(from),
 	)
 	if err != nil {
 		return err
@@ -69,8 +333,52 @@ func (r *InvoiceRepository) UpdateStatus(id string, status invoice.Status) error
 		return err
 	}
 	if affected == 0 {
-		return ErrInvoiceNotFound
+		if _, err := r.FindByID(id); err != nil {
+			return err
+		}
+		return invoice.ErrConcurrentUpdate
+	}
+
+	return nil
+}
+
+func (r *InvoiceRepository) Update(inv *invoice.Invoice) error {
+	err := withTx(r.db, func(tx dbtx) error {
+		res, err := tx.Exec(
+			`UPDATE invoices
+			 SET status = ?, amount_paid = ?, credit = ?, amount_refunded = ?, cancel_reason = ?,
+			     payment_attempts = ?, version = version + 1
+			 WHERE id = ? AND version = ?`,
+			string(inv.Status),
+			inv.AmountPaid.Amount,
+			inv.Credit.Amount,
+			inv.AmountRefunded.Amount,
+			inv.CancelReason,
+			inv.PaymentAttempts,
+			inv.ID,
+			inv.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			if _, err := (&InvoiceRepository{db: tx}).FindByID(inv.ID); err != nil {
+				return err
+			}
+			return invoice.ErrConcurrentUpdate
+		}
+
+		return saveAppliedPayments(tx, inv)
+	})
+	if err != nil {
+		return err
 	}
 
+	inv.Version++
 	return nil
 }
@@ -0,0 +1,122 @@
+package sqlite_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func newInvoice(t *testing.T, id string, issuedAt, dueAt time.Time) *invoice.Invoice {
+	t.Helper()
+
+	inv, err := invoice.New(id, money.BRL, []invoice.LineItem{{
+		Description: "plan",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 1000, Currency: money.BRL},
+	}}, issuedAt, dueAt)
+	require.NoError(t, err)
+	return inv
+}
+
+func TestInvoiceRepository_ShouldKeepAppliedPayments(t *testing.T) {
+	repo := sqlite.NewInvoiceRepository(setupDB(t))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	inv := newInvoice(t, "inv-1", now, now.Add(time.Hour))
+	require.NoError(t, repo.Save(inv))
+
+	for _, paymentID := range []string{"pay-1", "pay-2"} {
+		require.NoError(t, inv.Transition(invoice.StatusProcessing))
+		require.NoError(t, inv.ApplyPayment(paymentID, money.Money{Amount: 300, Currency: money.BRL}))
+		require.NoError(t, repo.Update(inv))
+	}
+
+	found, err := repo.FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, []string{"pay-1", "pay-2"}, found.AppliedPayments)
+	require.Equal(t, int64(600), found.AmountPaid.Amount)
+}
+
+func invoiceIDs(invoices []*invoice.Invoice) []string {
+	ids := make([]string, len(invoices))
+	for i, inv := range invoices {
+		ids[i] = inv.ID
+	}
+	return ids
+}
+
+func TestInvoiceRepository_List_ShouldFilterAndPage(t *testing.T) {
+	repo := sqlite.NewInvoiceRepository(setupDB(t))
+	day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, inv := range []*invoice.Invoice{
+		newInvoice(t, "inv-b", day, day.Add(time.Hour)),
+		newInvoice(t, "inv-a", day, day.Add(time.Hour)),
+		newInvoice(t, "inv-c", day.Add(24*time.Hour), day.Add(25*time.Hour)),
+		newInvoice(t, "inv-d", day.Add(48*time.Hour), day.Add(49*time.Hour)),
+	} {
+		require.NoError(t, repo.Save(inv))
+	}
+	require.NoError(t, repo.UpdateStatus("inv-c", invoice.StatusPending, invoice.StatusProcessing))
+
+	first, err := repo.List(invoice.ListQuery{Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-a", "inv-b"}, invoiceIDs(first))
+
+	rest, err := repo.List(invoice.ListQuery{After: invoice.CursorOf(first[1]), Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-c", "inv-d"}, invoiceIDs(rest))
+
+	pending, err := repo.List(invoice.ListQuery{Status: invoice.StatusPending, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-a", "inv-b", "inv-d"}, invoiceIDs(pending))
+
+	issued, err := repo.List(invoice.ListQuery{IssuedFrom: day.Add(time.Hour), IssuedTo: day.Add(48 * time.Hour), Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-c"}, invoiceIDs(issued))
+	require.Len(t, issued[0].Lines, 1)
+}
+
+func TestInvoiceRepository_FindOverdue_ShouldReturnOpenInvoicesPastDue(t *testing.T) {
+	repo := sqlite.NewInvoiceRepository(setupDB(t))
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	issuedAt := now.Add(-10 * 24 * time.Hour)
+
+	for _, inv := range []*invoice.Invoice{
+		newInvoice(t, "inv-late", issuedAt, now.Add(-2*time.Hour)),
+		newInvoice(t, "inv-later", issuedAt, now.Add(-time.Hour)),
+		newInvoice(t, "inv-latest", issuedAt, now.Add(-time.Minute)),
+		newInvoice(t, "inv-processing", issuedAt, now.Add(-3*time.Hour)),
+		newInvoice(t, "inv-not-due", issuedAt, now.Add(time.Hour)),
+	} {
+		require.NoError(t, repo.Save(inv))
+	}
+	require.NoError(t, repo.UpdateStatus("inv-processing", invoice.StatusPending, invoice.StatusProcessing))
+
+	overdue, err := repo.FindOverdue(now, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-late", "inv-later", "inv-latest"}, invoiceIDs(overdue))
+
+	limited, err := repo.FindOverdue(now, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"inv-late", "inv-later"}, invoiceIDs(limited))
+}
+
+func TestInvoiceRepository_Save_ShouldRejectAnExistingID(t *testing.T) {
+	repo := sqlite.NewInvoiceRepository(setupDB(t))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, repo.Save(newInvoice(t, "inv-1", now, now.Add(time.Hour))))
+
+	err := repo.Save(newInvoice(t, "inv-1", now, now.Add(2*time.Hour)))
+	require.ErrorIs(t, err, invoice.ErrAlreadyExists)
+
+	found, err := repo.FindByID("inv-1")
+	require.NoError(t, err)
+	require.True(t, found.DueAt.Equal(now.Add(time.Hour)))
+}
@@ -0,0 +1,384 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+)
+
+// Before migrations were versioned, tables were created by whichever release
+// first opened the database, in that release's shape. The baseline migration
+// takes them over: their rows are copied into the baseline's tables, filling
+// in what older shapes lack, and the old tables dropped.
+
+const baselineVersion = 1
+
+// legacyTables are the baseline's tables, each after those it refers to.
+var legacyTables = []string{
+	"invoices",
+	"invoice_lines",
+	"payments",
+	"payment_attempts",
+	"refunds",
+	"scheduled_payments",
+	"idempotency_keys",
+	"outbox_events",
+}
+
+// legacyEventTypes are the names the first releases gave event types.
+var legacyEventTypes = map[string]event.Type{
+	"REQUESTED": event.PaymentRequested,
+	"SUCCEEDED": event.PaymentSucceeded,
+	"FAILED":    event.PaymentFailed,
+}
+
+// legacyCurrency is the currency of amounts stored before they had one.
+const legacyCurrency = money.BRL
+
+type column struct {
+	name       string
+	typ        string
+	notNull    bool
+	hasDefault bool
+}
+
+type columns []column
+
+func (c columns) has(name string) bool {
+	return c.typeOf(name) != ""
+}
+
+func (c columns) typeOf(name string) string {
+	for _, col := range c {
+		if col.name == name {
+			return strings.ToUpper(col.typ)
+		}
+	}
+	return ""
+}
+
+// setAsideLegacyTables renames the baseline tables the database already has
+// to legacy_<name>, dropping their indexes so the baseline can reuse the
+// names, and returns their columns by table.
+func setAsideLegacyTables(tx dbtx) (map[string]columns, error) {
+	legacy := make(map[string]columns)
+
+	for _, table := range legacyTables {
+		cols, err := tableColumns(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		legacy[table] = cols
+
+		indexes, err := tableIndexes(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if _, err := tx.Exec(fmt.Sprintf(`DROP INDEX %q`, index)); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q RENAME TO %q`, table, "legacy_"+table)); err != nil {
+			return nil, err
+		}
+	}
+
+	return legacy, nil
+}
+
+// adoptLegacyTables copies the rows of the tables set aside into the
+// baseline's, then drops them.
+func adoptLegacyTables(tx dbtx, legacy map[string]columns) error {
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	now := "'" + time.Now().UTC().Format(sqlite3.SQLiteTimestampFormats[0]) + "'"
+
+	for _, table := range legacyTables {
+		cols, ok := legacy[table]
+		if !ok {
+			continue
+		}
+		if err := copyLegacyRows(tx, table, cols, now); err != nil {
+			return fmt.Errorf("legacy %s: %w", table, err)
+		}
+	}
+
+	// invoices used to be a bare amount, and payments a single charge
+	if _, ok := legacy["invoice_lines"]; !ok && legacy["invoices"] != nil {
+		if _, err := tx.Exec(
+			`INSERT INTO invoice_lines (invoice_id, position, description, quantity, unit_price, tax_rate, discount)
+			 SELECT id, 0, 'Invoice total', 1, amount, 0, 0 FROM invoices`,
+		); err != nil {
+			return err
+		}
+	}
+	if _, ok := legacy["payment_attempts"]; !ok && legacy["payments"] != nil {
+		if _, err := tx.Exec(
+			`INSERT INTO payment_attempts (payment_id, invoice_id, number, status, idempotency_key, created_at)
+			 SELECT id, invoice_id, attempt, status, idempotency_key, ` + now + `
+			 FROM payments
+			 WHERE attempt > 0`,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := legacy["outbox_events"]; ok {
+		if err := upgradeLegacyPayloads(tx); err != nil {
+			return err
+		}
+	}
+
+	for table := range legacy {
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE %q`, "legacy_"+table)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyLegacyRows(tx dbtx, table string, legacy columns, now string) error {
+	cols, err := tableColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	var names, values []string
+	for _, col := range cols {
+		value := legacyValue(table, col.name, legacy, now)
+		if value == "" {
+			if col.notNull && !col.hasDefault {
+				return fmt.Errorf("no value for column %s", col.name)
+			}
+			continue
+		}
+		names = append(names, col.name)
+		values = append(values, value)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(
+		`INSERT INTO %s (%s) SELECT %s FROM %s AS l ORDER BY l.rowid`,
+		table,
+		strings.Join(names, ", "),
+		strings.Join(values, ", "),
+		"legacy_"+table,
+	))
+	return err
+}
+
+// legacyValue is the expression computing column from a legacy row l, or
+// empty to leave the column to its default.
+func legacyValue(table, column string, legacy columns, now string) string {
+	switch table + "." + column {
+	case "invoices.currency":
+		if !legacy.has(column) {
+			return "'" + string(legacyCurrency) + "'"
+		}
+	case "invoices.amount_paid":
+		// invoices were paid in full or not at all
+		if !legacy.has(column) {
+			return "CASE WHEN l.status = 'PAID' THEN l.amount ELSE 0 END"
+		}
+	case "invoices.issued_at", "invoices.due_at":
+		if !legacy.has(column) {
+			return now
+		}
+	case "payments.amount":
+		if !legacy.has(column) {
+			return `COALESCE((SELECT amount FROM invoices WHERE id = l.invoice_id), 0)`
+		}
+	case "payments.currency":
+		if !legacy.has(column) {
+			return `COALESCE((SELECT currency FROM invoices WHERE id = l.invoice_id), '` + string(legacyCurrency) + `')`
+		}
+	case "outbox_events.id":
+		return legacyEventID(legacy)
+	case "outbox_events.correlation_id":
+		if !legacy.has(column) {
+			return legacyEventID(legacy)
+		}
+	case "outbox_events.event_type":
+		expr := "CASE l.event_type"
+		for _, old := range slices.Sorted(maps.Keys(legacyEventTypes)) {
+			expr += fmt.Sprintf(" WHEN '%s' THEN '%s'", old, legacyEventTypes[old])
+		}
+		return expr + " ELSE l.event_type END"
+	case "outbox_events.occurred_at":
+		if !legacy.has(column) {
+			return "l.created_at"
+		}
+	case "outbox_events.published_at":
+		if !legacy.has(column) && legacy.has("published") {
+			return "CASE WHEN l.published <> 0 THEN l.created_at END"
+		}
+	}
+
+	if legacy.has(column) {
+		return "l." + column
+	}
+	return ""
+}
+
+// legacyEventID keys outbox rows by event ID. Rows of the autoincrement
+// tables had none and are given one from their row number.
+func legacyEventID(legacy columns) string {
+	switch {
+	case legacy.has("event_id"):
+		return "l.event_id"
+	case legacy.typeOf("id") == "INTEGER":
+		return "'evt_legacy_' || l.id"
+	}
+	return "l.id"
+}
+
+// upgradeLegacyPayloads rewrites the outbox payloads of the first releases
+// into the current encoding. Payloads that still can't be decoded are left
+// for the dispatcher to poison.
+func upgradeLegacyPayloads(tx dbtx) error {
+	rows, err := tx.Query(`SELECT id, event_type, payload FROM outbox_events`)
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		id      string
+		typ     event.Type
+		payload []byte
+	}
+	var upgrades []row
+
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.typ, &r.payload); err != nil {
+			rows.Close()
+			return err
+		}
+
+		payload, err := upgradeLegacyPayload(r.typ, r.payload)
+		if err != nil {
+			continue
+		}
+		if string(payload) != string(r.payload) {
+			r.payload = payload
+			upgrades = append(upgrades, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range upgrades {
+		if _, err := tx.Exec(`UPDATE outbox_events SET payload = ? WHERE id = ?`, r.payload, r.id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upgradeLegacyPayload undoes what the first releases did differently: some
+// encoded the payload twice, as a JSON string, and all keyed fields by their
+// Go names and stored amounts as bare integers.
+func upgradeLegacyPayload(t event.Type, data []byte) ([]byte, error) {
+	var inner []byte
+	if err := json.Unmarshal(data, &inner); err == nil {
+		data = inner
+	}
+
+	payloadType, err := event.PayloadType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for i := range payloadType.NumField() {
+		field := payloadType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		value, ok := fields[field.Name]
+		if !ok || name == "" || name == field.Name {
+			continue
+		}
+		delete(fields, field.Name)
+		if _, ok := fields[name]; ok {
+			continue
+		}
+
+		var amount int64
+		if field.Type == reflect.TypeFor[money.Money]() && json.Unmarshal(value, &amount) == nil {
+			if value, err = json.Marshal(money.Money{Amount: amount, Currency: legacyCurrency}); err != nil {
+				return nil, err
+			}
+		}
+		fields[name] = value
+	}
+
+	return json.Marshal(fields)
+}
+
+func tableColumns(tx dbtx, table string) (columns, error) {
+	rows, err := tx.Query(
+		`SELECT name, type, "notnull", dflt_value IS NOT NULL FROM pragma_table_info(?)`,
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols columns
+	for rows.Next() {
+		var col column
+		if err := rows.Scan(&col.name, &col.typ, &col.notNull, &col.hasDefault); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+
+	return cols, rows.Err()
+}
+
+func tableIndexes(tx dbtx, table string) ([]string, error) {
+	// indexes without sql back constraints and go with their table
+	rows, err := tx.Query(
+		`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL`,
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, name)
+	}
+
+	return indexes, rows.Err()
+}
@@ -1,38 +1,270 @@
 package sqlite
 
-import "database/sql"
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
+// Migrations live in migrations/ as NNNN_name.up.sql, with an optional
+// NNNN_name.down.sql undoing it. Applied migrations are never edited: schema
+// changes go into a new version.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var (
+	ErrMigrationChanged = errors.New("applied migration was changed")
+	ErrMigrationMissing = errors.New("applied migration is missing")
+	ErrIrreversible     = errors.New("migration has no down script")
+)
+
+// Migration is a schema version. AppliedAt is zero while it is pending.
+type Migration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+
+	up   string
+	down string
+}
+
+func (m Migration) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// RunMigrations applies every pending migration in order, each in its own
+// transaction.
 func RunMigrations(db *sql.DB) error {
-	stmts := []string{
-
-		`CREATE TABLE IF NOT EXISTS invoices (
-			id TEXT PRIMARY KEY,
-			amount INTEGER NOT NULL,
-			status TEXT NOT NULL
-		);`,
-
-		`CREATE TABLE IF NOT EXISTS payments (
-			id TEXT PRIMARY KEY,
-			invoice_id TEXT NOT NULL,
-			attempt INTEGER NOT NULL,
-			status TEXT NOT NULL,
-			idempotency_key TEXT NOT NULL UNIQUE
-		);`,
-
-		`CREATE TABLE IF NOT EXISTS outbox_events (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			event_type TEXT NOT NULL,
-			payload TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			published_at DATETIME
-		);`,
-	}
-
-	for _, stmt := range stmts {
-		if _, err := db.Exec(stmt); err != nil {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	);`); err != nil {
+		return err
+	}
+
+	migrations, err := Migrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Applied() {
+			continue
+		}
+
+		err := withTx(db, func(tx dbtx) error {
+			if err := apply(tx, m); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(
+				`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+				m.Version,
+				m.Name,
+				m.Checksum,
+				time.Now().UTC(),
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m, err)
+		}
+	}
+
+	return nil
+}
+
+// apply runs the up script of m. The baseline also takes over the tables of
+// databases created before migrations were versioned.
+func apply(tx dbtx, m Migration) error {
+	if m.Version != baselineVersion {
+		_, err := tx.Exec(m.up)
+		return err
+	}
+
+	legacy, err := setAsideLegacyTables(tx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(m.up); err != nil {
+		return err
+	}
+
+	return adoptLegacyTables(tx, legacy)
+}
+
+// RollbackMigrations undoes the last steps applied migrations, newest first.
+// A negative steps undoes all of them.
+func RollbackMigrations(db *sql.DB, steps int) error {
+	migrations, err := Migrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range slices.Backward(migrations) {
+		if steps == 0 {
+			break
+		}
+		if !m.Applied() {
+			continue
+		}
+		if m.down == "" {
+			return fmt.Errorf("migration %s: %w", m, ErrIrreversible)
+		}
+
+		err := withTx(db, func(tx dbtx) error {
+			if _, err := tx.Exec(m.down); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
 			return err
+		})
+		if err != nil {
+			return fmt.Errorf("rolling back migration %s: %w", m, err)
 		}
+		steps--
 	}
 
 	return nil
 }
+
+// Migrations lists the known migrations with the state the database has
+// them in, without changing it. It fails if an applied migration was changed
+// or removed since.
+func Migrations(db *sql.DB) ([]Migration, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range applied {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == a.Version })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationMissing, a)
+		}
+
+		m := &migrations[i]
+		if a.Checksum != m.Checksum {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationChanged, m)
+		}
+		m.AppliedAt = a.AppliedAt.UTC()
+	}
+
+	return migrations, nil
+}
+
+// appliedMigrations reads schema_migrations, which a database no migration
+// ran on yet doesn't have.
+func appliedMigrations(db *sql.DB) ([]Migration, error) {
+	var versioned bool
+	if err := db.QueryRow(
+		`SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+	).Scan(&versioned); err != nil {
+		return nil, err
+	}
+	if !versioned {
+		return nil, nil
+	}
+
+	rows, err := db.Query(`SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []Migration
+	for rows.Next() {
+		var m Migration
+		if err := rows.Scan(&m.Version, &m.Name, &m.Checksum, &m.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, rows.Err()
+}
+
+// loadMigrations reads the migration files in version order, checking that
+// versions are unique and every one has an up script.
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range names {
+		base := path.Base(file)
+
+		stem, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: want NNNN_name.up.sql or NNNN_name.down.sql", base)
+		}
+
+		prefix, name, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: want a positive version before the name", base)
+		}
+
+		data, err := fs.ReadFile(files, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %s: version %d is also named %s", base, version, m.Name)
+		}
+
+		if direction == "up" {
+			sum := sha256.Sum256(data)
+			m.up = string(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %s: missing up script", m)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,8 @@
+DROP TABLE outbox_events;
+DROP TABLE idempotency_keys;
+DROP TABLE scheduled_payments;
+DROP TABLE refunds;
+DROP TABLE payment_attempts;
+DROP TABLE payments;
+DROP TABLE invoice_lines;
+DROP TABLE invoices;
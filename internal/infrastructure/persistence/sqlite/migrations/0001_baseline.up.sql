@@ -0,0 +1,122 @@
+-- Databases created before migrations were versioned already have some of
+-- these tables, in an older shape. RunMigrations sets them aside before this
+-- script runs and copies their rows into the new tables afterwards.
+
+CREATE TABLE invoices (
+    id TEXT PRIMARY KEY,
+    merchant_id TEXT NOT NULL DEFAULT '',
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    amount_paid INTEGER NOT NULL DEFAULT 0,
+    credit INTEGER NOT NULL DEFAULT 0,
+    amount_refunded INTEGER NOT NULL DEFAULT 0,
+    cancel_reason TEXT NOT NULL DEFAULT '',
+    payment_attempts INTEGER NOT NULL DEFAULT 0,
+    issued_at DATETIME NOT NULL,
+    due_at DATETIME NOT NULL,
+    version INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_invoices_due_at
+ON invoices(status, due_at);
+
+CREATE TABLE invoice_lines (
+    invoice_id TEXT NOT NULL REFERENCES invoices(id),
+    position INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_price INTEGER NOT NULL,
+    tax_rate INTEGER NOT NULL,
+    discount INTEGER NOT NULL,
+    PRIMARY KEY (invoice_id, position)
+);
+
+CREATE TABLE payments (
+    id TEXT PRIMARY KEY,
+    invoice_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE payment_attempts (
+    payment_id TEXT NOT NULL REFERENCES payments(id),
+    invoice_id TEXT NOT NULL,
+    number INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL UNIQUE,
+    decline_code TEXT NOT NULL DEFAULT '',
+    gateway_reference TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (payment_id, number)
+);
+
+CREATE TABLE refunds (
+    id TEXT PRIMARY KEY,
+    payment_id TEXT NOT NULL,
+    invoice_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_refunds_payment_id
+ON refunds(payment_id);
+
+CREATE TABLE scheduled_payments (
+    id TEXT PRIMARY KEY,
+    invoice_id TEXT NOT NULL,
+    merchant_id TEXT NOT NULL,
+    payment_id TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    payment_method TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    delay INTEGER NOT NULL,
+    run_at DATETIME NOT NULL,
+    correlation_id TEXT NOT NULL DEFAULT '',
+    causation_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_scheduled_payments_run_at
+ON scheduled_payments(run_at);
+
+CREATE INDEX idx_scheduled_payments_invoice_id
+ON scheduled_payments(invoice_id);
+
+CREATE TABLE idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER NOT NULL,
+    content_type TEXT NOT NULL,
+    body BLOB,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE outbox_events (
+    id TEXT PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    occurred_at DATETIME NOT NULL,
+    aggregate_type TEXT NOT NULL DEFAULT '',
+    aggregate_id TEXT NOT NULL DEFAULT '',
+    correlation_id TEXT NOT NULL DEFAULT '',
+    causation_id TEXT NOT NULL DEFAULT '',
+    payload BLOB NOT NULL,
+    created_at DATETIME NOT NULL,
+    published_at DATETIME,
+    poisoned_at DATETIME,
+    last_error TEXT
+);
+
+CREATE INDEX idx_outbox_events_pending
+ON outbox_events(published_at, poisoned_at, created_at);
+
+CREATE INDEX idx_outbox_events_correlation_id
+ON outbox_events(correlation_id);
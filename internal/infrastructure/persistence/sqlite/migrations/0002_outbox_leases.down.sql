@@ -0,0 +1,3 @@
+ALTER TABLE outbox_events DROP COLUMN lease_expires_at;
+
+ALTER TABLE outbox_events DROP COLUMN claimed_by;
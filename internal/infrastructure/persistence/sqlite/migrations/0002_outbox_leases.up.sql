@@ -0,0 +1,5 @@
+-- Dispatchers lease the events they are about to publish. An event whose
+-- lease expired can be claimed by another dispatcher.
+ALTER TABLE outbox_events ADD COLUMN claimed_by TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE outbox_events ADD COLUMN lease_expires_at DATETIME;
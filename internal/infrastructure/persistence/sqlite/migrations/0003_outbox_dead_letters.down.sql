@@ -0,0 +1,5 @@
+DROP TABLE outbox_dead_letters;
+
+ALTER TABLE outbox_events DROP COLUMN next_attempt_at;
+
+ALTER TABLE outbox_events DROP COLUMN attempts;
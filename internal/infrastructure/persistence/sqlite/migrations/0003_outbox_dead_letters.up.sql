@@ -0,0 +1,24 @@
+-- Failed publishes are retried with backoff. Events failing too often move
+-- to outbox_dead_letters until an operator replays or discards them.
+ALTER TABLE outbox_events ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE outbox_events ADD COLUMN next_attempt_at DATETIME;
+
+CREATE TABLE outbox_dead_letters (
+    id TEXT PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    occurred_at DATETIME NOT NULL,
+    aggregate_type TEXT NOT NULL DEFAULT '',
+    aggregate_id TEXT NOT NULL DEFAULT '',
+    correlation_id TEXT NOT NULL DEFAULT '',
+    causation_id TEXT NOT NULL DEFAULT '',
+    payload BLOB NOT NULL,
+    created_at DATETIME NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    dead_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_outbox_dead_letters_dead_at
+ON outbox_dead_letters(dead_at);
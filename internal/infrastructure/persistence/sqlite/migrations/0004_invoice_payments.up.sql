@@ -0,0 +1,16 @@
+-- Invoices keep the payments settled against them, so a redelivered success
+-- is not applied twice. Successful payments of invoices that were paid
+-- before are taken as applied.
+CREATE TABLE invoice_payments (
+    invoice_id TEXT NOT NULL REFERENCES invoices(id),
+    position INTEGER NOT NULL,
+    payment_id TEXT NOT NULL,
+    PRIMARY KEY (invoice_id, position),
+    UNIQUE (invoice_id, payment_id)
+);
+
+INSERT INTO invoice_payments (invoice_id, position, payment_id)
+SELECT p.invoice_id, ROW_NUMBER() OVER (PARTITION BY p.invoice_id ORDER BY p.rowid) - 1, p.id
+FROM payments p
+JOIN invoices i ON i.id = p.invoice_id
+WHERE p.status = 'SUCCESS' AND i.amount_paid > 0;
@@ -0,0 +1,309 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func TestRunMigrations_ShouldBeRepeatable(t *testing.T) {
+	db := setupDB(t)
+
+	require.NoError(t, sqlite.RunMigrations(db))
+
+	migrations, err := sqlite.Migrations(db)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for _, m := range migrations {
+		require.True(t, m.Applied(), "migration %s pending", m)
+	}
+}
+
+func TestRollbackMigrations_ShouldUndoNewestFirst(t *testing.T) {
+	db := setupDB(t)
+
+	require.NoError(t, sqlite.RollbackMigrations(db, 1))
+
+	migrations, err := sqlite.Migrations(db)
+	require.NoError(t, err)
+	last := migrations[len(migrations)-1]
+	require.False(t, last.Applied())
+	for _, m := range migrations[:len(migrations)-1] {
+		require.True(t, m.Applied(), "migration %s rolled back", m)
+	}
+
+	require.NoError(t, sqlite.RollbackMigrations(db, -1))
+	require.Zero(t, countTables(t, db, "invoices", "outbox_events"))
+
+	require.NoError(t, sqlite.RunMigrations(db))
+	require.Equal(t, 2, countTables(t, db, "invoices", "outbox_events"))
+}
+
+func TestRunMigrations_ShouldRejectChangedMigrations(t *testing.T) {
+	db := setupDB(t)
+
+	_, err := db.Exec(`UPDATE schema_migrations SET checksum = 'edited' WHERE version = 1`)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, sqlite.RunMigrations(db), sqlite.ErrMigrationChanged)
+}
+
+func TestRunMigrations_ShouldRejectUnknownAppliedMigrations(t *testing.T) {
+	db := setupDB(t)
+
+	_, err := db.Exec(
+		`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (9999, 'from_the_future', 'x', ?)`,
+		time.Now().UTC(),
+	)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, sqlite.RunMigrations(db), sqlite.ErrMigrationMissing)
+}
+
+func TestMigrations_ShouldNotChangeAnUnversionedDatabase(t *testing.T) {
+	db := openDB(t)
+
+	migrations, err := sqlite.Migrations(db)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for _, m := range migrations {
+		require.False(t, m.Applied(), "migration %s applied", m)
+	}
+	require.Zero(t, countTables(t, db, "schema_migrations"))
+}
+
+// The schema db/db.db shipped with: outbox rows keyed by event ID with a
+// published flag, and invoices and payments without currency.
+const shippedDatabaseSchema = `
+CREATE TABLE invoices ( id TEXT PRIMERY KEY, amount INTEGER NOT NULL, status TEXT NOT NULL );
+CREATE TABLE payments (id TEXT PRIMARY KEY, invoice_id TEXT NOT NULL, attempt INTEGER NOT NULL, status TEXT NOT NULL, idempotency_key TEXT NOT NULL UNIQUE);
+CREATE TABLE outbox_events (
+    id TEXT PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    payload BLOB NOT NULL,
+    published INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_outbox_unpublished
+ON outbox_events(published, created_at);`
+
+// The schema the first RunMigrations created: an autoincrement outbox whose
+// payloads were JSON encoded twice.
+const shippedMigrationsSchema = `
+CREATE TABLE IF NOT EXISTS invoices (
+	id TEXT PRIMARY KEY,
+	amount INTEGER NOT NULL,
+	status TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS payments (
+	id TEXT PRIMARY KEY,
+	invoice_id TEXT NOT NULL,
+	attempt INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL UNIQUE
+);
+CREATE TABLE IF NOT EXISTS outbox_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at DATETIME NOT NULL,
+	published_at DATETIME
+);`
+
+func TestRunMigrations_ShouldUpgradeTheShippedDatabase(t *testing.T) {
+	db := openDB(t)
+	now := time.Now().UTC()
+
+	_, err := db.Exec(shippedDatabaseSchema)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO invoices (id, amount, status) VALUES ('inv-1', 1500, 'PROCESSING'), ('inv-2', 1500, 'PAID')`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO payments (id, invoice_id, attempt, status, idempotency_key) VALUES
+		('pay-1', 'inv-1', 1, 'FAILED', 'inv-1-1'),
+		('pay-2', 'inv-2', 1, 'SUCCESS', 'inv-2-1')`)
+	require.NoError(t, err)
+	_, err = db.Exec(
+		`INSERT INTO outbox_events (id, event_type, payload, published, created_at) VALUES
+		 ('evt-1', 'FAILED', '{"InvoiceID":"inv-1","PaymentID":"pay-1","Retryable":true,"Reason":"declined"}', 1, ?),
+		 ('evt-2', 'REQUESTED', '{"InvoiceID":"inv-1","Amount":1500,"Attempt":2}', 0, ?)`,
+		now, now.Add(time.Second),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, sqlite.RunMigrations(db))
+
+	inv, err := sqlite.NewInvoiceRepository(db).FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, money.Money{Amount: 1500, Currency: money.BRL}, inv.Amount)
+	require.Equal(t, invoice.StatusProcessing, inv.Status)
+	require.Len(t, inv.Lines, 1)
+	require.Equal(t, inv.Amount, inv.Lines[0].Total())
+	require.True(t, inv.AmountPaid.IsZero())
+	require.Equal(t, inv.Amount, inv.Balance())
+	require.Empty(t, inv.AppliedPayments)
+
+	paid, err := sqlite.NewInvoiceRepository(db).FindByID("inv-2")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusPaid, paid.Status)
+	require.Equal(t, money.Money{Amount: 1500, Currency: money.BRL}, paid.AmountPaid)
+	require.True(t, paid.Balance().IsZero())
+	require.Equal(t, []string{"pay-2"}, paid.AppliedPayments)
+
+	payments := sqlite.NewPaymentRepository(db)
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, money.Money{Amount: 1500, Currency: money.BRL}, pay.Amount)
+	require.Equal(t, payment.StatusFailed, pay.Status)
+	attempts, err := payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	require.Equal(t, "inv-1-1", attempts[0].IdempotencyKey)
+
+	events, err := sqlite.NewOutboxRepository(db).FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "evt-2", events[0].ID)
+	require.Equal(t, event.PaymentRequested, events[0].Type)
+	require.True(t, events[0].OccurredAt.Equal(now.Add(time.Second)))
+
+	payload, err := event.DecodePayload(events[0].Type, events[0].Payload)
+	require.NoError(t, err)
+	require.Equal(t, event.PaymentRequestPayload{
+		InvoiceID: "inv-1",
+		Amount:    money.Money{Amount: 1500, Currency: money.BRL},
+		Attempt:   2,
+	}, payload)
+
+	require.Zero(t, countTables(t, db, "legacy_invoices", "legacy_payments", "legacy_outbox_events"))
+}
+
+func TestRunMigrations_ShouldUpgradeTheShippedMigrationsSchema(t *testing.T) {
+	db := openDB(t)
+
+	_, err := db.Exec(shippedMigrationsSchema)
+	require.NoError(t, err)
+
+	// the shipped repository encoded the already encoded payload again
+	payload, err := json.Marshal([]byte(`{"InvoiceID":"inv-1","PaymentID":"pay-1"}`))
+	require.NoError(t, err)
+	_, err = db.Exec(
+		`INSERT INTO outbox_events (event_type, payload, created_at) VALUES ('SUCCEEDED', ?, ?)`,
+		string(payload), time.Now().UTC(),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, sqlite.RunMigrations(db))
+
+	events, err := sqlite.NewOutboxRepository(db).FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "evt_legacy_1", events[0].ID)
+	require.Equal(t, "evt_legacy_1", events[0].CorrelationID)
+	require.Equal(t, event.PaymentSucceeded, events[0].Type)
+
+	decoded, err := event.DecodePayload(events[0].Type, events[0].Payload)
+	require.NoError(t, err)
+	require.Equal(t, event.PaymentSucceededPayload{InvoiceID: "inv-1", PaymentID: "pay-1"}, decoded)
+}
+
+func TestRunMigrations_ShouldKeepRowsOfTheEnvelopeOutboxTable(t *testing.T) {
+	db := openDB(t)
+
+	// the outbox table as it was between the envelope and the migrations
+	_, err := db.Exec(`CREATE TABLE outbox_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		occurred_at DATETIME NOT NULL,
+		aggregate_type TEXT NOT NULL DEFAULT '',
+		aggregate_id TEXT NOT NULL DEFAULT '',
+		correlation_id TEXT NOT NULL DEFAULT '',
+		causation_id TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		published_at DATETIME,
+		poisoned_at DATETIME,
+		last_error TEXT
+	)`)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	_, err = db.Exec(
+		`INSERT INTO outbox_events (event_id, event_type, occurred_at, payload, created_at)
+		 VALUES ('evt-1', 'PAYMENT_SUCCEEDED', ?, '{}', ?)`,
+		now, now,
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, sqlite.RunMigrations(db))
+
+	events, err := sqlite.NewOutboxRepository(db).FindUnpublished(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "evt-1", events[0].ID)
+}
+
+func TestRunMigrations_ShouldTakeSuccessfulPaymentsOfPaidInvoicesAsApplied(t *testing.T) {
+	db := openDB(t)
+	require.NoError(t, sqlite.RunMigrations(db))
+	require.NoError(t, sqlite.RollbackMigrations(db, 1))
+
+	now := time.Now().UTC()
+	_, err := db.Exec(
+		`INSERT INTO invoices (id, amount, currency, status, amount_paid, issued_at, due_at)
+		 VALUES ('inv-paid', 1000, 'BRL', 'PROCESSING', 400, ?, ?), ('inv-unpaid', 1000, 'BRL', 'PROCESSING', 0, ?, ?)`,
+		now, now, now, now,
+	)
+	require.NoError(t, err)
+	_, err = db.Exec(
+		`INSERT INTO payments (id, invoice_id, amount, currency, attempt, status, idempotency_key)
+		 VALUES ('pay-1', 'inv-paid', 400, 'BRL', 1, 'SUCCESS', 'k1'),
+		        ('pay-2', 'inv-paid', 600, 'BRL', 1, 'PROCESSING', 'k2'),
+		        ('pay-3', 'inv-unpaid', 1000, 'BRL', 1, 'SUCCESS', 'k3')`,
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, sqlite.RunMigrations(db))
+
+	repo := sqlite.NewInvoiceRepository(db)
+
+	paid, err := repo.FindByID("inv-paid")
+	require.NoError(t, err)
+	require.Equal(t, []string{"pay-1"}, paid.AppliedPayments)
+
+	unpaid, err := repo.FindByID("inv-unpaid")
+	require.NoError(t, err)
+	require.Empty(t, unpaid.AppliedPayments)
+}
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func countTables(t *testing.T, db *sql.DB, names ...string) int {
+	t.Helper()
+
+	var count int
+	for _, name := range names {
+		var found int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&found))
+		count += found
+	}
+	return count
+}
@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+)
+
+func (r *OutboxRepository) DeadLetter(id string, owner string, reason string, now time.Time) error {
+	return withTx(r.db, func(tx dbtx) error {
+		res, err := tx.Exec(
+			`INSERT INTO outbox_dead_letters
+			 (id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+			  correlation_id, causation_id, payload, created_at, attempts, last_error, dead_at)
+			 SELECT id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+			        correlation_id, causation_id, payload, created_at, attempts + 1, ?, ?
+			 FROM outbox_events
+			 WHERE id = ? AND claimed_by = ? AND published_at IS NULL`,
+			reason,
+			now.UTC(),
+			id,
+			owner,
+		)
+		if err != nil {
+			return err
+		}
+		if err := leaseHeld(res); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM outbox_events WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *OutboxRepository) ListDeadLetters(limit int) ([]outbox.DeadLetter, error) {
+	rows, err := r.db.Query(
+		`SELECT `+deadLetterColumns+`
+		 FROM outbox_dead_letters
+		 ORDER BY dead_at, rowid
+		 LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var letters []outbox.DeadLetter
+
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, rows.Err()
+}
+
+func (r *OutboxRepository) FindDeadLetter(id string) (outbox.DeadLetter, error) {
+	letter, err := scanDeadLetter(r.db.QueryRow(
+		`SELECT `+deadLetterColumns+` FROM outbox_dead_letters WHERE id = ?`,
+		id,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return outbox.DeadLetter{}, outbox.ErrDeadLetterNotFound
+	}
+	return letter, err
+}
+
+func (r *OutboxRepository) Replay(id string) error {
+	return withTx(r.db, func(tx dbtx) error {
+		res, err := tx.Exec(
+			`INSERT INTO outbox_events
+			 (id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+			  correlation_id, causation_id, payload, created_at)
+			 SELECT id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+			        correlation_id, causation_id, payload, created_at
+			 FROM outbox_dead_letters
+			 WHERE id = ?`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return outbox.ErrDeadLetterNotFound
+		}
+
+		_, err = tx.Exec(`DELETE FROM outbox_dead_letters WHERE id = ?`, id)
+		return err
+	})
+}
+
+func (r *OutboxRepository) Discard(id string) error {
+	res, err := r.db.Exec(`DELETE FROM outbox_dead_letters WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return outbox.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+const deadLetterColumns = `id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+		        correlation_id, causation_id, payload, created_at, attempts, last_error, dead_at`
+
+func scanDeadLetter(row interface{ Scan(...any) error }) (outbox.DeadLetter, error) {
+	var letter outbox.DeadLetter
+
+	if err := row.Scan(
+		&letter.ID,
+		&letter.Type,
+		&letter.Version,
+		&letter.OccurredAt,
+		&letter.Aggregate.Type,
+		&letter.Aggregate.ID,
+		&letter.CorrelationID,
+		&letter.CausationID,
+		&letter.Payload,
+		&letter.CreatedAt,
+		&letter.Attempts,
+		&letter.LastError,
+		&letter.DeadAt,
+	); err != nil {
+		return outbox.DeadLetter{}, err
+	}
+
+	letter.OccurredAt = letter.OccurredAt.UTC()
+	letter.CreatedAt = letter.CreatedAt.UTC()
+	letter.DeadAt = letter.DeadAt.UTC()
+
+	return letter, nil
+}
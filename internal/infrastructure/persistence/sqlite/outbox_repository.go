@@ -1,97 +1,223 @@
 package sqlite
 
 import (
+	"cmp"
 	"database/sql"
-	"encoding/json"
+	"slices"
 	"time"
 
-	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
 	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
 )
 
+// OutboxRepository is the only SQL outbox store. Rows are keyed by the event
+// ID and dispatched in the order they were saved.
 type OutboxRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewOutboxRepository(db *sql.DB) *OutboxRepository {
 	return &OutboxRepository{db: db}
 }
 
-// Save(OutboxEvent) error
-// FindUnpublished(int) ([]OutboxEvent, error)
-// MarkPublished(string) error
-
 func (r *OutboxRepository) Save(evt outbox.OutboxEvent) error {
-	data, err := json.Marshal(evt.Payload)
-	if err != nil {
-		return err
-	}
-
-	_, err = r.db.Exec(
-		`INSERT INTO outbox_events (event_type, payload)
-		 VALUES (?, ?)`,
-		string(evt.Type),
-		data,
+	_, err := r.db.Exec(
+		`INSERT INTO outbox_events
+		 (id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+		  correlation_id, causation_id, payload, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		evt.ID,
+		evt.Type,
+		evt.Version,
+		evt.OccurredAt.UTC(),
+		evt.Aggregate.Type,
+		evt.Aggregate.ID,
+		evt.CorrelationID,
+		evt.CausationID,
+		evt.Payload,
+		evt.CreatedAt.UTC(),
 	)
 	return err
 }
 
 func (r *OutboxRepository) FindUnpublished(limit int) ([]outbox.OutboxEvent, error) {
 	rows, err := r.db.Query(
-		`SELECT id, event_type, payload
+		`SELECT `+outboxColumns+`, rowid
 		 FROM outbox_events
-		 WHERE published = 0
-		 ORDER BY id
+		 WHERE published_at IS NULL AND poisoned_at IS NULL
+		 ORDER BY created_at, rowid
 		 LIMIT ?`,
 		limit,
 	)
 	if err != nil {
 		return nil, err
 	}
+
+	found, err := scanOutboxRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return outboxEvents(found), nil
+}
+
+// Claim takes the lease in a single UPDATE, which SQLite serializes with
+// every other writer, so concurrent claims never share an event.
+func (r *OutboxRepository) Claim(owner string, now time.Time, lease time.Duration, limit int) ([]outbox.OutboxEvent, error) {
+	rows, err := r.db.Query(
+		`UPDATE outbox_events
+		 SET claimed_by = ?, lease_expires_at = ?
+		 WHERE id IN (
+		     SELECT id FROM outbox_events
+		     WHERE published_at IS NULL AND poisoned_at IS NULL
+		       AND (claimed_by = ? OR lease_expires_at IS NULL OR lease_expires_at <= ?)
+		       AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		     ORDER BY created_at, rowid
+		     LIMIT ?
+		 )
+		 RETURNING `+outboxColumns+`, rowid`,
+		owner,
+		now.Add(lease).UTC(),
+		owner,
+		now.UTC(),
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, err := scanOutboxRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING yields rows in no particular order
+	slices.SortFunc(claimed, func(a, b outboxRow) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.rowid, b.rowid))
+	})
+
+	return outboxEvents(claimed), nil
+}
+
+const outboxColumns = `id, event_type, version, occurred_at, aggregate_type, aggregate_id,
+		        correlation_id, causation_id, payload, created_at, claimed_by, lease_expires_at,
+		        attempts, next_attempt_at, COALESCE(last_error, '')`
+
+// outboxRow is an event with its rowid, which breaks ties between events
+// created at the same time.
+type outboxRow struct {
+	outbox.OutboxEvent
+	rowid int64
+}
+
+// scanOutboxRows reads rows selecting outboxColumns and the rowid, and closes
+// them.
+func scanOutboxRows(rows *sql.Rows) ([]outboxRow, error) {
 	defer rows.Close()
 
-	var events []outbox.OutboxEvent
-	var ids []string
+	var found []outboxRow
 
 	for rows.Next() {
 		var (
-			id      string
-			typ     string
-			payload []byte
+			row            outboxRow
+			leaseExpiresAt sql.NullTime
+			nextAttemptAt  sql.NullTime
 		)
 
-		if err := rows.Scan(&id, &typ, &payload); err != nil {
+		if err := rows.Scan(
+			&row.ID,
+			&row.Type,
+			&row.Version,
+			&row.OccurredAt,
+			&row.Aggregate.Type,
+			&row.Aggregate.ID,
+			&row.CorrelationID,
+			&row.CausationID,
+			&row.Payload,
+			&row.CreatedAt,
+			&row.ClaimedBy,
+			&leaseExpiresAt,
+			&row.Attempts,
+			&nextAttemptAt,
+			&row.LastError,
+			&row.rowid,
+		); err != nil {
 			return nil, err
 		}
 
-		evt := outbox.OutboxEvent{
-			ID:        id,
-			Type:      event.Type(typ),
-			Payload:   payload,
-			Published: false,
-			CreatedAt: time.Now(),
+		row.OccurredAt = row.OccurredAt.UTC()
+		row.CreatedAt = row.CreatedAt.UTC()
+		if leaseExpiresAt.Valid {
+			row.LeaseExpiresAt = leaseExpiresAt.Time.UTC()
+		}
+		if nextAttemptAt.Valid {
+			row.NextAttemptAt = nextAttemptAt.Time.UTC()
 		}
 
-		events = append(events, evt)
-		ids = append(ids, id)
+		found = append(found, row)
 	}
 
-	return events, nil
+	return found, rows.Err()
 }
 
-func (r *OutboxRepository) MarkPublished(id string) error {
-	tx, err := r.db.Begin()
+func outboxEvents(rows []outboxRow) []outbox.OutboxEvent {
+	events := make([]outbox.OutboxEvent, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, row.OutboxEvent)
+	}
+	return events
+}
+
+func (r *OutboxRepository) MarkPublished(id string, owner string) error {
+	res, err := r.db.Exec(
+		`UPDATE outbox_events SET published_at = ?
+		 WHERE id = ? AND claimed_by = ? AND published_at IS NULL`,
+		time.Now().UTC(),
+		id,
+		owner,
+	)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	return leaseHeld(res)
+}
 
-	if _, err := tx.Exec(
-		`UPDATE outbox_events SET published = 1 WHERE id = ?`,
+func (r *OutboxRepository) MarkPoisoned(id string, reason string) error {
+	_, err := r.db.Exec(
+		`UPDATE outbox_events SET poisoned_at = ?, last_error = ? WHERE id = ?`,
+		time.Now().UTC(),
+		reason,
 		id,
-	); err != nil {
+	)
+	return err
+}
+
+func (r *OutboxRepository) MarkFailed(id string, owner string, reason string, nextAttemptAt time.Time) error {
+	res, err := r.db.Exec(
+		`UPDATE outbox_events
+		 SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?,
+		     claimed_by = '', lease_expires_at = NULL
+		 WHERE id = ? AND claimed_by = ? AND published_at IS NULL`,
+		reason,
+		nextAttemptAt.UTC(),
+		id,
+		owner,
+	)
+	if err != nil {
 		return err
 	}
+	return leaseHeld(res)
+}
 
-	return tx.Commit()
+// leaseHeld tells a write that matched no row, because the event is gone or
+// claimed by someone else, apart from one that went through.
+func leaseHeld(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return outbox.ErrLeaseLost
+	}
+	return nil
 }
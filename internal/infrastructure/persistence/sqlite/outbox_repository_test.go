@@ -0,0 +1,14 @@
+package sqlite_test
+
+import (
+	"testing"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox/outboxtest"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func TestOutboxRepository(t *testing.T) {
+	outboxtest.Run(t, func(t *testing.T) outboxtest.Store {
+		return sqlite.NewOutboxRepository(setupDB(t))
+	})
+}
@@ -4,13 +4,17 @@ import (
 	"database/sql"
 	"errors"
 
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
 	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
 )
 
-var ErrPaymentNotFound = errors.New("payment not found")
+var (
+	ErrPaymentNotFound = payment.ErrNotFound
+	ErrAttemptNotFound = errors.New("payment attempt not found")
+)
 
 type PaymentRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewPaymentRepository(db *sql.DB) *PaymentRepository {
@@ -20,10 +24,12 @@ func NewPaymentRepository(db *sql.DB) *PaymentRepository {
 func (r *PaymentRepository) Save(p *payment.Payment) error {
 	_, err := r.db.Exec(
 		`INSERT INTO payments
-		 (id, invoice_id, attempt, status, idempotency_key)
-		 VALUES (?, ?, ?, ?, ?)`,
+		 (id, invoice_id, amount, currency, attempt, status, idempotency_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		p.ID,
 		p.InvoiceID,
+		p.Amount.Amount,
+		string(p.Amount.Currency),
 		p.Attempt,
 		string(p.Status),
 		p.IdempotencyKey,
@@ -34,10 +40,12 @@ func (r *PaymentRepository) Save(p *payment.Payment) error {
 func (r *PaymentRepository) SaveIfNotExist(p *payment.Payment) (bool, error) {
 	res, err := r.db.Exec(
 		`INSERT OR IGNORE INTO payments
-		 (id, invoice_id, attempt, status, idempotency_key)
-		 VALUES (?, ?, ?, ?, ?)`,
+		 (id, invoice_id, amount, currency, attempt, status, idempotency_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		p.ID,
 		p.InvoiceID,
+		p.Amount.Amount,
+		string(p.Amount.Currency),
 		p.Attempt,
 		string(p.Status),
 		p.IdempotencyKey,
@@ -55,30 +63,79 @@ func (r *PaymentRepository) SaveIfNotExist(p *payment.Payment) (bool, error) {
 	return affected == 1, nil
 }
 
+func (r *PaymentRepository) FindByID(id string) (*payment.Payment, error) {
+	return r.findOne(
+		`SELECT id, invoice_id, amount, currency, attempt, status, idempotency_key
+		 FROM payments
+		 WHERE id = ?`,
+		id,
+	)
+}
+
 func (r *PaymentRepository) FindByIdempotencyKey(key string) (*payment.Payment, error) {
-	row := r.db.QueryRow(
-		`SELECT id, invoice_id, attempt, status, idempotency_key
+	return r.findOne(
+		`SELECT id, invoice_id, amount, currency, attempt, status, idempotency_key
 		 FROM payments
 		 WHERE idempotency_key = ?`,
 		key,
 	)
+}
+
+func (r *PaymentRepository) FindByInvoiceID(invoiceID string) ([]*payment.Payment, error) {
+	rows, err := r.db.Query(
+		`SELECT id, invoice_id, amount, currency, attempt, status, idempotency_key
+		 FROM payments
+		 WHERE invoice_id = ?
+		 ORDER BY rowid`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*payment.Payment
+
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+
+	return payments, rows.Err()
+}
+
+func (r *PaymentRepository) findOne(query string, args ...any) (*payment.Payment, error) {
+	p, err := scanPayment(r.db.QueryRow(query, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, err
+	}
 
+	return p, nil
+}
+
+func scanPayment(row rowScanner) (*payment.Payment, error) {
 	var p payment.Payment
-	var status string
+	var currency, status string
 
 	if err := row.Scan(
 		&p.ID,
 		&p.InvoiceID,
+		&p.Amount.Amount,
+		&currency,
 		&p.Attempt,
 		&status,
 		&p.IdempotencyKey,
 	); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrPaymentNotFound
-		}
 		return nil, err
 	}
 
+	p.Amount.Currency = money.Currency(currency)
 	p.Status = payment.Status(status)
 	return &p, nil
 }
@@ -105,3 +162,120 @@ func (r *PaymentRepository) UpdateStatus(id string, newStatus payment.Status) er
 
 	return nil
 }
+
+func (r *PaymentRepository) SaveAttemptIfNotExist(a *payment.Attempt) (bool, error) {
+	var saved bool
+	err := withTx(r.db, func(tx dbtx) error {
+		var err error
+		saved, err = saveAttemptIfNotExist(tx, a)
+		return err
+	})
+	return saved, err
+}
+
+func saveAttemptIfNotExist(tx dbtx, a *payment.Attempt) (bool, error) {
+	res, err := tx.Exec(
+		`INSERT OR IGNORE INTO payment_attempts
+		 (payment_id, invoice_id, number, status, idempotency_key, decline_code, gateway_reference, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.PaymentID,
+		a.InvoiceID,
+		a.Number,
+		string(a.Status),
+		a.IdempotencyKey,
+		string(a.DeclineCode),
+		a.GatewayReference,
+		a.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE payments
+		 SET attempt = ?
+		 WHERE id = ? AND attempt < ?`,
+		a.Number,
+		a.PaymentID,
+		a.Number,
+	); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *PaymentRepository) UpdateAttempt(a *payment.Attempt) error {
+	res, err := r.db.Exec(
+		`UPDATE payment_attempts
+		 SET status = ?, decline_code = ?, gateway_reference = ?
+		 WHERE payment_id = ? AND number = ?`,
+		string(a.Status),
+		string(a.DeclineCode),
+		a.GatewayReference,
+		a.PaymentID,
+		a.Number,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAttemptNotFound
+	}
+
+	return nil
+}
+
+func (r *PaymentRepository) FindAttempts(paymentID string) ([]*payment.Attempt, error) {
+	rows, err := r.db.Query(
+		`SELECT payment_id, invoice_id, number, status, idempotency_key, decline_code, gateway_reference, created_at
+		 FROM payment_attempts
+		 WHERE payment_id = ?
+		 ORDER BY number`,
+		paymentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*payment.Attempt
+
+	for rows.Next() {
+		var a payment.Attempt
+		var status, declineCode string
+
+		if err := rows.Scan(
+			&a.PaymentID,
+			&a.InvoiceID,
+			&a.Number,
+			&status,
+			&a.IdempotencyKey,
+			&declineCode,
+			&a.GatewayReference,
+			&a.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		a.Status = payment.Status(status)
+		a.DeclineCode = payment.DeclineCode(declineCode)
+		a.CreatedAt = a.CreatedAt.UTC()
+		attempts = append(attempts, &a)
+	}
+
+	return attempts, rows.Err()
+}
@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/refund"
+)
+
+var ErrRefundNotFound = errors.New("refund not found")
+
+type RefundRepository struct {
+	db dbtx
+}
+
+func NewRefundRepository(db *sql.DB) *RefundRepository {
+	return &RefundRepository{db: db}
+}
+
+func (r *RefundRepository) Save(ref *refund.Refund) error {
+	_, err := r.db.Exec(
+		`INSERT INTO refunds
+		 (id, payment_id, invoice_id, amount, currency, reason, status, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		ref.ID,
+		ref.PaymentID,
+		ref.InvoiceID,
+		ref.Amount.Amount,
+		string(ref.Amount.Currency),
+		ref.Reason,
+		string(ref.Status),
+	)
+	return err
+}
+
+func (r *RefundRepository) FindByID(id string) (*refund.Refund, error) {
+	refunds, err := r.find(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(refunds) == 0 {
+		return nil, ErrRefundNotFound
+	}
+
+	return refunds[0], nil
+}
+
+func (r *RefundRepository) FindByPaymentID(paymentID string) ([]*refund.Refund, error) {
+	return r.find(`WHERE payment_id = ?`, paymentID)
+}
+
+func (r *RefundRepository) FindByInvoiceID(invoiceID string) ([]*refund.Refund, error) {
+	return r.find(`WHERE invoice_id = ?`, invoiceID)
+}
+
+func (r *RefundRepository) UpdateStatus(id string, from, to refund.Status) error {
+	res, err := r.db.Exec(
+		`UPDATE refunds
+		 SET status = ?
+		 WHERE id = ? AND status = ?`,
+		string(to),
+		id,
+		string(from),
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := r.FindByID(id); err != nil {
+			return err
+		}
+		return refund.ErrConcurrentUpdate
+	}
+
+	return nil
+}
+
+func (r *RefundRepository) find(where string, args ...any) ([]*refund.Refund, error) {
+	rows, err := r.db.Query(
+		`SELECT id, payment_id, invoice_id, amount, currency, reason, status
+		 FROM refunds
+		 `+where+`
+		 ORDER BY created_at, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []*refund.Refund
+
+	for rows.Next() {
+		var ref refund.Refund
+		var currency, status string
+
+		if err := rows.Scan(
+			&ref.ID,
+			&ref.PaymentID,
+			&ref.InvoiceID,
+			&ref.Amount.Amount,
+			&currency,
+			&ref.Reason,
+			&status,
+		); err != nil {
+			return nil, err
+		}
+
+		ref.Amount.Currency = money.Currency(currency)
+		ref.Status = refund.Status(status)
+		refunds = append(refunds, &ref)
+	}
+
+	return refunds, rows.Err()
+}
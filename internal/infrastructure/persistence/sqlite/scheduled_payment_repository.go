@@ -0,0 +1,96 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+type ScheduledPaymentRepository struct {
+	db dbtx
+}
+
+func NewScheduledPaymentRepository(db *sql.DB) *ScheduledPaymentRepository {
+	return &ScheduledPaymentRepository{db: db}
+}
+
+func (r *ScheduledPaymentRepository) Save(s *payment.ScheduledPayment) error {
+	_, err := r.db.Exec(
+		`INSERT INTO scheduled_payments
+		 (id, invoice_id, merchant_id, payment_id, amount, currency, payment_method, attempt, delay, run_at,
+		  correlation_id, causation_id)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.ID,
+		s.InvoiceID,
+		s.MerchantID,
+		s.PaymentID,
+		s.Amount.Amount,
+		string(s.Amount.Currency),
+		s.PaymentMethod,
+		s.Attempt,
+		int64(s.Delay),
+		s.RunAt.UTC(),
+		s.CorrelationID,
+		s.CausationID,
+	)
+	return err
+}
+
+func (r *ScheduledPaymentRepository) FindDue(now time.Time, limit int) ([]*payment.ScheduledPayment, error) {
+	rows, err := r.db.Query(
+		`SELECT id, invoice_id, merchant_id, payment_id, amount, currency, payment_method, attempt, delay, run_at,
+		        correlation_id, causation_id
+		 FROM scheduled_payments
+		 WHERE run_at <= ?
+		 ORDER BY run_at, id
+		 LIMIT ?`,
+		now.UTC(),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*payment.ScheduledPayment
+
+	for rows.Next() {
+		var s payment.ScheduledPayment
+		var currency string
+
+		if err := rows.Scan(
+			&s.ID,
+			&s.InvoiceID,
+			&s.MerchantID,
+			&s.PaymentID,
+			&s.Amount.Amount,
+			&currency,
+			&s.PaymentMethod,
+			&s.Attempt,
+			&s.Delay,
+			&s.RunAt,
+			&s.CorrelationID,
+			&s.CausationID,
+		); err != nil {
+			return nil, err
+		}
+
+		s.Amount.Currency = money.Currency(currency)
+		s.RunAt = s.RunAt.UTC()
+		due = append(due, &s)
+	}
+
+	return due, rows.Err()
+}
+
+func (r *ScheduledPaymentRepository) Delete(id string) error {
+	_, err := r.db.Exec(`DELETE FROM scheduled_payments WHERE id = ?`, id)
+	return err
+}
+
+func (r *ScheduledPaymentRepository) DeleteByInvoiceID(invoiceID string) error {
+	_, err := r.db.Exec(`DELETE FROM scheduled_payments WHERE invoice_id = ?`, invoiceID)
+	return err
+}
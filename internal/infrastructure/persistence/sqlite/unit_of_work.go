@@ -0,0 +1,38 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/outbox"
+)
+
+// UnitOfWork runs invoice, payment, refund, retry and outbox writes in one
+// *sql.Tx.
+type UnitOfWork struct {
+	db *sql.DB
+}
+
+func NewUnitOfWork(db *sql.DB) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+func (u *UnitOfWork) Do(fn func(contracts.Repositories) error) error {
+	tx, err := u.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(contracts.Repositories{
+		Invoices:  &InvoiceRepository{db: tx},
+		Payments:  &PaymentRepository{db: tx},
+		Refunds:   &RefundRepository{db: tx},
+		Scheduled: &ScheduledPaymentRepository{db: tx},
+		Events:    &outbox.Recorder{Repo: &OutboxRepository{db: tx}},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,132 @@
+package sqlite_test
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/contracts"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/event"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/invoice"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/persistence/sqlite"
+)
+
+func setupDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, sqlite.RunMigrations(db))
+	return db
+}
+
+func setupUnitOfWork(t *testing.T) (*sqlite.UnitOfWork, *sql.DB) {
+	t.Helper()
+
+	db := setupDB(t)
+	now := time.Now()
+
+	inv, err := invoice.New("inv-1", money.BRL, []invoice.LineItem{{
+		Description: "plan",
+		Quantity:    1,
+		UnitPrice:   money.Money{Amount: 100, Currency: money.BRL},
+	}}, now, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, sqlite.NewInvoiceRepository(db).Save(inv))
+
+	require.NoError(t, sqlite.NewPaymentRepository(db).Save(&payment.Payment{
+		ID:             "pay-1",
+		InvoiceID:      "inv-1",
+		Amount:         money.Money{Amount: 100, Currency: money.BRL},
+		Status:         payment.StatusCreated,
+		IdempotencyKey: "payment:inv-1",
+	}))
+
+	return sqlite.NewUnitOfWork(db), db
+}
+
+func changeEverything(tx contracts.Repositories) error {
+	if err := tx.Invoices.UpdateStatus("inv-1", invoice.StatusPending, invoice.StatusProcessing); err != nil {
+		return err
+	}
+	if _, err := tx.Payments.SaveAttemptIfNotExist(&payment.Attempt{
+		PaymentID:      "pay-1",
+		InvoiceID:      "inv-1",
+		Number:         1,
+		Status:         payment.StatusProcessing,
+		IdempotencyKey: "payment:inv-1:attempt:1",
+		CreatedAt:      time.Now(),
+	}); err != nil {
+		return err
+	}
+	if err := tx.Payments.UpdateStatus("pay-1", payment.StatusProcessing); err != nil {
+		return err
+	}
+	return tx.Events.Record(event.New(event.PaymentRequested, event.Invoice("inv-1"), event.PaymentRequestPayload{InvoiceID: "inv-1"}))
+}
+
+func countOutboxEvents(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	var count int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM outbox_events`).Scan(&count))
+	return count
+}
+
+func TestUnitOfWork_ShouldCommitEveryChange(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+
+	require.NoError(t, uow.Do(changeEverything))
+
+	inv, err := sqlite.NewInvoiceRepository(db).FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusProcessing, inv.Status)
+
+	payments := sqlite.NewPaymentRepository(db)
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusProcessing, pay.Status)
+	require.Equal(t, 1, pay.Attempt)
+
+	attempts, err := payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+
+	require.Equal(t, 1, countOutboxEvents(t, db))
+}
+
+func TestUnitOfWork_ShouldRollBackEveryChangeOnError(t *testing.T) {
+	uow, db := setupUnitOfWork(t)
+	errBoom := errors.New("boom")
+
+	err := uow.Do(func(tx contracts.Repositories) error {
+		if err := changeEverything(tx); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	require.ErrorIs(t, err, errBoom)
+
+	inv, err := sqlite.NewInvoiceRepository(db).FindByID("inv-1")
+	require.NoError(t, err)
+	require.Equal(t, invoice.StatusPending, inv.Status)
+
+	payments := sqlite.NewPaymentRepository(db)
+	pay, err := payments.FindByID("pay-1")
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusCreated, pay.Status)
+
+	attempts, err := payments.FindAttempts("pay-1")
+	require.NoError(t, err)
+	require.Empty(t, attempts)
+
+	require.Zero(t, countOutboxEvents(t, db))
+}
@@ -0,0 +1,71 @@
+package fakepsp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type OutcomeKind string
+
+const (
+	OutcomeApprove OutcomeKind = "approve"
+	OutcomeDecline OutcomeKind = "decline"
+	OutcomeError   OutcomeKind = "error"
+	// OutcomeTimeout holds the request until the client gives up.
+	OutcomeTimeout OutcomeKind = "timeout"
+)
+
+type Outcome struct {
+	Kind        OutcomeKind
+	DeclineCode string
+	HTTPStatus  int
+}
+
+// ParseOutcome reads outcomes written as "approve", "decline:<code>",
+// "error:<http status>" or "timeout".
+func ParseOutcome(s string) (Outcome, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(s), ":")
+
+	switch OutcomeKind(kind) {
+	case OutcomeApprove, OutcomeTimeout:
+		return Outcome{Kind: OutcomeKind(kind)}, nil
+
+	case OutcomeDecline:
+		if arg == "" {
+			arg = "generic_decline"
+		}
+		return Outcome{Kind: OutcomeDecline, DeclineCode: arg}, nil
+
+	case OutcomeError:
+		status := http.StatusInternalServerError
+		if arg != "" {
+			var err error
+			if status, err = strconv.Atoi(arg); err != nil || status < 400 || status > 599 {
+				return Outcome{}, fmt.Errorf("invalid error status %q", arg)
+			}
+		}
+		return Outcome{Kind: OutcomeError, HTTPStatus: status}, nil
+	}
+
+	return Outcome{}, fmt.Errorf("unknown outcome %q", s)
+}
+
+// ParseScript reads a comma separated list of outcomes.
+func ParseScript(s string) ([]Outcome, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var script []Outcome
+	for part := range strings.SplitSeq(s, ",") {
+		outcome, err := ParseOutcome(part)
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, outcome)
+	}
+
+	return script, nil
+}
@@ -0,0 +1,336 @@
+// Package fakepsp is a stand-in payment service provider speaking the same
+// protocol as psp.HTTPExecutor, for local end-to-end runs and tests.
+package fakepsp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/psp"
+)
+
+// randomDeclines are picked when a charge fails the success rate draw.
+var randomDeclines = []string{
+	"insufficient_funds",
+	"do_not_honor",
+	"processing_error",
+	"expired_card",
+	"stolen_card",
+}
+
+type Config struct {
+	// SuccessRate is the share of unscripted charges that are approved.
+	// Unscripted refunds of approved charges are always approved.
+	SuccessRate float64
+	Latency     time.Duration
+	// Jitter adds up to this much random latency on top of Latency.
+	Jitter time.Duration
+	// Script is played in order, one outcome per new charge or refund,
+	// before falling back to SuccessRate.
+	Script []Outcome
+	// APIKey, when set, is required as a bearer token.
+	APIKey string
+	Seed   int64
+}
+
+// entry is the request holding an idempotency key. Its response is set
+// before done is closed; a request that ends without one, on an error or a
+// timeout, removes the entry so the key may be retried.
+type entry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	settled     bool
+	status      int
+	body        any
+}
+
+// charge is an approved charge, which refunds may return up to its amount.
+type charge struct {
+	amount   int64
+	currency string
+	refunded int64
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+// Server replays the stored response when an Idempotency-Key is reused with
+// the same request, and answers 409 when the request differs. Requests
+// reusing a key still being processed wait for its outcome.
+type Server struct {
+	cfg Config
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	script  []Outcome
+	entries map[string]*entry
+	charged map[string]*charge
+	seq     atomic.Int64
+	charges atomic.Int64
+	refunds atomic.Int64
+}
+
+func New(cfg Config) *Server {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &Server{
+		cfg:     cfg,
+		rand:    rand.New(rand.NewSource(seed)),
+		script:  append([]Outcome(nil), cfg.Script...),
+		entries: make(map[string]*entry),
+		charged: make(map[string]*charge),
+	}
+}
+
+// Charges returns how many charges reached an outcome, replays excluded.
+func (s *Server) Charges() int {
+	return int(s.charges.Load())
+}
+
+// Refunds returns how many refunds reached an outcome, replays excluded.
+func (s *Server) Refunds() int {
+	return int(s.refunds.Load())
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var handle func(http.ResponseWriter, *http.Request, string)
+	switch r.URL.Path {
+	case psp.ChargesPath:
+		handle = s.charge
+	case psp.RefundsPath:
+		handle = s.refund
+	}
+	if r.Method != http.MethodPost || handle == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.cfg.APIKey != "" && r.Header.Get("Authorization") != "Bearer "+s.cfg.APIKey {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Message: "invalid api key"})
+		return
+	}
+
+	key := strings.TrimSpace(r.Header.Get(psp.IdempotencyKeyHeader))
+	if key == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "idempotency key is required"})
+		return
+	}
+
+	handle(w, r, key)
+}
+
+func (s *Server) charge(w http.ResponseWriter, r *http.Request, key string) {
+	var req psp.ChargeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "invalid request body"})
+		return
+	}
+	if req.Amount <= 0 || req.Currency == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "amount and currency are required"})
+		return
+	}
+
+	// the attempt number is bookkeeping on the caller's side rather than
+	// part of the charge
+	fingerprint := req
+	fingerprint.Attempt = 0
+
+	s.serve(w, r, key, fingerprintOf(fingerprint), func(outcome Outcome) (int, any) {
+		s.charges.Add(1)
+
+		resp := psp.ChargeResponse{
+			ID:     fmt.Sprintf("ch_%d", s.seq.Add(1)),
+			Status: psp.StatusApproved,
+		}
+		if outcome.Kind == OutcomeDecline {
+			resp.Status = psp.StatusDeclined
+			resp.DeclineCode = outcome.DeclineCode
+			resp.Message = "charge declined"
+			return http.StatusPaymentRequired, resp
+		}
+
+		s.mu.Lock()
+		s.charged[resp.ID] = &charge{amount: req.Amount, currency: req.Currency}
+		s.mu.Unlock()
+
+		return http.StatusOK, resp
+	})
+}
+
+func (s *Server) refund(w http.ResponseWriter, r *http.Request, key string) {
+	var req psp.RefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "invalid request body"})
+		return
+	}
+	if req.ChargeID == "" || req.Amount <= 0 || req.Currency == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Message: "charge, amount and currency are required"})
+		return
+	}
+
+	s.serve(w, r, key, fingerprintOf(req), func(outcome Outcome) (int, any) {
+		s.refunds.Add(1)
+
+		resp := psp.RefundResponse{
+			ID:     fmt.Sprintf("re_%d", s.seq.Add(1)),
+			Status: psp.StatusDeclined,
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		c, ok := s.charged[req.ChargeID]
+		switch {
+		case outcome.Kind == OutcomeDecline:
+			resp.Message = "refund declined"
+		case !ok:
+			resp.Message = "charge not found"
+		case c.currency != req.Currency:
+			resp.Message = "currency does not match the charge"
+		case c.refunded+req.Amount > c.amount:
+			resp.Message = "amount exceeds what is left of the charge"
+		default:
+			c.refunded += req.Amount
+			resp.Status = psp.StatusApproved
+			return http.StatusOK, resp
+		}
+		return http.StatusPaymentRequired, resp
+	})
+}
+
+// serve settles the request holding key with the next outcome, or replays
+// the response of the request that held it before. Keys are scoped to the
+// endpoint.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, key string, fingerprint [sha256.Size]byte, settle func(Outcome) (int, any)) {
+	key = r.URL.Path + " " + key
+
+	e, claimed := s.reserve(r, key, fingerprint)
+	if e == nil {
+		return
+	}
+	if !claimed {
+		if e.fingerprint != fingerprint {
+			writeJSON(w, http.StatusConflict, errorResponse{Message: "idempotency key reused with a different request"})
+			return
+		}
+		writeJSON(w, e.status, e.body)
+		return
+	}
+	defer s.finish(key, e)
+
+	s.mu.Lock()
+	outcome := s.nextOutcome(r.URL.Path)
+	delay := s.delay()
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	switch outcome.Kind {
+	case OutcomeTimeout:
+		<-r.Context().Done()
+		return
+	case OutcomeError:
+		writeJSON(w, outcome.HTTPStatus, errorResponse{Message: http.StatusText(outcome.HTTPStatus)})
+		return
+	}
+
+	e.status, e.body = settle(outcome)
+	e.settled = true
+
+	writeJSON(w, e.status, e.body)
+}
+
+// reserve claims key for r, or returns the entry of the request holding it
+// once that request has settled. A different request reusing the key gets
+// the entry straight away. The entry is nil if r gave up waiting.
+func (s *Server) reserve(r *http.Request, key string, fingerprint [sha256.Size]byte) (e *entry, claimed bool) {
+	for {
+		s.mu.Lock()
+		e, ok := s.entries[key]
+		if !ok {
+			e = &entry{fingerprint: fingerprint, done: make(chan struct{})}
+			s.entries[key] = e
+		}
+		s.mu.Unlock()
+
+		if !ok {
+			return e, true
+		}
+		if e.fingerprint != fingerprint {
+			return e, false
+		}
+
+		select {
+		case <-e.done:
+		case <-r.Context().Done():
+			return nil, false
+		}
+		if e.settled {
+			return e, false
+		}
+	}
+}
+
+// finish wakes the requests waiting on e. Only settled requests are
+// remembered; errors and timeouts may be retried with the same key.
+func (s *Server) finish(key string, e *entry) {
+	if !e.settled {
+		s.mu.Lock()
+		delete(s.entries, key)
+		s.mu.Unlock()
+	}
+	close(e.done)
+}
+
+// nextOutcome must be called with s.mu held.
+func (s *Server) nextOutcome(path string) Outcome {
+	if len(s.script) > 0 {
+		outcome := s.script[0]
+		s.script = s.script[1:]
+		return outcome
+	}
+
+	if path == psp.RefundsPath || s.rand.Float64() < s.cfg.SuccessRate {
+		return Outcome{Kind: OutcomeApprove}
+	}
+
+	return Outcome{
+		Kind:        OutcomeDecline,
+		DeclineCode: randomDeclines[s.rand.Intn(len(randomDeclines))],
+	}
+}
+
+// delay must be called with s.mu held.
+func (s *Server) delay() time.Duration {
+	delay := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		delay += time.Duration(s.rand.Int63n(int64(s.cfg.Jitter)))
+	}
+	return delay
+}
+
+func fingerprintOf(req any) [sha256.Size]byte {
+	raw, _ := json.Marshal(req)
+	return sha256.Sum256(raw)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
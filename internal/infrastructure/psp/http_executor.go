@@ -0,0 +1,230 @@
+package psp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+)
+
+// pspDeclineCodes maps the PSP vocabulary to our decline codes. Codes
+// missing here are kept verbatim and treated as non-retryable.
+var pspDeclineCodes = map[string]payment.DeclineCode{
+	"insufficient_funds": payment.DeclineInsufficientFunds,
+	"do_not_honor":       payment.DeclineDoNotHonor,
+	"generic_decline":    payment.DeclineDoNotHonor,
+	"processing_error":   payment.DeclineProcessingError,
+	"try_again_later":    payment.DeclineProcessingError,
+	"stolen_card":        payment.DeclineCardStolen,
+	"lost_card":          payment.DeclineLostCard,
+	"expired_card":       payment.DeclineExpiredCard,
+	"incorrect_number":   payment.DeclineInvalidCard,
+	"invalid_account":    payment.DeclineInvalidCard,
+	"fraudulent":         payment.DeclineFraudSuspected,
+}
+
+type HTTPExecutor struct {
+	BaseURL string
+	APIKey  string
+	Client  *http.Client
+	// Timeout bounds each charge on top of the caller's context deadline.
+	Timeout time.Duration
+}
+
+func NewHTTPExecutor(baseURL, apiKey string, timeout time.Duration) *HTTPExecutor {
+	return &HTTPExecutor{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		APIKey:  apiKey,
+		Client:  &http.Client{},
+		Timeout: timeout,
+	}
+}
+
+func (e *HTTPExecutor) Execute(ctx context.Context, req worker.PaymentRequest) (worker.GatewayResult, error) {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(ChargeRequest{
+		PaymentID:     req.PaymentID,
+		InvoiceID:     req.InvoiceID,
+		Amount:        req.Amount.Amount,
+		Currency:      string(req.Amount.Currency),
+		PaymentMethod: req.PaymentMethod,
+		Attempt:       req.Attempt,
+	})
+	if err != nil {
+		return worker.GatewayResult{}, err
+	}
+
+	status, raw, err := e.post(ctx, ChargesPath, req.IdempotencyKey, body)
+	if err != nil {
+		return worker.GatewayResult{}, err
+	}
+
+	return mapResponse(status, raw)
+}
+
+// Refunds returns the executor refunding the charges made through e.
+func (e *HTTPExecutor) Refunds() *HTTPRefundExecutor {
+	return &HTTPRefundExecutor{charges: e}
+}
+
+// post sends body to the PSP and returns the status and body of its
+// response.
+func (e *HTTPExecutor) post(ctx context.Context, path, idempotencyKey string, body []byte) (int, []byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	if e.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	resp, err := e.client().Do(httpReq)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, raw, nil
+}
+
+func (e *HTTPExecutor) client() *http.Client {
+	if e.Client == nil {
+		return http.DefaultClient
+	}
+	return e.Client
+}
+
+// mapResponse turns PSP responses into gateway results. Server errors and
+// rate limiting leave the charge outcome unknown and are returned as errors
+// so the attempt is retried with the same idempotency key.
+func mapResponse(status int, raw []byte) (worker.GatewayResult, error) {
+	if unknownOutcome(status) {
+		return worker.GatewayResult{}, fmt.Errorf("psp responded %d: %s", status, strings.TrimSpace(string(raw)))
+	}
+
+	var charge ChargeResponse
+	if err := json.Unmarshal(raw, &charge); err != nil && status != http.StatusConflict {
+		return worker.GatewayResult{}, fmt.Errorf("invalid psp response (%d): %w", status, err)
+	}
+
+	switch {
+	case status == http.StatusOK && charge.Status == StatusApproved:
+		return worker.GatewayResult{
+			Approved:         true,
+			GatewayReference: charge.ID,
+		}, nil
+
+	case status == http.StatusPaymentRequired || charge.Status == StatusDeclined:
+		code, ok := pspDeclineCodes[charge.DeclineCode]
+		if !ok {
+			code = payment.DeclineCode(charge.DeclineCode)
+		}
+		return worker.GatewayResult{
+			GatewayReference: charge.ID,
+			DeclineCode:      code,
+			Message:          charge.Message,
+			Retryable:        code.Retryable(),
+		}, nil
+
+	case status == http.StatusConflict:
+		return worker.GatewayResult{
+			DeclineCode: payment.DeclineProcessingError,
+			Message:     "idempotency key reused with a different request",
+		}, nil
+	}
+
+	return worker.GatewayResult{
+		GatewayReference: charge.ID,
+		DeclineCode:      payment.DeclineProcessingError,
+		Message:          fmt.Sprintf("unexpected psp response %d: %s", status, charge.Message),
+	}, nil
+}
+
+func unknownOutcome(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// HTTPRefundExecutor refunds charges through the PSP they were made with.
+type HTTPRefundExecutor struct {
+	charges *HTTPExecutor
+}
+
+func (e *HTTPRefundExecutor) Execute(ctx context.Context, req worker.RefundRequest) (worker.RefundResult, error) {
+	if e.charges.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.charges.Timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(RefundRequest{
+		RefundID: req.RefundID,
+		ChargeID: req.ChargeReference,
+		Amount:   req.Amount.Amount,
+		Currency: string(req.Amount.Currency),
+	})
+	if err != nil {
+		return worker.RefundResult{}, err
+	}
+
+	status, raw, err := e.charges.post(ctx, RefundsPath, req.IdempotencyKey, body)
+	if err != nil {
+		return worker.RefundResult{}, err
+	}
+
+	return mapRefundResponse(status, raw)
+}
+
+// mapRefundResponse turns PSP responses into refund results, leaving the
+// outcome unknown on the same responses as mapResponse.
+func mapRefundResponse(status int, raw []byte) (worker.RefundResult, error) {
+	if unknownOutcome(status) {
+		return worker.RefundResult{}, fmt.Errorf("psp responded %d: %s", status, strings.TrimSpace(string(raw)))
+	}
+
+	var refund RefundResponse
+	if err := json.Unmarshal(raw, &refund); err != nil && status != http.StatusConflict {
+		return worker.RefundResult{}, fmt.Errorf("invalid psp response (%d): %w", status, err)
+	}
+
+	switch {
+	case status == http.StatusOK && refund.Status == StatusApproved:
+		return worker.RefundResult{
+			Approved:         true,
+			GatewayReference: refund.ID,
+		}, nil
+
+	case status == http.StatusPaymentRequired || refund.Status == StatusDeclined:
+		return worker.RefundResult{
+			GatewayReference: refund.ID,
+			Message:          refund.Message,
+		}, nil
+
+	case status == http.StatusConflict:
+		return worker.RefundResult{
+			Message: "idempotency key reused with a different request",
+		}, nil
+	}
+
+	return worker.RefundResult{
+		GatewayReference: refund.ID,
+		Message:          fmt.Sprintf("unexpected psp response %d: %s", status, refund.Message),
+	}, nil
+}
@@ -0,0 +1,197 @@
+package psp_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/rcarvalho-pb/payment_system-go/internal/application/worker"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/money"
+	"github.com/rcarvalho-pb/payment_system-go/internal/domain/payment"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/psp"
+	"github.com/rcarvalho-pb/payment_system-go/internal/infrastructure/psp/fakepsp"
+)
+
+func newExecutor(t *testing.T, script string) (*psp.HTTPExecutor, *fakepsp.Server) {
+	t.Helper()
+
+	outcomes, err := fakepsp.ParseScript(script)
+	require.NoError(t, err)
+
+	fake := fakepsp.New(fakepsp.Config{Script: outcomes, APIKey: "secret", Seed: 1})
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return psp.NewHTTPExecutor(server.URL, "secret", 200*time.Millisecond), fake
+}
+
+func chargeRequest(key string) worker.PaymentRequest {
+	return worker.PaymentRequest{
+		PaymentID:      "pay-1",
+		InvoiceID:      "inv-1",
+		Amount:         money.Money{Amount: 1000, Currency: money.BRL},
+		IdempotencyKey: key,
+		PaymentMethod:  "card",
+		Attempt:        1,
+	}
+}
+
+func TestHTTPExecutorMapsResponses(t *testing.T) {
+	exec, _ := newExecutor(t, "approve,decline:insufficient_funds,decline:stolen_card,decline:weird_code")
+
+	approved, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.NoError(t, err)
+	require.True(t, approved.Approved)
+	require.NotEmpty(t, approved.GatewayReference)
+
+	soft, err := exec.Execute(context.Background(), chargeRequest("k2"))
+	require.NoError(t, err)
+	require.False(t, soft.Approved)
+	require.Equal(t, payment.DeclineInsufficientFunds, soft.DeclineCode)
+	require.True(t, soft.Retryable)
+
+	hard, err := exec.Execute(context.Background(), chargeRequest("k3"))
+	require.NoError(t, err)
+	require.Equal(t, payment.DeclineCardStolen, hard.DeclineCode)
+	require.False(t, hard.Retryable)
+
+	unknown, err := exec.Execute(context.Background(), chargeRequest("k4"))
+	require.NoError(t, err)
+	require.Equal(t, payment.DeclineCode("weird_code"), unknown.DeclineCode)
+	require.False(t, unknown.Retryable)
+}
+
+func TestHTTPExecutorReturnsErrorsForUnknownOutcomes(t *testing.T) {
+	exec, _ := newExecutor(t, "error:503,error:429,timeout")
+
+	for range 3 {
+		_, err := exec.Execute(context.Background(), chargeRequest("k1"))
+		require.Error(t, err)
+	}
+}
+
+func TestHTTPExecutorReplaysIdempotentCharges(t *testing.T) {
+	exec, fake := newExecutor(t, "error:500,approve,decline:do_not_honor")
+
+	_, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.Error(t, err)
+
+	first, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.NoError(t, err)
+	require.True(t, first.Approved)
+
+	retry := chargeRequest("k1")
+	retry.Attempt = 2
+	replayed, err := exec.Execute(context.Background(), retry)
+	require.NoError(t, err)
+	require.Equal(t, first, replayed)
+	require.Equal(t, 1, fake.Charges())
+
+	changed := chargeRequest("k1")
+	changed.Amount.Amount = 2000
+	conflict, err := exec.Execute(context.Background(), changed)
+	require.NoError(t, err)
+	require.False(t, conflict.Approved)
+	require.False(t, conflict.Retryable)
+}
+
+func TestHTTPExecutorChargesConcurrentRequestsWithTheSameKeyOnce(t *testing.T) {
+	fake := fakepsp.New(fakepsp.Config{SuccessRate: 1, Latency: 50 * time.Millisecond, Seed: 1})
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	exec := psp.NewHTTPExecutor(server.URL, "", time.Second)
+
+	results := make([]worker.GatewayResult, 5)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Go(func() {
+			result, err := exec.Execute(context.Background(), chargeRequest("k1"))
+			require.NoError(t, err)
+			results[i] = result
+		})
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, fake.Charges())
+	for _, result := range results {
+		require.True(t, result.Approved)
+		require.Equal(t, results[0], result)
+	}
+}
+
+func TestHTTPExecutorRejectsWrongAPIKey(t *testing.T) {
+	exec, _ := newExecutor(t, "approve")
+	exec.APIKey = "wrong"
+
+	result, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.NoError(t, err)
+	require.False(t, result.Approved)
+	require.False(t, result.Retryable)
+}
+
+func refundRequest(key, chargeID string, amount int64) worker.RefundRequest {
+	return worker.RefundRequest{
+		RefundID:        key,
+		PaymentID:       "pay-1",
+		InvoiceID:       "inv-1",
+		Amount:          money.Money{Amount: amount, Currency: money.BRL},
+		ChargeReference: chargeID,
+		IdempotencyKey:  "refund:" + key,
+	}
+}
+
+func TestHTTPRefundExecutorRefundsUpToTheCharge(t *testing.T) {
+	exec, fake := newExecutor(t, "approve")
+	refunds := exec.Refunds()
+
+	charge, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.NoError(t, err)
+	require.True(t, charge.Approved)
+
+	partial, err := refunds.Execute(context.Background(), refundRequest("ref-1", charge.GatewayReference, 600))
+	require.NoError(t, err)
+	require.True(t, partial.Approved)
+	require.NotEmpty(t, partial.GatewayReference)
+
+	replayed, err := refunds.Execute(context.Background(), refundRequest("ref-1", charge.GatewayReference, 600))
+	require.NoError(t, err)
+	require.Equal(t, partial, replayed)
+
+	excess, err := refunds.Execute(context.Background(), refundRequest("ref-2", charge.GatewayReference, 600))
+	require.NoError(t, err)
+	require.False(t, excess.Approved)
+	require.NotEmpty(t, excess.Message)
+
+	unknown, err := refunds.Execute(context.Background(), refundRequest("ref-3", "ch_missing", 100))
+	require.NoError(t, err)
+	require.False(t, unknown.Approved)
+
+	require.Equal(t, 3, fake.Refunds())
+}
+
+func TestHTTPRefundExecutorReturnsErrorsForUnknownOutcomes(t *testing.T) {
+	exec, _ := newExecutor(t, "approve,error:503,approve")
+	refunds := exec.Refunds()
+
+	charge, err := exec.Execute(context.Background(), chargeRequest("k1"))
+	require.NoError(t, err)
+
+	_, err = refunds.Execute(context.Background(), refundRequest("ref-1", charge.GatewayReference, 1000))
+	require.Error(t, err)
+
+	retried, err := refunds.Execute(context.Background(), refundRequest("ref-1", charge.GatewayReference, 1000))
+	require.NoError(t, err)
+	require.True(t, retried.Approved)
+}
+
+func TestParseScriptRejectsUnknownOutcomes(t *testing.T) {
+	_, err := fakepsp.ParseScript("approve,explode")
+	require.Error(t, err)
+
+	_, err = fakepsp.ParseScript("error:200")
+	require.Error(t, err)
+}
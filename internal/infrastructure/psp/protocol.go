@@ -0,0 +1,44 @@
+package psp
+
+// ChargeRequest and ChargeResponse are the JSON bodies of POST /v1/charges.
+// The Idempotency-Key header must be sent with every charge.
+type ChargeRequest struct {
+	PaymentID     string `json:"payment_id"`
+	InvoiceID     string `json:"invoice_id"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	PaymentMethod string `json:"payment_method"`
+	Attempt       int    `json:"attempt"`
+}
+
+type ChargeResponse struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	DeclineCode string `json:"decline_code,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+// RefundRequest and RefundResponse are the JSON bodies of POST /v1/refunds,
+// which returns all or part of an approved charge. The Idempotency-Key
+// header must be sent with every refund.
+type RefundRequest struct {
+	RefundID string `json:"refund_id"`
+	ChargeID string `json:"charge_id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type RefundResponse struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	ChargesPath          = "/v1/charges"
+	RefundsPath          = "/v1/refunds"
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	StatusApproved = "approved"
+	StatusDeclined = "declined"
+)